@@ -1,33 +1,104 @@
 package access
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
-	"reflect"
+	"net/url"
 	"strings"
 	"time"
-
-	"github.com/boltdb/bolt"
-	"github.com/nilslice/jwt"
-
-	"github.com/ponzu-cms/ponzu/system/admin/user"
-	"github.com/ponzu-cms/ponzu/system/db"
 )
 
 const (
-	apiAccessStore      = "__apiAccess"
-	apiPendingUserStore = "__apiPending"
-	apiAccessCookie     = "_apiAccessToken"
+	apiAccessStore            = "__apiAccess"
+	apiPendingUserStore       = "__apiPending"
+	apiGroupStore             = "__apiGroups"
+	apiACLStore               = "__apiACL"
+	apiRateLimitStore         = "__apiRateLimit"
+	apiResetStore             = "__apiReset"
+	apiVerifyStore            = "__apiVerify"
+	apiGrantDataStore         = "__apiGrantData"
+	apiKeyStore               = "__apiKeys"
+	apiNonceStore             = "__apiNonce"
+	apiAuditStore             = "__apiAudit"
+	apiTokenVersionStore      = "__apiTokenVersion"
+	apiSessionStore           = "__apiSessions"
+	apiInviteStore            = "__apiInvite"
+	apiServiceCredentialStore = "__apiServiceCredentials"
+	apiRequestLimitStore      = "__apiRequestLimit"
+	apiUsageStore             = "__apiUsage"
+	apiAccessDeletedStore     = "__apiAccessDeleted"
+	apiAccessCookie           = "_apiAccessToken"
 )
 
 // APIAccess is the data for an API access grant
 type APIAccess struct {
-	Key   string `json:"key"`
-	Hash  string `json:"hash"`
-	Salt  string `json:"salt"`
-	Token string `json:"token"`
+	Version int      `json:"version"`
+	Key     string   `json:"key"`
+	Hash    string   `json:"hash"`
+	Salt    string   `json:"salt"`
+	Tenant  string   `json:"tenant,omitempty"`
+	Roles   []string `json:"roles,omitempty"`
+	Scopes  []string `json:"scopes,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	Token   string   `json:"token,omitempty"`
+	Admin   bool     `json:"admin,omitempty"`
+
+	// Networks, if set, are the CIDR ranges requests holding the grant's
+	// tokens must come from
+	Networks []string `json:"networks,omitempty"`
+
+	FailedLogins int       `json:"failed_logins,omitempty"`
+	LockedUntil  time.Time `json:"locked_until"`
+
+	// Metadata holds application data about the grant, such as its plan tier
+	// or customer ID. It is not included in tokens.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Tags label the grant's cohort, such as "partner" or "trial", and are set
+	// by TagGrant. They are not included in tokens.
+	Tags []string `json:"tags,omitempty"`
+
+	// ExpiresAt, if set, is when the grant expires, after which its logins and
+	// requests are rejected. Tokens issued for it expire by then.
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Disabled is set by Disable, and rejects every login and request for the
+	// grant until Enable is called
+	Disabled bool `json:"disabled,omitempty"`
+
+	// LastLoginAt is when a token was last issued for the grant, and
+	// LastSeenAt when it was last seen in a request, if SetLastSeenInterval
+	// is set
+	LastLoginAt time.Time `json:"last_login_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+
+	// ServiceAccount is set for machine clients created by
+	// CreateServiceAccount, which have no password
+	ServiceAccount bool `json:"service_account,omitempty"`
+
+	TOTPSecret   string `json:"totp_secret,omitempty"`
+	TOTPEnabled  bool   `json:"totp_enabled,omitempty"`
+	TOTPLastStep int64  `json:"totp_last_step,omitempty"`
+
+	// amr lists the methods used to authenticate for the token being issued
+	amr []string
+
+	// ver is the token version of the grant, which issued tokens carry as the
+	// "ver" claim
+	ver int
+
+	// csrf is the CSRF token of the token being issued, for cookie stores
+	csrf string
+
+	// jti is the session ID of the token being issued
+	jti string
+
+	// anon marks guests issued tokens by GrantGuest, which have no grant
+	anon bool
+
+	HashAlgorithm string `json:"hash_algorithm,omitempty"`
+	PepperVersion int    `json:"pepper_version,omitempty"`
 }
 
 // Config contains settings for token creation and validation
@@ -37,19 +108,130 @@ type Config struct {
 	TokenStore     reqHeaderOrHTTPCookie
 	CustomClaims   map[string]interface{}
 	SecureCookie   bool
+	TenantID       string
+	Scopes         []string
+
+	// Metadata, if set, replaces the metadata recorded on the grant by Grant
+	Metadata map[string]string
+
+	// Request, if set, is the request Login or Grant is handling, so attempts
+	// can be rate limited by source IP
+	Request *http.Request
+
+	// OTP is the one-time code Login requires for grants with TOTP enabled
+	OTP string
+
+	// CookieName, HeaderName and AuthScheme replace the default
+	// "_apiAccessToken" cookie, "Authorization" header and "Bearer" scheme, so
+	// apps sharing a domain or proxies reserving a header don't collide. Pass
+	// the Config as the tokenStore of IsGranted and the other checks to read
+	// tokens using them.
+	CookieName string
+	HeaderName string
+	AuthScheme string
+
+	// CookieSameSite, CookieDomain, CookiePath and CookieMaxAge set the
+	// attributes of the access cookie. SameSite defaults to Lax and Path to
+	// "/", and cookies with SameSite None are always Secure, as browsers
+	// require.
+	CookieSameSite http.SameSite
+	CookieDomain   string
+	CookiePath     string
+	CookieMaxAge   int
+
+	// CookiePrefix, if set, issues cookies under the __Host- or __Secure-
+	// prefix, enforcing the attributes browsers require of it
+	CookiePrefix CookiePrefix
+
+	// TokenSources, if set, are tried in order when reading a token through
+	// the Config, such as []interface{}{http.Header{}, http.Cookie{},
+	// QueryParam("")}, and the first holding a token is used. TokenStore is
+	// still the store Grant and Login write tokens to.
+	TokenSources []reqHeaderOrHTTPCookie
+
+	// SkipPaths, SkipMethods and Skip exempt requests from cfg.GateKeeper,
+	// such as public endpoints and CORS preflight OPTIONS requests, by path
+	// prefix, by method or by any other test. Exempt requests holding a valid
+	// token still have its identity stored in their context.
+	SkipPaths   []string
+	SkipMethods []string
+	Skip        func(req *http.Request) bool
+
+	// Authorizers, if set, replace DefaultAuthorizers as the checks letting a
+	// request through cfg.GateKeeper when no Policy decides it
+	Authorizers []Authorizer
+
+	// Realm, if set, is sent in the WWW-Authenticate challenge of requests
+	// rejected by cfg.GateKeeper
+	Realm string
+
+	// OnUnauthorized, if set, writes the response to requests rejected by
+	// cfg.GateKeeper, such as a JSON error or a redirect to a login page
+	OnUnauthorized func(res http.ResponseWriter, req *http.Request, reason Reason)
+
+	// OnDenied, if set, is called with every request rejected by
+	// cfg.GateKeeper, so operators can log or count rejections without the
+	// request's headers or cookies
+	OnDenied func(req *http.Request, denial Denial)
+
+	// Audience, if set, is added to tokens as the "aud" claim, and tokens
+	// checked through the Config must hold it, so tokens issued for one
+	// service aren't accepted by another
+	Audience string
+
+	// MaxSessions, if set, limits how many unexpired tokens a grant may hold
+	// at once. SessionLimit chooses whether a login beyond it evicts the
+	// oldest session or is rejected.
+	MaxSessions  int
+	SessionLimit SessionLimitAction
+
+	// Bind, if set, binds issued tokens to the client of Request, adding a
+	// hash of its IP prefix, User-Agent or both as the "bind" claim, so a
+	// token replayed by another client is rejected. Request is then required
+	// to issue tokens. Tokens checked without a request, by VerifyToken, are
+	// not held to their binding.
+	Bind Binding
+
+	// Signer, if set, signs the tokens issued through the Config and verifies
+	// the tokens checked through it in place of the Service's Signer, such as
+	// a NewPASETOSigner for one group of clients while others keep JWTs
+	Signer Signer
 }
 
 type reqHeaderOrHTTPCookie interface{}
 
-func init() {
-	db.AddBucket(apiAccessStore)
-	db.AddBucket(apiPendingUserStore)
-}
+// CookiePrefix is a cookie name prefix which browsers only accept on cookies
+// with certain attributes
+type CookiePrefix string
+
+const (
+	// HostPrefix limits cookies to the exact host which set them over HTTPS,
+	// requiring them to be Secure, with Path "/" and no Domain
+	HostPrefix CookiePrefix = "__Host-"
+
+	// SecurePrefix requires cookies to be Secure
+	SecurePrefix CookiePrefix = "__Secure-"
+)
+
+// QueryParam is a tokenStore reading tokens from the named URL query parameter,
+// or "access_token" if it is empty, for requests which can't carry headers or
+// cookies such as EventSource streams, iframe embeds and download links. Grant
+// and Login don't write tokens for a QueryParam, so add the returned token to
+// the URLs you hand out.
+type QueryParam string
 
 // Grant creates a new APIAccess and saves it to the __apiAccess bucket in the database
 // and if an existing APIAccess grant is encountered in the database, Grant attempts
-// to update the grant but will fail if unauthorized
+// to update the grant but will fail if unauthorized. The grant is saved and the key
+// removed from pending status in a single transaction, and the token is only
+// written to the response once it has been committed. If cfg.Scopes or
+// cfg.Metadata is set, it replaces the scopes or metadata recorded on the grant.
 func Grant(key, password string, cfg *Config) (*APIAccess, error) {
+	return std.Grant(key, password, cfg)
+}
+
+// Grant is the package Grant, keeping the grant in s's Store
+func (s *Service) Grant(key, password string, cfg *Config) (*APIAccess, error) {
 	if key == "" {
 		return nil, fmt.Errorf("%s", "key must not be empty")
 	}
@@ -58,66 +240,192 @@ func Grant(key, password string, cfg *Config) (*APIAccess, error) {
 		return nil, fmt.Errorf("%s", "password must not be empty")
 	}
 
-	u, err := user.New(key, password)
+	err := validateTenantID(cfg.TenantID)
 	if err != nil {
 		return nil, err
 	}
 
-	apiAccess := &APIAccess{
-		Key:  u.Email,
-		Hash: u.Hash,
-		Salt: u.Salt,
+	err = validateLocalKey(key, cfg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	storeKey := TenantKey(cfg.TenantID, key)
+	err = s.takeAttempt(cfg, storeKey)
+	if err != nil {
+		s.loginFailed(storeKey, cfg.Request, err)
+		return nil, err
 	}
 
-	err = apiAccess.setToken(cfg)
+	// the PasswordChecker may make network calls, and hashing is slow, so the
+	// passwords of new grants are checked and those of existing grants
+	// verified before the transaction
+	var exists bool
+	err = s.store.View(func(tx Tx) error {
+		j, err := tx.Get(apiAccessStore, storeKey)
+		exists = j != nil
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.Store().Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(apiAccessStore))
-		if b == nil {
-			return fmt.Errorf("failed to get bucket %s", apiAccessStore)
+	var verified *APIAccess
+	if exists {
+		verified, err = s.storedPassword(storeKey, password)
+		if err == ErrUnauthorized {
+			s.recordLoginFailure(storeKey)
+		}
+		if err != nil {
+			s.loginFailed(storeKey, cfg.Request, err)
+			return nil, err
+		}
+	} else {
+		err = s.checkNewPassword(password)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		if b.Get([]byte(apiAccess.Key)) != nil {
-			err := updateGrant(key, password, cfg)
+	hashed := &APIAccess{
+		Key:    key,
+		Tenant: cfg.TenantID,
+		amr:    []string{"pwd"},
+	}
+
+	err = s.hashPassword(hashed, password)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiAccess *APIAccess
+	var exp time.Time
+	var failed, existed bool
+	err = s.store.Update(func(tx Tx) error {
+		existing, err := tx.Get(apiAccessStore, storeKey)
+		if err != nil {
+			return err
+		}
+
+		existed = existing != nil
+
+		if existing == nil {
+			err = s.passwordPolicy.Check(password)
+			if err != nil {
+				return err
+			}
+		}
+
+		apiAccess = hashed
+		if existing != nil {
+			stored, err := s.updateGrant(tx, storeKey, verified, password)
 			if err != nil {
-				return fmt.Errorf("failed to update APIAccess grant for %s, %v", apiAccess.Key, err)
+				failed = err == ErrUnauthorized
+				return err
 			}
+
+			if stored.TOTPEnabled {
+				if !stored.checkTOTP(cfg.OTP, time.Now()) {
+					failed = cfg.OTP != ""
+					return ErrTOTPRequired
+				}
+
+				hashed.amr = append(hashed.amr, "otp")
+			}
+
+			stored.Hash = hashed.Hash
+			stored.Salt = hashed.Salt
+			stored.HashAlgorithm = hashed.HashAlgorithm
+			stored.PepperVersion = hashed.PepperVersion
+			stored.amr = hashed.amr
+			apiAccess = stored
+		}
+
+		if cfg.Scopes != nil {
+			apiAccess.Scopes = cfg.Scopes
+		}
+
+		if cfg.Metadata != nil {
+			apiAccess.Metadata = cfg.Metadata
 		}
 
-		j, err := json.Marshal(u)
+		apiAccess.LastLoginAt = time.Now()
+
+		apiAccess.Groups, err = groupsOf(tx, storeKey)
 		if err != nil {
-			return fmt.Errorf("failed to marshal APIAccess to json, %v", err)
+			return err
 		}
 
-		return b.Put([]byte(apiAccess.Key), j)
-	})
+		apiAccess.ver, err = tokenVersion(tx, storeKey)
+		if err != nil {
+			return err
+		}
+
+		exp, err = apiAccess.newToken(s.signer, cfg)
+		if err != nil {
+			return err
+		}
 
-	err = db.Store().Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(apiPendingUserStore))
-		if b == nil {
-			return fmt.Errorf("failed to get bucket %s", apiPendingUserStore)
+		err = startSession(tx, storeKey, apiAccess, cfg, exp)
+		if err != nil {
+			return err
+		}
+
+		err = putGrant(tx, apiAccess)
+		if err != nil {
+			return err
+		}
+
+		pending, err := tx.Get(apiPendingUserStore, storeKey)
+		if err != nil {
+			return err
 		}
 
-		if b.Get([]byte(apiAccess.Key)) != nil {
-			b.Delete([]byte(apiAccess.Key))
+		if pending != nil {
+			return tx.Delete(apiPendingUserStore, storeKey)
 		}
 
 		return nil
 	})
 
+	if failed {
+		s.recordLoginFailure(storeKey)
+	}
+
 	if err != nil {
+		if existed {
+			s.loginFailed(storeKey, cfg.Request, err)
+		}
+
 		return nil, err
 	}
 
+	// sessions evicted for cfg.MaxSessions are rejected at once
+	s.sessionStates.forget(storeKey)
+
+	err = apiAccess.writeToken(cfg, exp)
+	if err != nil {
+		return nil, err
+	}
+
+	if existed {
+		s.loggedIn(storeKey, cfg.Request, apiAccess.amr)
+	} else {
+		s.grantCreated(storeKey, cfg.Request)
+	}
+
 	return apiAccess, nil
 }
 
-// Login attempts
-// to update the grant but will fail if unauthorized
+// Login verifies the password of an existing grant and issues a new token,
+// transparently re-hashing the password if it was hashed with outdated
+// parameters. Login fails if unauthorized
 func Login(key, password string, cfg *Config) (*APIAccess, error) {
+	return std.Login(key, password, cfg)
+}
+
+// Login is the package Login for the grants in s's Store
+func (s *Service) Login(key, password string, cfg *Config) (*APIAccess, error) {
 	if key == "" {
 		return nil, fmt.Errorf("%s", "key must not be empty")
 	}
@@ -126,73 +434,242 @@ func Login(key, password string, cfg *Config) (*APIAccess, error) {
 		return nil, fmt.Errorf("%s", "password must not be empty")
 	}
 
-	u, err := user.New(key, password)
+	err := validateTenantID(cfg.TenantID)
 	if err != nil {
 		return nil, err
 	}
 
-	apiAccess := &APIAccess{
-		Key:  u.Email,
-		Hash: u.Hash,
-		Salt: u.Salt,
+	var apiAccess *APIAccess
+	var exp time.Time
+	var failed bool
+	storeKey := TenantKey(cfg.TenantID, key)
+	err = s.takeAttempt(cfg, storeKey)
+	if err != nil {
+		s.loginFailed(storeKey, cfg.Request, err)
+		return nil, err
 	}
 
-	err = apiAccess.setToken(cfg)
+	// passwords are checked before the transaction, by the CredentialVerifier
+	// as it makes network calls, and otherwise as hashing is slow
+	delegated, err := s.verifyCredentials(key, password, cfg)
+	var stored *APIAccess
+	if err == nil && !delegated {
+		stored, err = s.storedPassword(storeKey, password)
+		if errors.Is(err, ErrNotFound) {
+			err = ErrUnauthorized
+		}
+	}
 	if err != nil {
+		if err == ErrUnauthorized {
+			s.recordLoginFailure(storeKey)
+		}
+
+		s.loginFailed(storeKey, cfg.Request, err)
 		return nil, err
 	}
 
-	err = db.Store().Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(apiAccessStore))
-		if b == nil {
-			return fmt.Errorf("failed to get bucket %s", apiAccessStore)
+	var rehashed *APIAccess
+	if stored != nil && s.needsRehash(stored) {
+		rehashed = &APIAccess{}
+		err = s.hashPassword(rehashed, password)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		if b.Get([]byte(apiAccess.Key)) != nil {
-			err := updateGrant(key, password, cfg)
-			if err != nil {
-				return fmt.Errorf("failed to update APIAccess grant for %s, %v", apiAccess.Key, err)
+	err = s.store.Update(func(tx Tx) error {
+		existing, err := tx.Get(apiAccessStore, storeKey)
+		if err != nil {
+			return err
+		}
+
+		if existing == nil {
+			return ErrUnauthorized
+		}
+
+		if delegated {
+			// the grant must still have no password of its own
+			apiAccess, err = verifiedGrant(tx, storeKey, func(a *APIAccess) bool {
+				return a.Hash == "" && !a.ServiceAccount
+			})
+		} else {
+			apiAccess, err = s.updateGrant(tx, storeKey, stored, password)
+		}
+		if err != nil {
+			failed = err == ErrUnauthorized
+			return err
+		}
+
+		apiAccess.Groups, err = groupsOf(tx, storeKey)
+		if err != nil {
+			return err
+		}
+
+		apiAccess.ver, err = tokenVersion(tx, storeKey)
+		if err != nil {
+			return err
+		}
+
+		apiAccess.amr = []string{"pwd"}
+		if apiAccess.TOTPEnabled {
+			if !apiAccess.checkTOTP(cfg.OTP, time.Now()) {
+				failed = cfg.OTP != ""
+				return ErrTOTPRequired
 			}
-			return nil
+
+			apiAccess.amr = append(apiAccess.amr, "otp")
+		}
+
+		if rehashed != nil {
+			apiAccess.Hash = rehashed.Hash
+			apiAccess.Salt = rehashed.Salt
+			apiAccess.HashAlgorithm = rehashed.HashAlgorithm
+			apiAccess.PepperVersion = rehashed.PepperVersion
+		}
+
+		exp, err = apiAccess.newToken(s.signer, cfg)
+		if err != nil {
+			return err
 		}
 
-		return fmt.Errorf("%s", "User Not Authorized")
+		err = startSession(tx, storeKey, apiAccess, cfg, exp)
+		if err != nil {
+			return err
+		}
+
+		apiAccess.FailedLogins = 0
+		apiAccess.LastLoginAt = time.Now()
+		return putGrant(tx, apiAccess)
 	})
 
+	if failed {
+		s.recordLoginFailure(storeKey)
+	}
+
+	if err != nil {
+		s.loginFailed(storeKey, cfg.Request, err)
+		return nil, err
+	}
+
+	s.sessionStates.forget(storeKey)
+
+	err = apiAccess.writeToken(cfg, exp)
 	if err != nil {
 		return nil, err
 	}
 
+	s.loggedIn(storeKey, cfg.Request, apiAccess.amr)
+	return apiAccess, nil
+}
+
+// IssueToken issues a new token for the existing grant for key without
+// checking its password, for callers which have authenticated its owner by
+// other means, such as a passkey or an identity provider. amr lists those
+// means for the token's "amr" claim.
+func IssueToken(key string, cfg *Config, amr ...string) (*APIAccess, error) {
+	return std.IssueToken(key, cfg, amr...)
+}
+
+// IssueToken is the package IssueToken for the grants in s's Store
+func (s *Service) IssueToken(key string, cfg *Config, amr ...string) (*APIAccess, error) {
+	if key == "" {
+		return nil, fmt.Errorf("%s", "key must not be empty")
+	}
+
+	err := validateTenantID(cfg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiAccess *APIAccess
+	var exp time.Time
+	storeKey := TenantKey(cfg.TenantID, key)
+	err = s.store.Update(func(tx Tx) error {
+		apiAccess, _, err = getGrant(tx, storeKey)
+		if err != nil {
+			return err
+		}
+
+		if apiAccess == nil {
+			return ErrUnauthorized
+		}
+
+		if time.Now().Before(apiAccess.LockedUntil) {
+			return ErrLocked
+		}
+
+		err = apiAccess.inactive(time.Now())
+		if err != nil {
+			return err
+		}
+
+		apiAccess.Groups, err = groupsOf(tx, storeKey)
+		if err != nil {
+			return err
+		}
+
+		apiAccess.ver, err = tokenVersion(tx, storeKey)
+		if err != nil {
+			return err
+		}
+
+		apiAccess.amr = amr
+		exp, err = apiAccess.newToken(s.signer, cfg)
+		if err != nil {
+			return err
+		}
+
+		err = startSession(tx, storeKey, apiAccess, cfg, exp)
+		if err != nil {
+			return err
+		}
+
+		apiAccess.LastLoginAt = time.Now()
+		return putGrant(tx, apiAccess)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.sessionStates.forget(storeKey)
+
+	err = apiAccess.writeToken(cfg, exp)
+	if err != nil {
+		return nil, err
+	}
+
+	s.loggedIn(storeKey, cfg.Request, amr)
 	return apiAccess, nil
 }
 
 // Check is to see if the user exists in either active or pending status
 func Check(key string) error {
+	return std.Check(key)
+}
+
+// Check is the package Check against s's Store
+func (s *Service) Check(key string) error {
 	if key == "" {
 		return fmt.Errorf("%s", "key must not be empty")
 	}
 
-	err := db.Store().View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(apiAccessStore))
-		if b == nil {
-			return fmt.Errorf("failed to get bucket %s", apiAccessStore)
+	err := s.store.View(func(tx Tx) error {
+		active, err := tx.Get(apiAccessStore, key)
+		if err != nil {
+			return err
 		}
 
-		if b.Get([]byte(key)) != nil {
-			return fmt.Errorf("%s", "email already actively in use")
+		if active != nil {
+			return ErrDuplicateKey
 		}
 
-		return nil
-	})
-
-	err = db.Store().View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(apiPendingUserStore))
-		if b == nil {
-			return fmt.Errorf("failed to get bucket %s", apiPendingUserStore)
+		pending, err := tx.Get(apiPendingUserStore, key)
+		if err != nil {
+			return err
 		}
 
-		if b.Get([]byte(key)) != nil {
-			return fmt.Errorf("%s", "email already pending in use")
+		if pending != nil && !isStalePending(pending) {
+			return ErrPending
 		}
 
 		return nil
@@ -207,21 +684,36 @@ func Check(key string) error {
 
 // Pending adds user to pending status to block possible duplicates
 func Pending(key string) error {
+	return std.Pending(key)
+}
+
+// Pending is the package Pending, keeping the pending user in s's Store
+func (s *Service) Pending(key string) error {
 	if key == "" {
 		return fmt.Errorf("Pending: %s", "key must not be empty")
 	}
 
-	err := db.Store().Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(apiPendingUserStore))
-		if b == nil {
-			return fmt.Errorf("Pending: failed to get bucket %s", apiPendingUserStore)
+	err := validateStoreKey(key)
+	if err != nil {
+		return fmt.Errorf("Pending: %v", err)
+	}
+
+	err = s.store.Update(func(tx Tx) error {
+		pending, err := tx.Get(apiPendingUserStore, key)
+		if err != nil {
+			return fmt.Errorf("Pending: %v", err)
 		}
 
-		if b.Get([]byte(key)) != nil {
-			return fmt.Errorf("Pending: %s", "email already in use")
+		if pending != nil && !isStalePending(pending) {
+			return fmt.Errorf("Pending: %w", ErrPending)
 		}
 
-		return b.Put([]byte(key), []byte("pending"))
+		rec, err := newPendingRecord()
+		if err != nil {
+			return fmt.Errorf("Pending: %v", err)
+		}
+
+		return tx.Put(apiPendingUserStore, key, rec)
 	})
 
 	if err != nil {
@@ -233,18 +725,23 @@ func Pending(key string) error {
 
 // ClearPending removes the user from pending status db
 func ClearPending(key string) error {
+	return std.ClearPending(key)
+}
+
+// ClearPending is the package ClearPending for s's Store
+func (s *Service) ClearPending(key string) error {
 	if key == "" {
 		return fmt.Errorf("Pending: %s", "key must not be empty")
 	}
 
-	err := db.Store().Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(apiPendingUserStore))
-		if b == nil {
-			return fmt.Errorf("Pending: failed to get bucket %s", apiPendingUserStore)
+	err := s.store.Update(func(tx Tx) error {
+		pending, err := tx.Get(apiPendingUserStore, key)
+		if err != nil {
+			return fmt.Errorf("Pending: %v", err)
 		}
 
-		if b.Get([]byte(key)) != nil {
-			b.Delete([]byte(key))
+		if pending != nil {
+			return tx.Delete(apiPendingUserStore, key)
 		}
 
 		return nil
@@ -257,20 +754,59 @@ func ClearPending(key string) error {
 	return nil
 }
 
-// ClearGrant removes the user from active status db
+// ClearGrant removes the user from active status db, and revokes every token
+// issued for it. The grant is kept in the __apiAccessDeleted bucket for
+// RestoreGrant until the retention period set by SetDeletedRetention passes.
 func ClearGrant(key string) error {
+	return std.ClearGrant(key)
+}
+
+// ClearGrant is the package ClearGrant for the grants in s's Store
+func (s *Service) ClearGrant(key string) error {
 	if key == "" {
 		return fmt.Errorf("Grant: %s", "key must not be empty")
 	}
 
-	err := db.Store().Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(apiAccessStore))
-		if b == nil {
-			return fmt.Errorf("Grant: failed to get bucket %s", apiAccessStore)
+	var removed bool
+	err := s.store.Update(func(tx Tx) error {
+		active, err := tx.Get(apiAccessStore, key)
+		if err != nil {
+			return fmt.Errorf("Grant: %v", err)
 		}
 
-		if b.Get([]byte(key)) != nil {
-			b.Delete([]byte(key))
+		removed = active != nil
+		if active != nil {
+			err := archiveGrant(tx, key, active)
+			if err != nil {
+				return err
+			}
+
+			err = tx.Delete(apiGroupStore, key)
+			if err != nil {
+				return err
+			}
+
+			err = tx.Delete(apiGrantDataStore, key)
+			if err != nil {
+				return err
+			}
+
+			err = clearAPIKeys(tx, key)
+			if err != nil {
+				return err
+			}
+
+			err = tx.Delete(apiServiceCredentialStore, key)
+			if err != nil {
+				return err
+			}
+
+			err = revokeTokens(tx, key)
+			if err != nil {
+				return err
+			}
+
+			return tx.Delete(apiAccessStore, key)
 		}
 
 		return nil
@@ -280,77 +816,215 @@ func ClearGrant(key string) error {
 		return err
 	}
 
+	if removed {
+		s.sessionStates.forget(key)
+		s.revoked(key)
+	}
+
 	return nil
 }
 
 // IsGranted checks if the user request is authenticated by the token held within
-// the provided tokenStore (should be a http.Cookie or http.Header)
+// the provided tokenStore (should be a http.Cookie or http.Header, or a *Config
+// to use its TokenStore with custom names), or by an API key or request
+// signature if it holds no token
 func IsGranted(req *http.Request, tokenStore reqHeaderOrHTTPCookie) bool {
-	token, err := getToken(req, tokenStore)
-	if err != nil {
-		log.Println("failed to get token to check API access grant")
-		return false
-	}
+	return std.IsGranted(req, tokenStore)
+}
 
-	return jwt.Passes(token)
+// IsGranted is the package IsGranted, verifying tokens with s's Signer
+func (s *Service) IsGranted(req *http.Request, tokenStore reqHeaderOrHTTPCookie) bool {
+	_, ok := s.grantedClaims(req, tokenStore)
+	return ok
 }
 
 // IsOwner validates the access token and checks the claims within the
-// authenticated request's JWT for the key key associated with the grant.
+// authenticated request's JWT for the key key associated with the grant. For
+// tenant grants, key should be the namespaced key returned by TenantKey. Keys
+// are compared in constant time, and tokens with malformed claims own nothing.
 func IsOwner(req *http.Request, tokenStore reqHeaderOrHTTPCookie, key string) bool {
-	token, err := getToken(req, tokenStore)
-	if err != nil {
-		log.Println("failed to get token to check API access owner")
+	return std.IsOwner(req, tokenStore, key)
+}
+
+// IsOwner is the package IsOwner, verifying tokens with s's Signer
+func (s *Service) IsOwner(req *http.Request, tokenStore reqHeaderOrHTTPCookie, key string) bool {
+	return s.IsOwnerFunc(req, tokenStore, func(claimKey string) bool {
+		return sameKey(claimKey, key)
+	})
+}
+
+// IsOwnerAny validates the access token and checks whether its grant is any
+// of keys, for resources shared by several grants such as a team's members
+func IsOwnerAny(req *http.Request, tokenStore reqHeaderOrHTTPCookie, keys ...string) bool {
+	return std.IsOwnerAny(req, tokenStore, keys...)
+}
+
+// IsOwnerAny is the package IsOwnerAny, verifying tokens with s's Signer
+func (s *Service) IsOwnerAny(req *http.Request, tokenStore reqHeaderOrHTTPCookie, keys ...string) bool {
+	return s.IsOwnerFunc(req, tokenStore, func(claimKey string) bool {
+		owner := false
+		for _, key := range keys {
+			if sameKey(claimKey, key) {
+				owner = true
+			}
+		}
+
+		return owner
+	})
+}
+
+// IsOwnerFunc validates the access token and reports whether match returns
+// true for the key of its grant. For tenant grants, claimKey is the namespaced
+// key returned by TenantKey.
+func IsOwnerFunc(req *http.Request, tokenStore reqHeaderOrHTTPCookie, match func(claimKey string) bool) bool {
+	return std.IsOwnerFunc(req, tokenStore, match)
+}
+
+// IsOwnerFunc is the package IsOwnerFunc, verifying tokens with s's Signer
+func (s *Service) IsOwnerFunc(req *http.Request, tokenStore reqHeaderOrHTTPCookie, match func(claimKey string) bool) bool {
+	claims, ok := s.grantedClaims(req, tokenStore)
+	if !ok {
 		return false
 	}
 
-	if !jwt.Passes(token) {
-		return false
+	return match(claimKey(claims))
+}
+
+// storedPassword checks password against the grant stored for key outside of
+// any transaction, as hashing is slow, and returns the grant it matches for
+// the caller's transaction to pass to updateGrant
+func (s *Service) storedPassword(key, password string) (*APIAccess, error) {
+	var a *APIAccess
+	err := s.store.View(func(tx Tx) error {
+		var err error
+		a, _, err = getGrant(tx, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	claims := jwt.GetClaims(token)
-	if claims["access"].(string) != key {
-		return false
+	if a == nil {
+		return nil, fmt.Errorf("failed to get access grant to update grant, %w", notFound(key))
+	}
+
+	if time.Now().Before(a.LockedUntil) {
+		return nil, ErrLocked
 	}
 
-	return true
+	ok, err := s.checkPassword(a, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+
+	return a, nil
 }
 
-func updateGrant(key, password string, cfg *Config) error {
-	apiAccess := new(APIAccess)
-	err := db.Store().View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(apiAccessStore))
-		if b == nil {
-			return fmt.Errorf("failed to get %s bucket to update grant", apiAccessStore)
+// updateGrant returns the grant stored for key if it still has the password
+// hash of verified, as returned by storedPassword, saving the record again if
+// it was migrated to a newer schema version. A hash changed since, such as by
+// another Login re-hashing the password, is checked against password again.
+func (s *Service) updateGrant(tx Tx, key string, verified *APIAccess, password string) (*APIAccess, error) {
+	var busy error
+	a, err := verifiedGrant(tx, key, func(a *APIAccess) bool {
+		if verified != nil && a.Hash == verified.Hash {
+			return true
 		}
 
-		j := b.Get([]byte(key))
-		fmt.Println("Raw DB Response:\n" + string(j) + "\nEnd Raw Response\n")
-		return json.Unmarshal(j, &apiAccess)
+		var ok bool
+		ok, busy = s.checkPassword(a, password)
+		return ok
 	})
+	if busy != nil {
+		return nil, busy
+	}
+
+	return a, err
+}
+
+// verifiedGrant returns the grant stored for key if verify accepts it and it
+// is neither locked nor inactive, saving the record again if it was migrated
+// to a newer schema version
+func verifiedGrant(tx Tx, key string, verify func(a *APIAccess) bool) (*APIAccess, error) {
+	apiAccess, upgraded, err := getGrant(tx, key)
+	if err == nil && apiAccess == nil {
+		err = notFound(key)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get access grant to update grant, %v", err)
+		return nil, fmt.Errorf("failed to get access grant to update grant, %w", err)
 	}
 
-	usr := &user.User{
-		Email: apiAccess.Key,
-		Hash:  apiAccess.Hash,
-		Salt:  apiAccess.Salt,
+	if time.Now().Before(apiAccess.LockedUntil) {
+		return nil, ErrLocked
 	}
 
-	if !user.IsUser(usr, password) {
-		return fmt.Errorf(
-			"unauthorized attempt to update grant for %s", apiAccess.Key,
-		)
+	if !verify(apiAccess) {
+		return nil, ErrUnauthorized
 	}
 
-	return nil
+	err = apiAccess.inactive(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if upgraded {
+		err = putGrant(tx, apiAccess)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return apiAccess, nil
 }
 
 func getToken(req *http.Request, tokenStore reqHeaderOrHTTPCookie) (string, error) {
-	switch tokenStore.(type) {
+	token, _, err := findToken(req, tokenStore)
+	return token, err
+}
+
+// findToken returns the token held within tokenStore and the source it was read
+// from. For a *Config with TokenSources, the sources are tried in order and the
+// first holding a token is used.
+func findToken(req *http.Request, tokenStore reqHeaderOrHTTPCookie) (string, reqHeaderOrHTTPCookie, error) {
+	cfg, ok := tokenStore.(*Config)
+	if !ok {
+		cfg = &Config{TokenStore: tokenStore}
+	}
+
+	if len(cfg.TokenSources) == 0 {
+		token, err := readToken(req, cfg)
+		return token, cfg.TokenStore, err
+	}
+
+	for _, source := range cfg.TokenSources {
+		src := *cfg
+		src.TokenStore = source
+
+		token, err := readToken(req, &src)
+		if err == http.ErrNoCookie {
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+
+		if token != "" {
+			return token, source, nil
+		}
+	}
+
+	return "", nil, fmt.Errorf("%s", "no token found in any token source")
+}
+
+// readToken returns the token held within cfg.TokenStore
+func readToken(req *http.Request, cfg *Config) (string, error) {
+	switch cfg.TokenStore.(type) {
 	case http.Cookie:
-		cookie, err := req.Cookie(apiAccessCookie)
+		cookie, err := req.Cookie(cfg.cookieName())
 		if err != nil {
 			return "", err
 		}
@@ -358,24 +1032,114 @@ func getToken(req *http.Request, tokenStore reqHeaderOrHTTPCookie) (string, erro
 		return cookie.Value, nil
 
 	case http.Header:
-		bearer := req.Header.Get("Authorization")
-		return strings.TrimPrefix(bearer, "Bearer "), nil
+		bearer := req.Header.Get(cfg.headerName())
+		return strings.TrimPrefix(bearer, cfg.authScheme()+" "), nil
+
+	case QueryParam:
+		return queryValue(req.URL.RawQuery, cfg.queryParam()), nil
 
 	default:
 		return "", fmt.Errorf("%s", "unrecognized token store")
 	}
 }
 
-func (a *APIAccess) setToken(cfg *Config) error {
+// queryValue returns the first value of name in the raw query, as
+// url.Values.Get does, without parsing every other parameter into a map
+func queryValue(rawQuery, name string) string {
+	for rawQuery != "" {
+		var param string
+		param, rawQuery, _ = strings.Cut(rawQuery, "&")
+
+		k, v, _ := strings.Cut(param, "=")
+		if strings.Contains(k, ";") {
+			// rejected by url.ParseQuery
+			continue
+		}
+
+		if k != name {
+			if !strings.ContainsAny(k, "%+") {
+				continue
+			}
+
+			unescaped, err := url.QueryUnescape(k)
+			if err != nil || unescaped != name {
+				continue
+			}
+		}
+
+		if !strings.ContainsAny(v, "%+") {
+			return v
+		}
+
+		value, err := url.QueryUnescape(v)
+		if err != nil {
+			continue
+		}
+
+		return value
+	}
+
+	return ""
+}
+
+// newToken signs a new token for the grant with cfg.Signer, or signer if it
+// has none, and returns its expiry, without writing it to the response
+func (a *APIAccess) newToken(signer Signer, cfg *Config) (time.Time, error) {
+	if cfg.Signer != nil {
+		signer = cfg.Signer
+	}
+
+	switch cfg.TokenStore.(type) {
+	case http.Header, http.Cookie, QueryParam:
+	default:
+		return time.Time{}, fmt.Errorf("%s", "unrecognized token store")
+	}
+
 	exp := time.Now().Add(cfg.ExpireAfter)
-	claims := map[string]interface{}{
-		"exp":    exp.Unix(),
-		"access": a.Key,
+	if !a.ExpiresAt.IsZero() && a.ExpiresAt.Before(exp) {
+		exp = a.ExpiresAt
+	}
+
+	claims := a.claims()
+	claims["exp"] = exp.Unix()
+
+	if cfg.Audience != "" {
+		claims["aud"] = cfg.Audience
+	}
+
+	if a.ver > 0 {
+		claims["ver"] = a.ver
+	}
+
+	if cfg.Bind != 0 {
+		if cfg.Request == nil {
+			return time.Time{}, fmt.Errorf("%s", "a Request is required to bind tokens to the client")
+		}
+
+		claims["bind"] = bindClaim(cfg.Request, cfg.Bind)
+	}
+
+	jti, err := newSessionID()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	claims["jti"] = jti
+	a.jti = jti
+
+	if _, ok := cfg.TokenStore.(http.Cookie); ok {
+		csrf, err := newCSRFToken()
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		claims["csrf"] = csrf
+		a.csrf = csrf
 	}
 
 	for k, v := range cfg.CustomClaims {
-		if _, ok := claims[k]; ok {
-			return fmt.Errorf(
+		if _, ok := claims[k]; ok || isReservedClaim(k) {
+			return time.Time{}, fmt.Errorf(
 				"custom Config claim [%s] collides with internal claim [%s], %s",
 				k, k, "please rename custom claim",
 			)
@@ -384,26 +1148,85 @@ func (a *APIAccess) setToken(cfg *Config) error {
 		claims[k] = v
 	}
 
-	token, err := jwt.New(claims)
+	token, err := signer.Sign(claims)
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
 
 	a.Token = token
+	return exp, nil
+}
+
+// claims returns the claims describing the grant, which every token issued for
+// it holds
+func (a *APIAccess) claims() map[string]interface{} {
+	claims := map[string]interface{}{
+		"access": a.Key,
+	}
+
+	if a.Tenant != "" {
+		claims["tenant"] = a.Tenant
+	}
 
+	if len(a.Roles) > 0 {
+		claims["roles"] = a.Roles
+	}
+
+	if len(a.Scopes) > 0 {
+		claims["scopes"] = a.Scopes
+	}
+
+	if len(a.Groups) > 0 {
+		claims["groups"] = a.Groups
+	}
+
+	if a.Admin {
+		claims["admin"] = true
+	}
+
+	if len(a.Networks) > 0 {
+		claims["networks"] = a.Networks
+	}
+
+	if len(a.amr) > 0 {
+		claims["amr"] = a.amr
+	}
+
+	if a.anon {
+		claims["anon"] = true
+	}
+
+	if a.ServiceAccount {
+		claims["svc"] = true
+	}
+
+	return claims
+}
+
+// isReservedClaim reports whether name is an internal claim which is only set
+// when the grant holds it, so custom claims can't stand in for it
+func isReservedClaim(name string) bool {
+	switch name {
+	case "tenant", "roles", "scopes", "groups", "admin", "networks", "csrf", "amr", "aud", "ver", "jti", "anon", "svc", "chain", "bind":
+		return true
+	}
+
+	return false
+}
+
+// writeToken adds the grant's token to the response via the configured store
+func (a *APIAccess) writeToken(cfg *Config, exp time.Time) error {
 	switch cfg.TokenStore.(type) {
 	case http.Header:
-		cfg.ResponseWriter.Header().Add("Authorization", "Bearer "+token)
+		cfg.ResponseWriter.Header().Add(cfg.headerName(), cfg.authScheme()+" "+a.Token)
 
 	case http.Cookie:
-		http.SetCookie(cfg.ResponseWriter, &http.Cookie{
-			Name:     apiAccessCookie,
-			Value:    token,
-			Expires:  exp,
-			Path:     "/",
-			HttpOnly: true,
-			Secure:   cfg.SecureCookie,
-		})
+		cookie := cfg.cookie(a.Token, exp)
+		http.SetCookie(cfg.ResponseWriter, cookie)
+		cfg.writeCSRFCookie(a.csrf, cookie)
+
+	case QueryParam:
+		// tokens are added to URLs by the caller
 
 	default:
 		return fmt.Errorf("%s", "unrecognized token store")
@@ -412,26 +1235,367 @@ func (a *APIAccess) setToken(cfg *Config) error {
 	return nil
 }
 
-// GateKeeper is the auth HandlerFunc, because we cannot use item.Hideable for our data without blocking references from other items
+// Reason describes why GateKeeper rejected a request
+type Reason string
+
+const (
+	// ReasonNoToken is given for requests without a token
+	ReasonNoToken Reason = "no_token"
+
+	// ReasonInvalidToken is given for requests whose token is expired or
+	// doesn't pass verification
+	ReasonInvalidToken Reason = "invalid_token"
+
+	// ReasonDenied is given for requests with a valid token which a Policy
+	// denied
+	ReasonDenied Reason = "denied"
+
+	// ReasonCSRF is given for state-changing requests authenticated by the
+	// access cookie without its CSRF token
+	ReasonCSRF Reason = "csrf"
+
+	// ReasonExpired, ReasonBadSignature, ReasonWrongAudience and
+	// ReasonWrongClient tell apart invalid tokens for CheckRequest, and are
+	// given by GateKeeper as ReasonInvalidToken
+	ReasonExpired       Reason = "expired_token"
+	ReasonBadSignature  Reason = "bad_signature"
+	ReasonWrongAudience Reason = "wrong_audience"
+	ReasonWrongClient   Reason = "wrong_client"
+
+	// ReasonRevoked is given by CheckRequest for tokens which have been
+	// revoked, such as by a password change, or whose grant has been removed,
+	// and by GateKeeper as ReasonInvalidToken
+	ReasonRevoked Reason = "revoked"
+)
+
+// Denial describes a request rejected by GateKeeper, holding only fields which
+// are safe to log
+type Denial struct {
+	Reason     Reason
+	Method     string
+	Path       string
+	RemoteAddr string
+
+	// Key is the grant of a valid token denied by a Policy, namespaced by
+	// TenantKey for tenant grants
+	Key string
+}
+
+// GateKeeper is the auth HandlerFunc, because we cannot use item.Hideable for our data without blocking references from other items.
+// Policies set with UsePolicy are consulted before the Authorizers, which by default check for a valid token or admin user
+// The identity of a valid token is stored in the request context, for next to read with FromContext
 func GateKeeper(next http.HandlerFunc) http.HandlerFunc {
+	return (&Config{}).GateKeeper(next)
+}
+
+// GateKeeper is the package GateKeeper, reading tokens from cfg.TokenSources
+// or cfg.TokenStore if either is set, reporting rejected requests to
+// cfg.OnDenied and answering them with cfg.OnUnauthorized if it is set
+func (cfg *Config) GateKeeper(next http.HandlerFunc) http.HandlerFunc {
+	return std.gateKeeper(cfg, next)
+}
+
+// GateKeeper is the package GateKeeper for the grants of s, using its default
+// Config
+func (s *Service) GateKeeper(next http.HandlerFunc) http.HandlerFunc {
+	return s.gateKeeper(&s.config, next)
+}
+
+// Middleware is s.GateKeeper for an http.Handler
+func (s *Service) Middleware(next http.Handler) http.Handler {
+	return s.GateKeeper(next.ServeHTTP)
+}
+
+func (s *Service) gateKeeper(cfg *Config, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-		if IsGranted(req, req.Header) || user.IsValid(req) || trimPortFromAddress(req.RemoteAddr) == db.ConfigCache("bind_addr").(string) {
+		var identity *Identity
+		claims, source, reason := s.gateClaims(cfg, req)
+		granted := reason == ""
+		if granted {
+			identity = identityFromClaims(claims)
+			identity.Source = source
+			req = WithIdentity(req, identity)
+		}
+
+		if cfg.skip(req) {
 			next.ServeHTTP(res, req)
-		} else {
-			res.WriteHeader(http.StatusUnauthorized)
-			res.Write([]byte("Please login first..."))
-			fmt.Println("Request:")
-			s := reflect.ValueOf(req).Elem()
-			for i := 0; i < s.NumField(); i++ {
-				fmt.Printf("%s: %s\n", s.Type().Field(i).Name, fmt.Sprint(s.Field(i).Interface()))
+			return
+		}
+
+		decision := Abstain
+		if s.policy != nil {
+			decision = s.policy.Allow(req, identity)
+		}
+
+		switch decision {
+		case Allow:
+			next.ServeHTTP(res, req)
+			return
+
+		case Deny:
+			if identity != nil {
+				s.unauthorized(cfg, res, req, ReasonDenied)
+				return
+			}
+
+		default:
+			if cfg.authorized(req, identity) {
+				next.ServeHTTP(res, req)
+				return
+			}
+
+			if identity != nil {
+				reason = ReasonDenied
 			}
 		}
+
+		s.unauthorized(cfg, res, req, reason)
 	})
 }
 
-func trimPortFromAddress(s string) string {
-	if idx := strings.Index(s, ":"); idx != -1 {
-		return s[:idx]
+// skip reports whether req is exempt from cfg.GateKeeper
+func (cfg *Config) skip(req *http.Request) bool {
+	for _, method := range cfg.SkipMethods {
+		if req.Method == method {
+			return true
+		}
+	}
+
+	for _, prefix := range cfg.SkipPaths {
+		if strings.HasPrefix(req.URL.Path, prefix) {
+			return true
+		}
+	}
+
+	return cfg.Skip != nil && cfg.Skip(req)
+}
+
+// unauthorized writes the response to a request GateKeeper rejected
+func (s *Service) unauthorized(cfg *Config, res http.ResponseWriter, req *http.Request, reason Reason) {
+	denial := Denial{
+		Reason:     reason,
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		RemoteAddr: req.RemoteAddr,
+	}
+
+	if identity, ok := FromContext(req.Context()); ok {
+		denial.Key = TenantKey(identity.Tenant, identity.Key)
+	}
+
+	s.denied(req, denial)
+	if cfg.OnDenied != nil {
+		cfg.OnDenied(req, denial)
+	}
+
+	res.Header().Set("WWW-Authenticate", cfg.bearerChallenge(reason))
+
+	if cfg.OnUnauthorized != nil {
+		cfg.OnUnauthorized(res, req, reason)
+		return
+	}
+
+	if reason == ReasonDenied || reason == ReasonCSRF {
+		res.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	res.WriteHeader(http.StatusUnauthorized)
+	res.Write([]byte("Please login first..."))
+}
+
+// bearerChallenge returns the WWW-Authenticate challenge for a rejected
+// request, as described by RFC 6750
+func (cfg *Config) bearerChallenge(reason Reason) string {
+	var params []string
+	if cfg.Realm != "" {
+		params = append(params, "realm="+quoteAuthParam(cfg.Realm))
+	}
+
+	switch reason {
+	case ReasonInvalidToken:
+		params = append(params,
+			`error="invalid_token"`,
+			`error_description="The access token is expired or invalid"`,
+		)
+
+	case ReasonDenied:
+		params = append(params,
+			`error="insufficient_scope"`,
+			`error_description="The access token does not permit this request"`,
+		)
+	}
+
+	if len(params) == 0 {
+		return cfg.authScheme()
+	}
+
+	return cfg.authScheme() + " " + strings.Join(params, ", ")
+}
+
+// cookie returns the access cookie holding token
+func (cfg *Config) cookie(token string, exp time.Time) *http.Cookie {
+	path := cfg.CookiePath
+	if path == "" {
+		path = "/"
+	}
+
+	sameSite := cfg.CookieSameSite
+	if sameSite == 0 {
+		sameSite = http.SameSiteLaxMode
+	}
+
+	cookie := &http.Cookie{
+		Name:     cfg.cookieName(),
+		Value:    token,
+		Expires:  exp,
+		MaxAge:   cfg.CookieMaxAge,
+		Path:     path,
+		Domain:   cfg.CookieDomain,
+		HttpOnly: true,
+		Secure:   cfg.SecureCookie || sameSite == http.SameSiteNoneMode,
+		SameSite: sameSite,
+	}
+
+	switch cfg.CookiePrefix {
+	case HostPrefix:
+		cookie.Secure = true
+		cookie.Path = "/"
+		cookie.Domain = ""
+
+	case SecurePrefix:
+		cookie.Secure = true
+	}
+
+	return cookie
+}
+
+func (cfg *Config) cookieName() string {
+	if cfg.CookieName != "" {
+		return string(cfg.CookiePrefix) + cfg.CookieName
+	}
+
+	return string(cfg.CookiePrefix) + apiAccessCookie
+}
+
+func (cfg *Config) queryParam() string {
+	if name, _ := cfg.TokenStore.(QueryParam); name != "" {
+		return string(name)
+	}
+
+	return "access_token"
+}
+
+func (cfg *Config) headerName() string {
+	if cfg.HeaderName != "" {
+		return cfg.HeaderName
+	}
+
+	return "Authorization"
+}
+
+func (cfg *Config) authScheme() string {
+	if cfg.AuthScheme != "" {
+		return cfg.AuthScheme
+	}
+
+	return "Bearer"
+}
+
+func quoteAuthParam(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// gateClaims returns the claims of a valid token as checkClaims does, with the
+// reasons tokens fail verification reported as ReasonInvalidToken
+func (s *Service) gateClaims(cfg *Config, req *http.Request) (map[string]interface{}, reqHeaderOrHTTPCookie, Reason) {
+	claims, source, reason := s.checkClaims(cfg, req)
+	switch reason {
+	case ReasonExpired, ReasonBadSignature, ReasonWrongAudience, ReasonWrongClient, ReasonRevoked:
+		reason = ReasonInvalidToken
+	}
+
+	return claims, source, reason
+}
+
+// checkClaims returns the claims of a valid token held within cfg.TokenSources
+// or cfg.TokenStore, or the Authorization header if neither is set, along with
+// the source it was read from, or the reason there are none. Requests without
+// a token may instead send an API key or be signed.
+func (s *Service) checkClaims(cfg *Config, req *http.Request) (map[string]interface{}, reqHeaderOrHTTPCookie, Reason) {
+	ts := *cfg
+	if ts.TokenStore == nil && len(ts.TokenSources) == 0 {
+		ts.TokenStore = http.Header{}
 	}
-	return s
+
+	token, source, err := findToken(req, &ts)
+	if err != nil || token == "" {
+		claims, source, ok := s.credentialClaims(req)
+		if source == nil {
+			return nil, nil, ReasonNoToken
+		}
+
+		if !ok {
+			return nil, source, ReasonInvalidToken
+		}
+
+		if !fromAllowedNetwork(req, claims) {
+			return nil, source, ReasonDenied
+		}
+
+		s.markSeen(claims)
+		return claims, source, ""
+	}
+
+	claims, ok := s.tokenClaims(token, cfg.Signer)
+	if !ok {
+		return nil, source, s.tokenFailure(token, cfg.Signer)
+	}
+
+	if cfg.Audience != "" && !hasAudience(claims, cfg.Audience) {
+		return nil, source, ReasonWrongAudience
+	}
+
+	if !boundToClient(req, claims) {
+		return nil, source, ReasonWrongClient
+	}
+
+	if _, ok := source.(http.Cookie); ok && !validCSRF(req, claims) {
+		return nil, source, ReasonCSRF
+	}
+
+	if !fromAllowedNetwork(req, claims) {
+		return nil, source, ReasonDenied
+	}
+
+	s.markSeen(claims)
+	return claims, source, ""
+}
+
+// credentialClaims returns the claims of an API key sent in the X-API-Key header
+// or of a signed request, for requests without a token, along with the header
+// holding it. The source is nil if the request holds neither.
+func (s *Service) credentialClaims(req *http.Request) (map[string]interface{}, reqHeaderOrHTTPCookie, bool) {
+	if secret := req.Header.Get(APIKeyHeader); secret != "" {
+		claims, ok := s.apiKeyClaims(secret)
+		return claims, APIKeyHeader, ok
+	}
+
+	if req.Header.Get(SignatureHeader) != "" {
+		claims, ok := s.signedClaims(req)
+		return claims, SignatureHeader, ok
+	}
+
+	return nil, nil, false
+}
+
+// Middleware is GateKeeper for an http.Handler, so it composes with the
+// middleware chains of routers such as chi and gorilla/mux
+func Middleware(next http.Handler) http.Handler {
+	return GateKeeper(next.ServeHTTP)
+}
+
+// Middleware is cfg.GateKeeper for an http.Handler
+func (cfg *Config) Middleware(next http.Handler) http.Handler {
+	return cfg.GateKeeper(next.ServeHTTP)
 }
@@ -0,0 +1,41 @@
+package access_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+func BenchmarkIsGranted(b *testing.B) {
+	accesstest.UseMemoryStore(b)
+
+	req := accesstest.Request(http.MethodGet, "/", accesstest.Token(b, "bench@example.com"))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !access.IsGranted(req, http.Header{}) {
+			b.Fatal("token rejected")
+		}
+	}
+}
+
+func BenchmarkGateKeeper(b *testing.B) {
+	accesstest.UseMemoryStore(b)
+
+	req := accesstest.Request(http.MethodGet, "/", accesstest.Token(b, "bench@example.com"))
+	handler := access.GateKeeper(func(res http.ResponseWriter, req *http.Request) {})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		res := httptest.NewRecorder()
+		handler(res, req)
+		if res.Code != http.StatusOK {
+			b.Fatalf("got status %d", res.Code)
+		}
+	}
+}
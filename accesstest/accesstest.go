@@ -0,0 +1,200 @@
+// Package accesstest helps test handlers protected by the access package. It
+// runs the package against an in-memory store, mints valid, expired and
+// malformed tokens for grants it creates, and builds requests carrying them, so
+// tests need neither bolt nor real credentials.
+package accesstest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nilslice/access"
+)
+
+// Password is the password of the grants created by Token
+const Password = "accesstest-Passw0rd-not-for-production"
+
+// UseMemoryStore makes the access package keep its records in a new in-memory
+// store, and hash passwords with bcrypt at the minimum cost, until the end of
+// t. The store is returned for tests to inspect.
+func UseMemoryStore(t testing.TB) *access.MemoryStore {
+	t.Helper()
+
+	store := access.NewMemoryStore()
+	access.UseStore(store)
+	access.UseHasher(access.BcryptHasher{Cost: bcrypt.MinCost})
+
+	t.Cleanup(func() {
+		access.UseStore(nil)
+		access.UseHasher(nil)
+	})
+
+	return store
+}
+
+// Option sets what a token minted by Token or ExpiredToken holds
+type Option func(o *options)
+
+type options struct {
+	tenant string
+	roles  []string
+	scopes []string
+	groups []string
+	admin  bool
+	claims map[string]interface{}
+}
+
+// WithTenant mints the token for a grant of tenant
+func WithTenant(tenant string) Option {
+	return func(o *options) {
+		o.tenant = tenant
+	}
+}
+
+// WithRoles gives the grant roles
+func WithRoles(roles ...string) Option {
+	return func(o *options) {
+		o.roles = roles
+	}
+}
+
+// WithScopes gives the grant scopes
+func WithScopes(scopes ...string) Option {
+	return func(o *options) {
+		o.scopes = scopes
+	}
+}
+
+// WithGroups adds the grant to groups
+func WithGroups(groups ...string) Option {
+	return func(o *options) {
+		o.groups = groups
+	}
+}
+
+// WithAdmin makes the grant an admin
+func WithAdmin() Option {
+	return func(o *options) {
+		o.admin = true
+	}
+}
+
+// WithClaims adds custom claims to the token
+func WithClaims(claims map[string]interface{}) Option {
+	return func(o *options) {
+		o.claims = claims
+	}
+}
+
+// Token returns a token valid for an hour for the grant for key, creating the
+// grant with Password if it doesn't exist and giving it exactly the roles,
+// scopes and admin flag of opts. Call UseMemoryStore first.
+func Token(t testing.TB, key string, opts ...Option) string {
+	t.Helper()
+	return token(t, key, time.Hour, opts)
+}
+
+// ExpiredToken returns a token for the grant for key which expired a minute
+// ago, creating the grant with Password if it doesn't exist
+func ExpiredToken(t testing.TB, key string, opts ...Option) string {
+	t.Helper()
+	return token(t, key, -time.Minute, opts)
+}
+
+// MalformedToken returns a token which can't be parsed
+func MalformedToken() string {
+	return "malformed.access.token"
+}
+
+// TamperedToken returns token with its signature altered, so it fails
+// verification although its claims are intact
+func TamperedToken(token string) string {
+	i := strings.LastIndex(token, ".")
+	if i < 0 || i == len(token)-1 {
+		return token + "x"
+	}
+
+	sig := []byte(token[i+1:])
+	if sig[0] == 'A' {
+		sig[0] = 'B'
+	} else {
+		sig[0] = 'A'
+	}
+
+	return token[:i+1] + string(sig)
+}
+
+// Request returns a request for target carrying token in the Authorization
+// header, as GateKeeper reads it by default
+func Request(method, target, token string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// CookieRequest returns a request for target carrying token in the access
+// cookie. State-changing methods also need the token's CSRF token, which
+// cookie-authenticated requests must send.
+func CookieRequest(method, target, token string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	req.AddCookie(&http.Cookie{Name: "_apiAccessToken", Value: token})
+	return req
+}
+
+func token(t testing.TB, key string, expireAfter time.Duration, opts []Option) string {
+	t.Helper()
+
+	o := options{scopes: []string{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// Grant creates the grant, or updates an existing one, replacing its
+	// scopes with those given
+	_, err := access.Grant(key, Password, &access.Config{
+		ExpireAfter:    time.Minute,
+		ResponseWriter: httptest.NewRecorder(),
+		TokenStore:     http.Header{},
+		TenantID:       o.tenant,
+		Scopes:         o.scopes,
+	})
+	if err != nil {
+		t.Fatalf("accesstest: failed to grant %s, %v", key, err)
+	}
+
+	storeKey := access.TenantKey(o.tenant, key)
+	err = access.SetRoles(storeKey, o.roles...)
+	if err != nil {
+		t.Fatalf("accesstest: failed to set roles of %s, %v", key, err)
+	}
+
+	err = access.SetAdmin(storeKey, o.admin)
+	if err != nil {
+		t.Fatalf("accesstest: failed to set admin of %s, %v", key, err)
+	}
+
+	for _, group := range o.groups {
+		err = access.AddToGroup(group, storeKey)
+		if err != nil {
+			t.Fatalf("accesstest: failed to add %s to group %s, %v", key, group, err)
+		}
+	}
+
+	a, err := access.IssueToken(key, &access.Config{
+		ExpireAfter:    expireAfter,
+		ResponseWriter: httptest.NewRecorder(),
+		TokenStore:     http.Header{},
+		TenantID:       o.tenant,
+		CustomClaims:   o.claims,
+	}, "pwd")
+	if err != nil {
+		t.Fatalf("accesstest: failed to issue token for %s, %v", key, err)
+	}
+
+	return a.Token
+}
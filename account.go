@@ -0,0 +1,221 @@
+package access
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AccountHandler returns a handler for self-service accounts, to mount at
+// prefix, such as "/api". It handles POST requests with form values:
+//
+//	prefix/signup   key, password   create a grant
+//	prefix/verify   token           verify a key and create its grant
+//	prefix/login    key, password   issue a token, with otp for TOTP grants
+//	prefix/logout                   revoke the request's token
+//	prefix/refresh                  exchange the request's token for a new one
+//
+// If a Mailer has been set, signup adds the key to pending status with
+// PendingWithVerification and responds 202 Accepted, and the grant is created
+// when the emailed token is sent to verify. Otherwise signup creates the grant
+// at once. Tokens are issued with defaults, whose TokenStore must be an
+// http.Header or http.Cookie, and the requests to logout and refresh must hold
+// one in it. Successful requests are answered with 204 No Content, or 201
+// Created for new grants, and failed ones with a status and message describing
+// why.
+func AccountHandler(prefix string, defaults *Config) http.Handler {
+	return std.AccountHandler(prefix, defaults)
+}
+
+// AccountHandler is the package AccountHandler for the grants in s's Store
+func (s *Service) AccountHandler(prefix string, defaults *Config) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	h := accountHandler{s: s, defaults: *defaults}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(prefix+"/signup", postOnly(h.signup))
+	mux.HandleFunc(prefix+"/verify", postOnly(h.verify))
+	mux.HandleFunc(prefix+"/login", postOnly(h.login))
+	mux.HandleFunc(prefix+"/logout", postOnly(h.logout))
+	mux.HandleFunc(prefix+"/refresh", postOnly(h.refresh))
+	return mux
+}
+
+type accountHandler struct {
+	s        *Service
+	defaults Config
+}
+
+// config returns the Config to issue tokens for req with
+func (h accountHandler) config(res http.ResponseWriter, req *http.Request) *Config {
+	cfg := h.defaults
+	cfg.ResponseWriter = res
+	cfg.Request = req
+	return &cfg
+}
+
+func (h accountHandler) signup(res http.ResponseWriter, req *http.Request) {
+	key := req.PostFormValue("key")
+	password := req.PostFormValue("password")
+	cfg := h.config(res, req)
+
+	err := validateLocalKey(key, cfg.TenantID)
+	if err != nil {
+		h.s.writeError(res, err)
+		return
+	}
+
+	if h.s.mailer != nil {
+		_, err := h.s.PendingWithVerification(key, password, cfg)
+		if err != nil {
+			h.s.writeError(res, err)
+			return
+		}
+
+		res.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	storeKey := TenantKey(cfg.TenantID, key)
+	err = h.s.Check(storeKey)
+	if err != nil {
+		h.s.writeError(res, err)
+		return
+	}
+
+	err = h.s.Pending(storeKey)
+	if err != nil {
+		h.s.writeError(res, err)
+		return
+	}
+
+	_, err = h.s.Grant(key, password, cfg)
+	if err != nil {
+		h.s.ClearPending(storeKey)
+		h.s.writeError(res, err)
+		return
+	}
+
+	res.WriteHeader(http.StatusCreated)
+}
+
+func (h accountHandler) verify(res http.ResponseWriter, req *http.Request) {
+	_, err := h.s.Verify(req.PostFormValue("token"), h.config(res, req))
+	if err != nil {
+		if status := errorStatus(err); status != http.StatusInternalServerError {
+			h.s.writeError(res, err)
+			return
+		}
+
+		http.Error(res, "invalid or expired verification token", http.StatusBadRequest)
+		return
+	}
+
+	res.WriteHeader(http.StatusCreated)
+}
+
+func (h accountHandler) login(res http.ResponseWriter, req *http.Request) {
+	cfg := h.config(res, req)
+	cfg.OTP = req.PostFormValue("otp")
+
+	_, err := h.s.Login(req.PostFormValue("key"), req.PostFormValue("password"), cfg)
+	if err != nil {
+		h.s.writeError(res, err)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func (h accountHandler) logout(res http.ResponseWriter, req *http.Request) {
+	cfg := h.config(res, req)
+	claims, ok := h.tokenClaims(cfg, req)
+	if !ok {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if jti, ok := claims["jti"].(string); ok {
+		err := h.s.RevokeSession(claimKey(claims), jti)
+		if err != nil {
+			h.s.writeError(res, err)
+			return
+		}
+	}
+
+	if _, ok := cfg.TokenStore.(http.Cookie); ok {
+		clearCookies(cfg)
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// refresh issues a new token for the grant of the request's token, with the
+// same authentication methods, and revokes the old one first so a limit on
+// sessions doesn't evict another. Delegated tokens can't be refreshed, as the
+// new token would hold all of the grant's authority.
+func (h accountHandler) refresh(res http.ResponseWriter, req *http.Request) {
+	cfg := h.config(res, req)
+	claims, ok := h.tokenClaims(cfg, req)
+	if !ok || isGuest(claims) || isDelegated(claims) {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if jti, ok := claims["jti"].(string); ok {
+		err := h.s.RevokeSession(claimKey(claims), jti)
+		if err != nil {
+			h.s.writeError(res, err)
+			return
+		}
+	}
+
+	key, _ := claims["access"].(string)
+	cfg.TenantID, _ = claims["tenant"].(string)
+	_, err := h.s.IssueToken(key, cfg, claimStrings(claims, "amr")...)
+	if err != nil {
+		h.s.writeError(res, err)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// tokenClaims returns the claims of a valid token held in the request, not an
+// API key or request signature
+func (h accountHandler) tokenClaims(cfg *Config, req *http.Request) (map[string]interface{}, bool) {
+	claims, source, reason := h.s.checkClaims(cfg, req)
+	if reason != "" {
+		return nil, false
+	}
+
+	switch source {
+	case APIKeyHeader, SignatureHeader:
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// clearCookies expires the access and CSRF cookies written with cfg
+func clearCookies(cfg *Config) {
+	cookie := cfg.cookie("", time.Unix(0, 0))
+	cookie.MaxAge = -1
+	http.SetCookie(cfg.ResponseWriter, cookie)
+
+	csrf := *cookie
+	csrf.Name = cfg.csrfCookieName()
+	csrf.HttpOnly = false
+	http.SetCookie(cfg.ResponseWriter, &csrf)
+}
+
+func postOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		next(res, req)
+	}
+}
@@ -0,0 +1,138 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// SetACL sets the permissions the grant for key holds on the resource
+// identified by resourceID, such as a Ponzu content item's "Type:ID". Passing
+// no perms removes the grant from the resource's ACL. Keys of tenant grants
+// are the namespaced keys returned by TenantKey.
+func SetACL(resourceID, key string, perms ...string) error {
+	return std.SetACL(resourceID, key, perms...)
+}
+
+// SetACL is the package SetACL for s's Store
+func (s *Service) SetACL(resourceID, key string, perms ...string) error {
+	if resourceID == "" {
+		return fmt.Errorf("%s", "resource ID must not be empty")
+	}
+
+	if key == "" {
+		return fmt.Errorf("%s", "key must not be empty")
+	}
+
+	return s.store.Update(func(tx Tx) error {
+		acl, err := aclOf(tx, resourceID)
+		if err != nil {
+			return err
+		}
+
+		if len(perms) == 0 {
+			delete(acl, key)
+		} else {
+			p := append([]string(nil), perms...)
+			sort.Strings(p)
+			acl[key] = p
+		}
+
+		if len(acl) == 0 {
+			return tx.Delete(apiACLStore, resourceID)
+		}
+
+		j, err := json.Marshal(acl)
+		if err != nil {
+			return err
+		}
+
+		return tx.Put(apiACLStore, resourceID, j)
+	})
+}
+
+// GetACL returns the permissions held on the resource identified by
+// resourceID, by grant key
+func GetACL(resourceID string) (map[string][]string, error) {
+	return std.GetACL(resourceID)
+}
+
+// GetACL is the package GetACL for s's Store
+func (s *Service) GetACL(resourceID string) (map[string][]string, error) {
+	var acl map[string][]string
+	err := s.store.View(func(tx Tx) error {
+		var err error
+		acl, err = aclOf(tx, resourceID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return acl, nil
+}
+
+// ClearACL removes every permission held on the resource identified by
+// resourceID, such as when the resource is deleted
+func ClearACL(resourceID string) error {
+	return std.ClearACL(resourceID)
+}
+
+// ClearACL is the package ClearACL for s's Store
+func (s *Service) ClearACL(resourceID string) error {
+	return s.store.Update(func(tx Tx) error {
+		return tx.Delete(apiACLStore, resourceID)
+	})
+}
+
+// CheckACL validates the access token sent with req, in the Authorization
+// header or access cookie, and checks whether its grant holds perm on the
+// resource identified by resourceID. Unlike roles and groups, ACLs are read
+// from the store on every check, so changes apply to existing tokens.
+func CheckACL(req *http.Request, resourceID, perm string) bool {
+	return std.CheckACL(req, resourceID, perm)
+}
+
+// CheckACL is the package CheckACL for s's Store
+func (s *Service) CheckACL(req *http.Request, resourceID, perm string) bool {
+	claims, ok := s.requestClaims(req)
+	if !ok {
+		return false
+	}
+
+	var allowed bool
+	err := s.store.View(func(tx Tx) error {
+		acl, err := aclOf(tx, resourceID)
+		if err != nil {
+			return err
+		}
+
+		allowed = containsString(acl[claimKey(claims)], perm)
+		return nil
+	})
+	if err != nil {
+		return false
+	}
+
+	return allowed
+}
+
+func aclOf(tx Tx, resourceID string) (map[string][]string, error) {
+	j, err := tx.Get(apiACLStore, resourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	acl := make(map[string][]string)
+	if j == nil {
+		return acl, nil
+	}
+
+	err = json.Unmarshal(j, &acl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode ACL for %s, %v", resourceID, err)
+	}
+
+	return acl, nil
+}
@@ -0,0 +1,391 @@
+package access
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminGrant authorizes requests holding a valid token from an admin grant
+func AdminGrant(req *http.Request, identity *Identity) bool {
+	return identity != nil && identity.Admin
+}
+
+// AdminHandler returns a handler for managing grants over HTTP, to mount at
+// prefix, such as under Ponzu's "/admin" routes. It is protected by
+// GateKeeper, letting through admin grants and users logged in to the Ponzu
+// admin. It serves JSON:
+//
+//	GET    prefix/grants?offset=0&limit=100   list grants
+//	GET    prefix/grants?tag=...              list the grants tagged with tag
+//	POST   prefix/grants                      create a grant from a GrantRequest
+//	POST   prefix/service-accounts            create a service account
+//	GET    prefix/grant?key=...               view a grant
+//	DELETE prefix/grant?key=...               revoke a grant
+//	POST   prefix/grant/disable?key=...       disable a grant
+//	POST   prefix/grant/enable?key=...        re-enable a grant
+//	POST   prefix/grant/restore?key=...       restore a revoked grant
+//	GET    prefix/deleted                     list revoked grants to restore
+//	GET    prefix/sessions?key=...            list a grant's sessions
+//	DELETE prefix/sessions?key=...&jti=...    revoke a session, or all without jti
+//	GET    prefix/audit?key=...&from=...&to=  query the audit log
+//	GET    prefix/usage?key=...&period=...    count a grant's metered requests
+//	GET    prefix/data?key=...                export every record about a key
+//	DELETE prefix/data?key=...                erase every record about a key
+//
+// Service accounts are created from a GrantRequest with a "public_key" field
+// holding a PEM public key, or without one to be given a secret, which is
+// returned once as "secret". Keys of tenant grants are the namespaced keys
+// returned by TenantKey, audit times are in RFC 3339 format, and usage periods
+// are months such as "2024-05", by default the current one.
+func AdminHandler(prefix string) http.Handler {
+	return std.AdminHandler(prefix)
+}
+
+// AdminHandler is the package AdminHandler for the grants in s's Store
+func (s *Service) AdminHandler(prefix string) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(prefix+"/grants", s.adminGrants)
+	mux.HandleFunc(prefix+"/service-accounts", s.adminServiceAccounts)
+	mux.HandleFunc(prefix+"/grant", s.adminGrant)
+	mux.HandleFunc(prefix+"/grant/disable", s.adminSetDisabled(true))
+	mux.HandleFunc(prefix+"/grant/enable", s.adminSetDisabled(false))
+	mux.HandleFunc(prefix+"/grant/restore", s.adminRestore)
+	mux.HandleFunc(prefix+"/deleted", s.adminDeleted)
+	mux.HandleFunc(prefix+"/sessions", s.adminSessions)
+	mux.HandleFunc(prefix+"/audit", s.adminAudit)
+	mux.HandleFunc(prefix+"/usage", s.adminUsage)
+	mux.HandleFunc(prefix+"/data", s.adminKeyData)
+
+	cfg := &Config{Authorizers: []Authorizer{AdminGrant, AdminUser}}
+	return s.gateKeeper(cfg, mux.ServeHTTP)
+}
+
+func (s *Service) adminGrants(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		if tag := req.URL.Query().Get("tag"); tag != "" {
+			grants, err := s.ListGrantsByTag(tag)
+			if err != nil {
+				s.writeError(res, err)
+				return
+			}
+
+			writeJSON(res, http.StatusOK, grants)
+			return
+		}
+
+		offset, _ := strconv.Atoi(req.URL.Query().Get("offset"))
+		limit, err := strconv.Atoi(req.URL.Query().Get("limit"))
+		if err != nil {
+			limit = 100
+		}
+
+		grants, err := s.ListGrants(offset, limit)
+		if err != nil {
+			s.writeError(res, err)
+			return
+		}
+
+		writeJSON(res, http.StatusOK, grants)
+
+	case http.MethodPost:
+		var gr GrantRequest
+		err := json.NewDecoder(req.Body).Decode(&gr)
+		if err != nil {
+			http.Error(res, "invalid grant request", http.StatusBadRequest)
+			return
+		}
+
+		grant, err := s.createGrant(gr)
+		if err != nil {
+			s.writeError(res, err)
+			return
+		}
+
+		writeJSON(res, http.StatusCreated, grant)
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Service) adminServiceAccounts(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var sr struct {
+		GrantRequest
+		PublicKey string `json:"public_key"`
+	}
+	err := json.NewDecoder(req.Body).Decode(&sr)
+	if err != nil {
+		http.Error(res, "invalid service account request", http.StatusBadRequest)
+		return
+	}
+
+	var publicKey []byte
+	if sr.PublicKey != "" {
+		publicKey = []byte(sr.PublicKey)
+	}
+
+	secret, err := s.CreateServiceAccount(sr.GrantRequest, publicKey)
+	if err != nil {
+		s.writeError(res, err)
+		return
+	}
+
+	writeJSON(res, http.StatusCreated, map[string]string{
+		"key":    TenantKey(sr.Tenant, sr.Key),
+		"secret": secret,
+	})
+}
+
+func (s *Service) adminGrant(res http.ResponseWriter, req *http.Request) {
+	key := req.URL.Query().Get("key")
+	switch req.Method {
+	case http.MethodGet:
+		grant, err := s.GetGrant(key)
+		if err != nil {
+			s.writeError(res, err)
+			return
+		}
+
+		writeJSON(res, http.StatusOK, grant)
+
+	case http.MethodDelete:
+		err := s.ClearGrant(key)
+		if err != nil {
+			s.writeError(res, err)
+			return
+		}
+
+		res.WriteHeader(http.StatusNoContent)
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Service) adminSetDisabled(disabled bool) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		err := s.setDisabled(req.URL.Query().Get("key"), disabled)
+		if err != nil {
+			s.writeError(res, err)
+			return
+		}
+
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Service) adminRestore(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := s.RestoreGrant(req.URL.Query().Get("key"))
+	if err != nil {
+		s.writeError(res, err)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Service) adminDeleted(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	deleted, err := s.ListDeletedGrants()
+	if err != nil {
+		s.writeError(res, err)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, deleted)
+}
+
+func (s *Service) adminKeyData(res http.ResponseWriter, req *http.Request) {
+	key := req.URL.Query().Get("key")
+	switch req.Method {
+	case http.MethodGet:
+		data, err := s.ExportKeyData(key)
+		if err != nil {
+			s.writeError(res, err)
+			return
+		}
+
+		writeJSON(res, http.StatusOK, data)
+
+	case http.MethodDelete:
+		err := s.EraseKeyData(key)
+		if err != nil {
+			s.writeError(res, err)
+			return
+		}
+
+		res.WriteHeader(http.StatusNoContent)
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Service) adminUsage(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := req.URL.Query().Get("key")
+	period := req.URL.Query().Get("period")
+	if period == "" {
+		period = BillingPeriod(time.Now())
+	}
+
+	count, err := s.Usage(key, period)
+	if err != nil {
+		s.writeError(res, err)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, map[string]interface{}{
+		"key":      key,
+		"period":   period,
+		"requests": count,
+	})
+}
+
+func (s *Service) adminSessions(res http.ResponseWriter, req *http.Request) {
+	key := req.URL.Query().Get("key")
+	switch req.Method {
+	case http.MethodGet:
+		sessions, err := s.ListSessions(key)
+		if err != nil {
+			s.writeError(res, err)
+			return
+		}
+
+		writeJSON(res, http.StatusOK, sessions)
+
+	case http.MethodDelete:
+		var err error
+		if jti := req.URL.Query().Get("jti"); jti != "" {
+			err = s.RevokeSession(key, jti)
+		} else {
+			err = s.RevokeAllSessions(key)
+		}
+		if err != nil {
+			s.writeError(res, err)
+			return
+		}
+
+		res.WriteHeader(http.StatusNoContent)
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Service) adminAudit(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var from, to time.Time
+	var err error
+	if v := req.URL.Query().Get("from"); v != "" {
+		from, err = time.Parse(time.RFC3339, v)
+	}
+	if v := req.URL.Query().Get("to"); v != "" && err == nil {
+		to, err = time.Parse(time.RFC3339, v)
+	}
+	if err != nil {
+		http.Error(res, "from and to must be RFC 3339 times", http.StatusBadRequest)
+		return
+	}
+
+	events, err := s.QueryAudit(req.URL.Query().Get("key"), from, to)
+	if err != nil {
+		s.writeError(res, err)
+		return
+	}
+
+	if events == nil {
+		events = []AuditEvent{}
+	}
+
+	writeJSON(res, http.StatusOK, events)
+}
+
+// createGrant creates the grant described by gr, failing with ErrDuplicateKey
+// rather than updating an existing grant
+func (s *Service) createGrant(gr GrantRequest) (*APIAccess, error) {
+	results, err := s.GrantBatch([]GrantRequest{gr})
+	if err != nil {
+		return nil, err
+	}
+
+	return results[0].Grant, results[0].Err
+}
+
+// writeError writes the response for err with the status from errorStatus.
+// Unexpected errors are logged rather than written.
+func (s *Service) writeError(res http.ResponseWriter, err error) {
+	status := errorStatus(err)
+	if status == http.StatusInternalServerError {
+		s.logger.Error("failed to handle request", "err", err)
+		http.Error(res, http.StatusText(status), status)
+		return
+	}
+
+	http.Error(res, err.Error(), status)
+}
+
+// errorStatus returns the HTTP status for err, telling apart the errors
+// callers can act on
+func errorStatus(err error) int {
+	var policyErr *PasswordPolicyError
+	switch {
+	case errors.Is(err, ErrUnauthorized), errors.Is(err, ErrTOTPRequired):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrDisabled), errors.Is(err, ErrGrantExpired):
+		return http.StatusForbidden
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrDuplicateKey), errors.Is(err, ErrPending), errors.Is(err, ErrSessionLimit):
+		return http.StatusConflict
+	case errors.Is(err, ErrLocked), errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrBusy):
+		return http.StatusServiceUnavailable
+	case errors.As(err, &policyErr), strings.Contains(err.Error(), "must"):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(res http.ResponseWriter, status int, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "no-store")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(v)
+}
@@ -0,0 +1,208 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// APIKeyHeader is the request header API keys are read from. It is also the
+// Source of identities authenticated by an API key.
+const APIKeyHeader = "X-API-Key"
+
+// APIKey describes an API key issued to a grant. The key itself is only
+// returned by CreateAPIKey. Only its hash is stored, in the __apiKeys bucket.
+type APIKey struct {
+	ID      string    `json:"id"`
+	Grant   string    `json:"grant"`
+	Created time.Time `json:"created"`
+}
+
+// CreateAPIKey returns a new API key for the grant for key, for servers which
+// can't go through a login flow. Requests send it in the X-API-Key header and
+// are treated as holding a token for the grant. The key isn't stored and can't
+// be recovered, so hand it to its owner right away. For tenant grants, key
+// should be the namespaced key returned by TenantKey.
+func CreateAPIKey(key string) (string, error) {
+	return std.CreateAPIKey(key)
+}
+
+// CreateAPIKey is the package CreateAPIKey for s's Store
+func (s *Service) CreateAPIKey(key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("%s", "key must not be empty")
+	}
+
+	secret, hash, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	j, err := json.Marshal(APIKey{
+		ID:      hash[:16],
+		Grant:   key,
+		Created: time.Now(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = s.store.Update(func(tx Tx) error {
+		a, _, err := getGrant(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if a == nil {
+			return notFound(key)
+		}
+
+		return tx.Put(apiKeyStore, hash, j)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// ListAPIKeys returns the API keys issued to the grant for key, oldest first
+func ListAPIKeys(key string) ([]APIKey, error) {
+	return std.ListAPIKeys(key)
+}
+
+// ListAPIKeys is the package ListAPIKeys for s's Store
+func (s *Service) ListAPIKeys(key string) ([]APIKey, error) {
+	var keys []APIKey
+	err := s.store.View(func(tx Tx) error {
+		return forEachAPIKey(tx, key, func(hash string, k APIKey) error {
+			keys = append(keys, k)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Created.Before(keys[j].Created)
+	})
+
+	return keys, nil
+}
+
+// RevokeAPIKey removes the API key with id from the grant for key, so it is
+// no longer accepted
+func RevokeAPIKey(key, id string) error {
+	return std.RevokeAPIKey(key, id)
+}
+
+// RevokeAPIKey is the package RevokeAPIKey for s's Store
+func (s *Service) RevokeAPIKey(key, id string) error {
+	return s.store.Update(func(tx Tx) error {
+		var found string
+		err := forEachAPIKey(tx, key, func(hash string, k APIKey) error {
+			if k.ID == id {
+				found = hash
+				return errStopIteration
+			}
+
+			return nil
+		})
+		if err != nil && err != errStopIteration {
+			return err
+		}
+
+		if found == "" {
+			return fmt.Errorf("no API key %s found for %s", id, key)
+		}
+
+		return tx.Delete(apiKeyStore, found)
+	})
+}
+
+// clearAPIKeys removes every API key issued to the grant for key
+func clearAPIKeys(tx Tx, key string) error {
+	var hashes []string
+	err := forEachAPIKey(tx, key, func(hash string, k APIKey) error {
+		hashes = append(hashes, hash)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		err = tx.Delete(apiKeyStore, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// forEachAPIKey calls fn with each API key issued to the grant for key and the
+// hash it is stored under
+func forEachAPIKey(tx Tx, key string, fn func(hash string, k APIKey) error) error {
+	return tx.ForEach(apiKeyStore, func(hash string, value []byte) error {
+		var k APIKey
+		err := json.Unmarshal(value, &k)
+		if err != nil {
+			return fmt.Errorf("failed to decode API key, %v", err)
+		}
+
+		if k.Grant != key {
+			return nil
+		}
+
+		return fn(hash, k)
+	})
+}
+
+// apiKeyClaims returns claims describing the grant an API key was issued to,
+// as a token for it would hold, if the key is valid and the grant neither
+// locked nor inactive
+func (s *Service) apiKeyClaims(secret string) (map[string]interface{}, bool) {
+	if secret == "" {
+		return nil, false
+	}
+
+	var claims map[string]interface{}
+	err := s.store.View(func(tx Tx) error {
+		j, err := tx.Get(apiKeyStore, hashSecret(secret))
+		if err != nil || j == nil {
+			return err
+		}
+
+		var k APIKey
+		err = json.Unmarshal(j, &k)
+		if err != nil {
+			return err
+		}
+
+		a, _, err := getGrant(tx, k.Grant)
+		if err != nil || a == nil {
+			return err
+		}
+
+		if time.Now().Before(a.LockedUntil) || a.inactive(time.Now()) != nil {
+			return nil
+		}
+
+		a.Groups, err = groupsOf(tx, k.Grant)
+		if err != nil {
+			return err
+		}
+
+		a.amr = []string{"apikey"}
+		claims = a.claims()
+		return nil
+	})
+	if err != nil || claims == nil {
+		return nil, false
+	}
+
+	return claims, true
+}
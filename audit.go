@@ -0,0 +1,196 @@
+package access
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AuditType is the kind of an AuditEvent
+type AuditType string
+
+const (
+	// AuditGrant is recorded when Grant creates a grant
+	AuditGrant AuditType = "grant"
+
+	// AuditLogin is recorded when a token is issued for an existing grant
+	AuditLogin AuditType = "login"
+
+	// AuditLoginFailed is recorded when Login or Grant fails for an existing
+	// or unknown grant, with the error as its Detail
+	AuditLoginFailed AuditType = "login_failed"
+
+	// AuditRevoke is recorded when ClearGrant removes a grant
+	AuditRevoke AuditType = "revoke"
+
+	// AuditGuest is recorded when GrantGuest issues a token to a guest
+	AuditGuest AuditType = "guest"
+
+	// AuditDenied is recorded when GateKeeper rejects a request, with the
+	// Reason as its Detail
+	AuditDenied AuditType = "denied"
+)
+
+// AuditEvent is an entry of the audit log
+type AuditEvent struct {
+	Time   time.Time `json:"time"`
+	Type   AuditType `json:"type"`
+	Key    string    `json:"key,omitempty"`
+	IP     string    `json:"ip,omitempty"`
+	Path   string    `json:"path,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+var (
+	auditEnabledMu sync.RWMutex
+	auditEnabled   bool
+)
+
+// SetAudit sets whether access events are recorded in the audit log, the
+// __apiAudit bucket, which is disabled by default. Events are only ever
+// appended, and are removed by PurgeAudit once they are no longer needed.
+func SetAudit(enabled bool) {
+	auditEnabledMu.Lock()
+	auditEnabled = enabled
+	auditEnabledMu.Unlock()
+}
+
+// auditing reports whether SetAudit has enabled the audit log
+func auditing() bool {
+	auditEnabledMu.RLock()
+	defer auditEnabledMu.RUnlock()
+
+	return auditEnabled
+}
+
+// QueryAudit returns the recorded events for the grant for key, or for every
+// grant and anonymous request if key is empty, between from and to, oldest
+// first. Zero times leave the range open on that side. For tenant grants, key
+// should be the namespaced key returned by TenantKey.
+func QueryAudit(key string, from, to time.Time) ([]AuditEvent, error) {
+	return std.QueryAudit(key, from, to)
+}
+
+// QueryAudit is the package QueryAudit for s's Store
+func (s *Service) QueryAudit(key string, from, to time.Time) ([]AuditEvent, error) {
+	var events []AuditEvent
+	err := s.store.View(func(tx Tx) error {
+		return tx.ForEach(apiAuditStore, func(id string, value []byte) error {
+			var ev AuditEvent
+			err := json.Unmarshal(value, &ev)
+			if err != nil {
+				return fmt.Errorf("failed to decode audit event %s, %v", id, err)
+			}
+
+			if key != "" && ev.Key != key {
+				return nil
+			}
+
+			if !from.IsZero() && ev.Time.Before(from) {
+				return nil
+			}
+
+			if !to.IsZero() && ev.Time.After(to) {
+				return errStopIteration
+			}
+
+			events = append(events, ev)
+			return nil
+		})
+	})
+	if err != nil && err != errStopIteration {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// PurgeAudit removes events recorded before before, and returns the number
+// removed
+func PurgeAudit(before time.Time) (int, error) {
+	return std.PurgeAudit(before)
+}
+
+// PurgeAudit is the package PurgeAudit for s's Store
+func (s *Service) PurgeAudit(before time.Time) (int, error) {
+	var purged int
+	err := s.store.Update(func(tx Tx) error {
+		var old []string
+		err := tx.ForEach(apiAuditStore, func(id string, value []byte) error {
+			if id >= auditID(before, "") {
+				return errStopIteration
+			}
+
+			old = append(old, id)
+			return nil
+		})
+		if err != nil && err != errStopIteration {
+			return err
+		}
+
+		for _, id := range old {
+			err := tx.Delete(apiAuditStore, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		purged = len(old)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
+
+// audit records an event in its own transaction, so events are kept even
+// when the operation's transaction is rolled back. Failures are logged.
+func (s *Service) audit(typ AuditType, key string, req *http.Request, detail string) {
+	if !auditing() {
+		return
+	}
+
+	ev := AuditEvent{
+		Time:   time.Now().UTC(),
+		Type:   typ,
+		Key:    key,
+		IP:     requestIP(req),
+		Detail: detail,
+	}
+
+	if req != nil {
+		ev.Path = req.URL.Path
+	}
+
+	j, err := json.Marshal(ev)
+	if err != nil {
+		s.logger.Error("failed to encode audit event", "type", typ, "err", err)
+		return
+	}
+
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		s.logger.Error("failed to record audit event", "type", typ, "err", err)
+		return
+	}
+
+	err = s.store.Update(func(tx Tx) error {
+		return tx.Put(apiAuditStore, auditID(ev.Time, hex.EncodeToString(suffix)), j)
+	})
+	if err != nil {
+		s.logger.Error("failed to record audit event", "type", typ, "err", err)
+	}
+}
+
+// auditID returns an ID for an event recorded at t which sorts by time, with
+// suffix telling apart events recorded at the same time
+func auditID(t time.Time, suffix string) string {
+	return fmt.Sprintf("%020d-%s", t.UnixNano(), suffix)
+}
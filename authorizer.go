@@ -0,0 +1,98 @@
+package access
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/ponzu-cms/ponzu/system/admin/user"
+	"github.com/ponzu-cms/ponzu/system/db"
+)
+
+// Authorizer lets a request through GateKeeper, when no Policy decides it, by
+// returning true. identity is nil if the request holds no valid token.
+type Authorizer func(req *http.Request, identity *Identity) bool
+
+// DefaultAuthorizers returns the Authorizers GateKeeper uses unless
+// Config.Authorizers is set: ValidToken and AdminUser. Requests from local
+// addresses are only let through by adding LocalRequest or LocalNetworks.
+func DefaultAuthorizers() []Authorizer {
+	return []Authorizer{ValidToken, AdminUser}
+}
+
+// ValidToken authorizes requests holding a valid access token of a grant, not
+// a guest
+func ValidToken(req *http.Request, identity *Identity) bool {
+	return identity != nil && !identity.Anonymous
+}
+
+// AdminUser authorizes requests from users logged in to the Ponzu admin
+func AdminUser(req *http.Request, identity *Identity) bool {
+	return user.IsValid(req)
+}
+
+// LocalRequest authorizes requests whose client address is the server's
+// bind_addr. Behind a reverse proxy on the same host every request has that
+// address unless the proxy is set with SetTrustedProxies, so prefer
+// LocalNetworks with narrow ranges.
+func LocalRequest(req *http.Request, identity *Identity) bool {
+	return requestIP(req) == db.ConfigCache("bind_addr").(string)
+}
+
+// LocalNetworks returns an Authorizer for requests whose remote address is
+// within any of cidrs, such as "127.0.0.1/32" or "10.0.0.0/8"
+func LocalNetworks(cidrs ...string) (Authorizer, error) {
+	networks, err := parseCIDRs(cidrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(req *http.Request, identity *Identity) bool {
+		return inNetworks(remoteIP(req), networks)
+	}, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %s, %v", cidr, err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func inNetworks(ip net.IP, networks []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorized reports whether any of cfg.Authorizers, or DefaultAuthorizers
+// if it isn't set, authorizes req
+func (cfg *Config) authorized(req *http.Request, identity *Identity) bool {
+	authorizers := cfg.Authorizers
+	if authorizers == nil {
+		authorizers = DefaultAuthorizers()
+	}
+
+	for _, authorize := range authorizers {
+		if authorize(req, identity) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,164 @@
+package access
+
+import "fmt"
+
+// GrantRequest describes a grant to create with GrantBatch, or through
+// AdminHandler
+type GrantRequest struct {
+	Key      string            `json:"key"`
+	Password string            `json:"password"`
+	Tenant   string            `json:"tenant,omitempty"`
+	Roles    []string          `json:"roles,omitempty"`
+	Scopes   []string          `json:"scopes,omitempty"`
+	Admin    bool              `json:"admin,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+}
+
+// GrantResult is the outcome of one GrantRequest of a batch. Key is the
+// namespaced key returned by TenantKey, and Grant is set, with its password
+// hash omitted, unless Err is.
+type GrantResult struct {
+	Key   string
+	Grant *APIAccess
+	Err   error
+}
+
+// GrantBatch creates the grants described by reqs in a single transaction, such
+// as to onboard a list of partner API consumers at once, and returns a result
+// for each in the same order. Requests which are invalid, fail the password
+// policy or name a key already active, pending or earlier in the batch fail
+// with their own error without affecting the others. No tokens are issued. The
+// error is only set if the transaction fails, in which case no grant was
+// created.
+func GrantBatch(reqs []GrantRequest) ([]GrantResult, error) {
+	return std.GrantBatch(reqs)
+}
+
+// GrantBatch is the package GrantBatch for the grants in s's Store
+func (s *Service) GrantBatch(reqs []GrantRequest) ([]GrantResult, error) {
+	results := make([]GrantResult, len(reqs))
+	grants := make([]*APIAccess, len(reqs))
+
+	// passwords are checked and hashed before the transaction, as the
+	// PasswordChecker may make network calls and hashing is slow
+	for i, gr := range reqs {
+		results[i].Key = TenantKey(gr.Tenant, gr.Key)
+		grants[i], results[i].Err = s.newGrant(gr)
+	}
+
+	err := s.store.Update(func(tx Tx) error {
+		seen := make(map[string]bool, len(reqs))
+		for i, a := range grants {
+			if a == nil {
+				continue
+			}
+
+			key := results[i].Key
+			if seen[key] {
+				results[i].Err = ErrDuplicateKey
+				continue
+			}
+
+			seen[key] = true
+
+			active, err := tx.Get(apiAccessStore, key)
+			if err != nil {
+				return err
+			}
+
+			if active != nil {
+				results[i].Err = ErrDuplicateKey
+				continue
+			}
+
+			pending, err := tx.Get(apiPendingUserStore, key)
+			if err != nil {
+				return err
+			}
+
+			if pending != nil && !isStalePending(pending) {
+				results[i].Err = fmt.Errorf("Pending: %w", ErrPending)
+				continue
+			}
+
+			err = putGrant(tx, a)
+			if err != nil {
+				return err
+			}
+
+			if pending != nil {
+				err = tx.Delete(apiPendingUserStore, key)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, a := range grants {
+		if a == nil || results[i].Err != nil {
+			continue
+		}
+
+		grant := a.redacted()
+		results[i].Grant = &grant
+		s.grantCreated(results[i].Key, nil)
+	}
+
+	return results, nil
+}
+
+// newGrant validates gr and returns the grant it describes with its password
+// hashed
+func (s *Service) newGrant(gr GrantRequest) (*APIAccess, error) {
+	if gr.Key == "" {
+		return nil, fmt.Errorf("%s", "key must not be empty")
+	}
+
+	if gr.Password == "" {
+		return nil, fmt.Errorf("%s", "password must not be empty")
+	}
+
+	err := validateTenantID(gr.Tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateLocalKey(gr.Key, gr.Tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateTags(gr.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.checkNewPassword(gr.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &APIAccess{
+		Key:      gr.Key,
+		Tenant:   gr.Tenant,
+		Roles:    gr.Roles,
+		Scopes:   gr.Scopes,
+		Admin:    gr.Admin,
+		Metadata: gr.Metadata,
+		Tags:     gr.Tags,
+	}
+
+	err = s.hashPassword(a, gr.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
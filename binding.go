@@ -0,0 +1,102 @@
+package access
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Binding chooses which traits of the client a token is bound to by
+// Config.Bind. Bindings combine with |, such as BindIPPrefix|BindUserAgent.
+type Binding int
+
+const (
+	// BindIPPrefix binds tokens to the network of the client's IP address,
+	// its /24 for IPv4 and /64 for IPv6, so they survive address changes
+	// within a network. The address is read as SetTrustedProxies allows.
+	BindIPPrefix Binding = 1 << iota
+
+	// BindUserAgent binds tokens to the client's User-Agent header
+	BindUserAgent
+)
+
+// bindingNames are the names of each Binding in the "bind" claim
+var bindingNames = []struct {
+	binding Binding
+	name    string
+}{
+	{BindIPPrefix, "ip"},
+	{BindUserAgent, "ua"},
+}
+
+// bindClaim returns the "bind" claim binding a token to the client of req by
+// b, which names the bound traits followed by a hash of their values, such as
+// "ip,ua:...", so the traits themselves aren't readable from the token
+func bindClaim(req *http.Request, b Binding) string {
+	var names []string
+	for _, n := range bindingNames {
+		if b&n.binding != 0 {
+			names = append(names, n.name)
+		}
+	}
+
+	return strings.Join(names, ",") + ":" + fingerprint(req, names)
+}
+
+// fingerprint hashes the traits of the client of req which are named
+func fingerprint(req *http.Request, names []string) string {
+	h := sha256.New()
+	for _, name := range names {
+		switch name {
+		case "ip":
+			fmt.Fprintf(h, "ip=%s\n", ipPrefix(remoteIP(req)))
+
+		case "ua":
+			fmt.Fprintf(h, "ua=%s\n", req.UserAgent())
+		}
+	}
+
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// ipPrefix returns the network ip belongs to, its /24 for IPv4 and /64 for
+// IPv6, or an empty string if ip is nil
+func ipPrefix(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+
+	if ip != nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+
+	return ""
+}
+
+// boundToClient reports whether req comes from the client the token holding
+// claims was bound to by its "bind" claim, or claims hold none. Malformed
+// bindings match no request.
+func boundToClient(req *http.Request, claims map[string]interface{}) bool {
+	bind, ok := claims["bind"].(string)
+	if !ok {
+		return true
+	}
+
+	list, hash, ok := strings.Cut(bind, ":")
+	if !ok || list == "" {
+		return false
+	}
+
+	names := strings.Split(list, ",")
+	for _, name := range names {
+		if name != "ip" && name != "ua" {
+			return false
+		}
+	}
+
+	return subtle.ConstantTimeCompare([]byte(fingerprint(req, names)), []byte(hash)) == 1
+}
@@ -0,0 +1,147 @@
+package access
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// SetTokenCache keeps the claims of up to size verified tokens, least recently
+// used first out, so the tokens seen again by IsGranted, GateKeeper and the
+// other checks skip signature verification and decoding until they expire.
+// Revocation is still checked against the Store on every request, so revoked
+// tokens are rejected at once whether or not they are cached. A size of 0, the
+// default, disables the cache.
+func SetTokenCache(size int) {
+	std.SetTokenCache(size)
+}
+
+// SetTokenCache is the package SetTokenCache for the tokens s verifies. It
+// must be called before s handles requests.
+func (s *Service) SetTokenCache(size int) {
+	if size <= 0 {
+		s.cache = nil
+		return
+	}
+
+	s.cache = newTokenCache(size)
+}
+
+// InvalidateTokens drops the claims cached by SetTokenCache for the tokens of
+// the grant for key and its state cached by SetSessionCache, and forgets that
+// SetStrict found it active, so its next check reads the Store again. Stores shared by several servers call it when
+// a grant's tokens are revoked elsewhere, such as from etcdstore's
+// WatchRevocations. For tenant grants, key should be the namespaced key
+// returned by TenantKey.
+func InvalidateTokens(key string) {
+	std.InvalidateTokens(key)
+}
+
+// InvalidateTokens is the package InvalidateTokens for the tokens s verifies
+func (s *Service) InvalidateTokens(key string) {
+	s.cache.evict(key)
+	s.sessionStates.forget(key)
+	if s.strict != nil {
+		s.strict.forget(key)
+	}
+}
+
+// tokenCache is an LRU cache of the claims of verified tokens. Its methods are
+// safe to call on a nil *tokenCache, which caches nothing.
+type tokenCache struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cachedToken struct {
+	token  string
+	claims map[string]interface{}
+	exp    time.Time
+}
+
+func newTokenCache(size int) *tokenCache {
+	return &tokenCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// get returns the claims cached for token, if it hasn't expired. They are
+// shared by every request with token, so must not be modified.
+func (c *tokenCache) get(token string) (map[string]interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[token]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*cachedToken)
+	if time.Now().After(entry.exp) {
+		c.order.Remove(el)
+		delete(c.entries, token)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return entry.claims, true
+}
+
+// evict removes the claims cached for the tokens of the grant for key
+func (c *tokenCache) evict(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for token, el := range c.entries {
+		if claimKey(el.Value.(*cachedToken).claims) == key {
+			c.order.Remove(el)
+			delete(c.entries, token)
+		}
+	}
+}
+
+// add caches the claims of token until their "exp" claim. Claims without one
+// aren't cached, as only their Signer knows how long they are valid.
+func (c *tokenCache) add(token string, claims map[string]interface{}) {
+	if c == nil {
+		return
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[token]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[token] = c.order.PushFront(&cachedToken{
+		token:  token,
+		claims: claims,
+		exp:    time.Unix(int64(exp), 0),
+	})
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedToken).token)
+	}
+}
@@ -0,0 +1,108 @@
+package access_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+func TestCachedClaimsImmutable(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+	access.SetTokenCache(16)
+	t.Cleanup(func() { access.SetTokenCache(0) })
+
+	token := accesstest.Token(t, "cached@example.com", accesstest.WithRoles("viewer"),
+		accesstest.WithClaims(map[string]interface{}{"org": map[string]interface{}{"id": "acme"}}))
+
+	first, ok := access.VerifyToken(token)
+	if !ok {
+		t.Fatal("token rejected")
+	}
+
+	first.Claims["access"] = "admin@example.com"
+	first.Claims["admin"] = true
+	first.Claims["roles"].([]interface{})[0] = "owner"
+	first.Claims["org"].(map[string]interface{})["id"] = "other"
+	first.Roles[0] = "owner"
+
+	for name, identity := range map[string]func() (*access.Identity, bool){
+		"VerifyToken": func() (*access.Identity, bool) { return access.VerifyToken(token) },
+		"IdentityOf": func() (*access.Identity, bool) {
+			return access.IdentityOf(accesstest.Request(http.MethodGet, "/", token), http.Header{})
+		},
+	} {
+		id, ok := identity()
+		if !ok {
+			t.Fatalf("%s rejected the cached token", name)
+		}
+
+		if id.Key != "cached@example.com" || id.Claims["access"] != "cached@example.com" || id.Admin {
+			t.Errorf("%s: cached claims were modified through an Identity: %+v", name, id)
+		}
+
+		if !id.HasRole("viewer") || id.HasRole("owner") {
+			t.Errorf("%s: cached roles were modified through an Identity: %v", name, id.Roles)
+		}
+
+		roles := id.Claims["roles"].([]interface{})
+		org := id.Claims["org"].(map[string]interface{})
+		if roles[0] != "viewer" || org["id"] != "acme" {
+			t.Errorf("%s: nested claims were modified through an Identity: %v, %v", name, roles, org)
+		}
+	}
+}
+
+func TestInvalidateTokens(t *testing.T) {
+	store := accesstest.UseMemoryStore(t)
+	access.SetTokenCache(16)
+	access.SetStrict(true, time.Hour)
+	t.Cleanup(func() {
+		access.SetTokenCache(0)
+		access.SetStrict(false, 0)
+	})
+
+	req := accesstest.Request(http.MethodGet, "/", accesstest.Token(t, "gone@example.com"))
+	if !access.IsGranted(req, http.Header{}) {
+		t.Fatal("token rejected")
+	}
+
+	// as another server sharing the store would, without revoking the tokens
+	// this server has cached
+	err := store.Update(func(tx access.Tx) error {
+		return tx.Delete("__apiAccess", "gone@example.com")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !access.IsGranted(req, http.Header{}) {
+		t.Fatal("grant wasn't cached as active")
+	}
+
+	access.InvalidateTokens("gone@example.com")
+	if access.IsGranted(req, http.Header{}) {
+		t.Error("token of a removed grant accepted after InvalidateTokens")
+	}
+}
+
+func BenchmarkIsGrantedCached(b *testing.B) {
+	accesstest.UseMemoryStore(b)
+	access.SetTokenCache(16)
+	b.Cleanup(func() { access.SetTokenCache(0) })
+
+	req := accesstest.Request(http.MethodGet, "/", accesstest.Token(b, "bench@example.com"))
+	if !access.IsGranted(req, http.Header{}) {
+		b.Fatal("token rejected")
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !access.IsGranted(req, http.Header{}) {
+			b.Fatal("token rejected")
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Package casbinadapter evaluates Casbin models against access grants, for
+// authorization such as RBAC with domains or ABAC beyond what the access
+// package implements itself.
+//
+// Adapter loads the roles and groups of every grant into a Casbin model as "g"
+// rules, while "p" rules are kept by any other Casbin adapter. Authorizer then
+// enforces the model for each request, either as GateKeeper's access.Policy or
+// as middleware.
+package casbinadapter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+
+	"github.com/nilslice/access"
+)
+
+// pageSize is the number of grants read at a time when loading the model
+const pageSize = 100
+
+// errNotImplemented is recognised by Casbin, which ignores it when saving
+// changes the adapter can't store
+var errNotImplemented = errors.New("not implemented")
+
+// Adapter is a Casbin persist.Adapter which loads grants' roles and groups as
+// "g" rules, alongside the "p" rules of Policy
+type Adapter struct {
+	// Policy, if set, loads and saves the model's "p" rules
+	Policy persist.Adapter
+
+	// Domains adds each grant's tenant as a fourth field of its "g" rules, for
+	// models using RBAC with domains
+	Domains bool
+}
+
+// NewAdapter returns an Adapter loading "p" rules from policy
+func NewAdapter(policy persist.Adapter) *Adapter {
+	return &Adapter{Policy: policy}
+}
+
+// LoadPolicy loads the rules of Policy, then a "g" rule for each role and group
+// of every grant. Subjects are grant keys, namespaced by TenantKey for tenant
+// grants.
+func (a *Adapter) LoadPolicy(m model.Model) error {
+	if a.Policy != nil {
+		err := a.Policy.LoadPolicy(m)
+		if err != nil {
+			return err
+		}
+	}
+
+	var cursor string
+	for {
+		grants, next, err := access.ListGrantsAfter(cursor, pageSize)
+		if err != nil {
+			return fmt.Errorf("failed to list grants for casbin, %v", err)
+		}
+
+		for _, g := range grants {
+			sub := access.TenantKey(g.Tenant, g.Key)
+
+			groups, err := access.GroupsOf(sub)
+			if err != nil {
+				return fmt.Errorf("failed to get groups of %s for casbin, %v", sub, err)
+			}
+
+			for _, role := range append(g.Roles, groups...) {
+				rule := []string{"g", sub, role}
+				if a.Domains {
+					rule = append(rule, g.Tenant)
+				}
+
+				err := persist.LoadPolicyArray(rule, m)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// SavePolicy is not supported, since "g" rules are managed with SetRoles and
+// AddToGroup, and "p" rules by Policy
+func (a *Adapter) SavePolicy(m model.Model) error {
+	return errNotImplemented
+}
+
+// AddPolicy adds a "p" rule to Policy
+func (a *Adapter) AddPolicy(sec string, ptype string, rule []string) error {
+	if sec != "p" || a.Policy == nil {
+		return errNotImplemented
+	}
+
+	return a.Policy.AddPolicy(sec, ptype, rule)
+}
+
+// RemovePolicy removes a "p" rule from Policy
+func (a *Adapter) RemovePolicy(sec string, ptype string, rule []string) error {
+	if sec != "p" || a.Policy == nil {
+		return errNotImplemented
+	}
+
+	return a.Policy.RemovePolicy(sec, ptype, rule)
+}
+
+// RemoveFilteredPolicy removes the "p" rules matching a filter from Policy
+func (a *Adapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
+	if sec != "p" || a.Policy == nil {
+		return errNotImplemented
+	}
+
+	return a.Policy.RemoveFilteredPolicy(sec, ptype, fieldIndex, fieldValues...)
+}
+
+// Authorizer enforces a Casbin model for requests, with the grant key as the
+// subject, the URL path as the object and the method as the action
+type Authorizer struct {
+	Enforcer casbin.IEnforcer
+
+	// Domains passes the grant's tenant as the domain, after the subject
+	Domains bool
+}
+
+// New returns an Authorizer using e
+func New(e casbin.IEnforcer) *Authorizer {
+	return &Authorizer{Enforcer: e}
+}
+
+// Allow implements access.Policy, abstaining for requests without a valid
+// token and otherwise allowing or denying them as the model decides
+func (a *Authorizer) Allow(req *http.Request, identity *access.Identity) access.Decision {
+	if identity == nil {
+		return access.Abstain
+	}
+
+	ok, err := a.enforce(req, identity)
+	if err != nil || !ok {
+		return access.Deny
+	}
+
+	return access.Allow
+}
+
+// Middleware only calls next for requests authenticated by access.Authenticate
+// which the model allows. Requests without a valid token are rejected with 401
+// Unauthorized, and those the model denies with 403 Forbidden.
+func (a *Authorizer) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return access.Authenticate(func(res http.ResponseWriter, req *http.Request) {
+		identity, _ := access.FromContext(req.Context())
+
+		ok, err := a.enforce(req, identity)
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if !ok {
+			res.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
+
+func (a *Authorizer) enforce(req *http.Request, identity *access.Identity) (bool, error) {
+	sub := access.TenantKey(identity.Tenant, identity.Key)
+
+	if a.Domains {
+		return a.Enforcer.Enforce(sub, identity.Tenant, req.URL.Path, req.Method)
+	}
+
+	return a.Enforcer.Enforce(sub, req.URL.Path, req.Method)
+}
@@ -0,0 +1,188 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ChangeKey moves the grant for oldKey to newKey, such as when its owner
+// changes their email address, after verifying password. Its groups, grant
+// data, API keys and ACL entries move along with it, and every token issued
+// for oldKey is revoked, so its owner must Login again with newKey. newKey must
+// not be in use by an active or pending user. For tenant grants, both keys
+// should be namespaced by TenantKey for the grant's tenant.
+func ChangeKey(oldKey, newKey, password string) error {
+	return std.ChangeKey(oldKey, newKey, password)
+}
+
+// ChangeKey is the package ChangeKey for the grants in s's Store
+func (s *Service) ChangeKey(oldKey, newKey, password string) error {
+	if oldKey == "" || newKey == "" {
+		return fmt.Errorf("%s", "key must not be empty")
+	}
+
+	if password == "" {
+		return fmt.Errorf("%s", "password must not be empty")
+	}
+
+	if oldKey == newKey {
+		return fmt.Errorf("%s", "new key must differ from the current key")
+	}
+
+	err := s.takeAttempt(&Config{}, oldKey)
+	if err != nil {
+		return err
+	}
+
+	// the password is checked before the transaction, as hashing is slow
+	stored, err := s.storedPassword(oldKey, password)
+	if err == ErrUnauthorized {
+		s.recordLoginFailure(oldKey)
+	}
+	if err != nil {
+		return err
+	}
+
+	defer s.sessionStates.forget(oldKey)
+	return s.store.Update(func(tx Tx) error {
+		a, err := s.updateGrant(tx, oldKey, stored, password)
+		if err != nil {
+			return err
+		}
+
+		prefix := TenantKey(a.Tenant, "")
+		if !strings.HasPrefix(newKey, prefix) || newKey == prefix {
+			return fmt.Errorf("new key %s must be namespaced for tenant %s", newKey, a.Tenant)
+		}
+
+		active, err := tx.Get(apiAccessStore, newKey)
+		if err != nil {
+			return err
+		}
+
+		if active != nil {
+			return ErrDuplicateKey
+		}
+
+		pending, err := tx.Get(apiPendingUserStore, newKey)
+		if err != nil {
+			return err
+		}
+
+		if pending != nil && !isStalePending(pending) {
+			return ErrPending
+		}
+
+		a.Key = strings.TrimPrefix(newKey, prefix)
+		err = putGrant(tx, a)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Delete(apiAccessStore, oldKey)
+		if err != nil {
+			return err
+		}
+
+		for _, bucket := range []string{apiGroupStore, apiGrantDataStore} {
+			err = moveRecord(tx, bucket, oldKey, newKey)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = moveAPIKeys(tx, oldKey, newKey)
+		if err != nil {
+			return err
+		}
+
+		err = moveACLEntries(tx, oldKey, newKey)
+		if err != nil {
+			return err
+		}
+
+		return revokeTokens(tx, oldKey)
+	})
+}
+
+// moveRecord moves the record stored under oldKey in bucket to newKey, if
+// there is one
+func moveRecord(tx Tx, bucket, oldKey, newKey string) error {
+	j, err := tx.Get(bucket, oldKey)
+	if err != nil || j == nil {
+		return err
+	}
+
+	err = tx.Put(bucket, newKey, j)
+	if err != nil {
+		return err
+	}
+
+	return tx.Delete(bucket, oldKey)
+}
+
+// moveAPIKeys reissues every API key of the grant for oldKey to newKey,
+// keeping their secrets
+func moveAPIKeys(tx Tx, oldKey, newKey string) error {
+	keys := make(map[string]APIKey)
+	err := forEachAPIKey(tx, oldKey, func(hash string, k APIKey) error {
+		keys[hash] = k
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for hash, k := range keys {
+		k.Grant = newKey
+		j, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Put(apiKeyStore, hash, j)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// moveACLEntries moves the permissions held by oldKey to newKey in every ACL
+func moveACLEntries(tx Tx, oldKey, newKey string) error {
+	acls := make(map[string]map[string][]string)
+	err := tx.ForEach(apiACLStore, func(resourceID string, value []byte) error {
+		var acl map[string][]string
+		err := json.Unmarshal(value, &acl)
+		if err != nil {
+			return fmt.Errorf("failed to decode ACL for %s, %v", resourceID, err)
+		}
+
+		if perms, ok := acl[oldKey]; ok {
+			delete(acl, oldKey)
+			acl[newKey] = perms
+			acls[resourceID] = acl
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for resourceID, acl := range acls {
+		j, err := json.Marshal(acl)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Put(apiACLStore, resourceID, j)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package access
+
+import (
+	"net/http"
+	"time"
+)
+
+// CheckError is returned by CheckRequest for requests which aren't
+// authenticated, with the Reason why
+type CheckError struct {
+	Reason Reason
+}
+
+func (e *CheckError) Error() string {
+	switch e.Reason {
+	case ReasonNoToken:
+		return "request holds no access token"
+	case ReasonExpired:
+		return "access token has expired"
+	case ReasonBadSignature:
+		return "access token signature is invalid"
+	case ReasonWrongAudience:
+		return "access token was issued for another audience"
+	case ReasonWrongClient:
+		return "access token was issued to another client"
+	case ReasonRevoked:
+		return "access token grant has been revoked"
+	case ReasonCSRF:
+		return "request is missing its CSRF token"
+	case ReasonDenied:
+		return "request is not allowed from this network"
+	default:
+		return "access token is invalid"
+	}
+}
+
+// CheckRequest authenticates req as cfg.GateKeeper does, without applying any
+// Policy or Authorizer, and also checks that the grant still exists. It returns
+// the request's identity, or a *CheckError with the Reason it isn't
+// authenticated, such as ReasonExpired or ReasonRevoked, so callers can report
+// accurate errors and metrics. cfg may be nil to read the Authorization header.
+func CheckRequest(req *http.Request, cfg *Config) (*Identity, error) {
+	return std.CheckRequest(req, cfg)
+}
+
+// CheckRequest is the package CheckRequest, verifying tokens with s's Signer
+func (s *Service) CheckRequest(req *http.Request, cfg *Config) (*Identity, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	claims, source, reason := s.checkClaims(cfg, req)
+	if reason != "" {
+		return nil, &CheckError{Reason: reason}
+	}
+
+	// guests have no grant
+	if !isGuest(claims) {
+		var exists bool
+		err := s.store.View(func(tx Tx) error {
+			j, err := tx.Get(apiAccessStore, claimKey(claims))
+			exists = j != nil
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if !exists {
+			return nil, &CheckError{Reason: ReasonRevoked}
+		}
+	}
+
+	identity := identityFromClaims(claims)
+	identity.Source = source
+	return identity, nil
+}
+
+// tokenFailure returns the reason token fails verification. Tokens are parsed
+// by signer, or s's Signer if nil, so tokens in any format it issues are
+// classified. The signature of an expired token isn't checked on its own, so
+// expired tokens are reported as such whether or not they were signed by this
+// server.
+func (s *Service) tokenFailure(token string, signer Signer) Reason {
+	if signer == nil {
+		signer = s.signer
+	}
+
+	claims := signer.Claims(token)
+	if claims == nil {
+		return ReasonInvalidToken
+	}
+
+	if signer.Verify(token) {
+		// the token verifies, so it has been revoked or its claims are
+		// malformed
+		if validClaims(claims) {
+			return ReasonRevoked
+		}
+
+		return ReasonInvalidToken
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if ok && int64(exp) < time.Now().Unix() {
+		return ReasonExpired
+	}
+
+	return ReasonBadSignature
+}
+
+// hasAudience reports whether the "aud" claim, a string or list of strings,
+// holds aud
+func hasAudience(claims map[string]interface{}, aud string) bool {
+	if s, ok := claims["aud"].(string); ok {
+		return s == aud
+	}
+
+	return containsString(claimStrings(claims, "aud"), aud)
+}
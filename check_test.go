@@ -0,0 +1,55 @@
+package access_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+func TestCheckRequestGuest(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	guest, err := access.GrantGuest(headerConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := access.CheckRequest(bearer(http.MethodGet, guest.Token), nil)
+	if err != nil {
+		t.Fatalf("guest token rejected: %v", err)
+	}
+
+	if !identity.Anonymous {
+		t.Error("guest token not identified as a guest")
+	}
+}
+
+func TestCheckRequestPASETOFooter(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := access.NewPASETOSigner(key)
+	s := access.NewService(access.NewMemoryStore(), signer, nil, nil)
+
+	token, err := signer.Sign(map[string]interface{}{
+		"access": "footer@example.com",
+		"exp":    time.Now().Add(-time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.CheckRequest(bearer(http.MethodGet, token+".Zm9vdGVy"), nil)
+	var checkErr *access.CheckError
+	if !errors.As(err, &checkErr) || checkErr.Reason != access.ReasonExpired {
+		t.Errorf("got %v, want %s", err, access.ReasonExpired)
+	}
+}
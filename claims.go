@@ -0,0 +1,318 @@
+package access
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"reflect"
+)
+
+// grantedClaims returns the claims of a valid token held within tokenStore, or
+// of an API key or request signature if it holds no token, as long as req
+// comes from the grant's networks and the client a token was bound to. Guest
+// tokens, tokens without the Audience of a *Config and tokens read from the
+// access cookie by state-changing requests without their CSRF token are
+// rejected.
+func (s *Service) grantedClaims(req *http.Request, tokenStore reqHeaderOrHTTPCookie) (map[string]interface{}, bool) {
+	token, source, err := findToken(req, tokenStore)
+	if err != nil || token == "" {
+		if claims, credential, ok := s.credentialClaims(req); credential != nil {
+			if !ok || !fromAllowedNetwork(req, claims) {
+				return nil, false
+			}
+
+			s.markSeen(claims)
+			return claims, true
+		}
+	}
+
+	if err != nil {
+		s.logger.Error("failed to get token to check API access claims", "err", err)
+		return nil, false
+	}
+
+	claims, ok := s.tokenClaims(token, configSigner(tokenStore))
+	if !ok || isGuest(claims) || !forAudience(tokenStore, claims) ||
+		!boundToClient(req, claims) || !fromAllowedNetwork(req, claims) {
+		return nil, false
+	}
+
+	if _, ok := source.(http.Cookie); ok && !validCSRF(req, claims) {
+		return nil, false
+	}
+
+	s.markSeen(claims)
+	return claims, true
+}
+
+// forAudience reports whether claims hold the Audience of tokenStore, if it is
+// a *Config with one set
+func forAudience(tokenStore reqHeaderOrHTTPCookie, claims map[string]interface{}) bool {
+	cfg, ok := tokenStore.(*Config)
+	return !ok || cfg.Audience == "" || hasAudience(claims, cfg.Audience)
+}
+
+// configSigner returns the Signer of tokenStore, if it is a *Config with one
+// set
+func configSigner(tokenStore reqHeaderOrHTTPCookie) Signer {
+	cfg, ok := tokenStore.(*Config)
+	if !ok {
+		return nil
+	}
+
+	return cfg.Signer
+}
+
+// tokenClaims returns the claims of token if it passes verification by signer,
+// or s's Signer if nil, its claims are well formed, neither it nor its session
+// has been revoked and, if s is strict, its grant is active, or the claims of
+// its grant if it was issued by a trusted Issuer and the grant is active
+func (s *Service) tokenClaims(token string, signer Signer) (map[string]interface{}, bool) {
+	if token == "" {
+		return nil, false
+	}
+
+	// only tokens verified by s's Signer are cached, so a token can't skip
+	// verification by a Config's Signer by being cached by the other
+	cached := signer == nil
+	if cached {
+		signer = s.signer
+	}
+
+	var claims map[string]interface{}
+	ok := false
+	if cached {
+		claims, ok = s.cache.get(token)
+	}
+
+	if !ok {
+		if !signer.Verify(token) {
+			claims, ok = s.issuerClaims(token)
+			if !ok || !s.grantActive(claimKey(claims)) {
+				return nil, false
+			}
+
+			return claims, true
+		}
+
+		claims = signer.Claims(token)
+		if !validClaims(claims) {
+			return nil, false
+		}
+
+		if cached {
+			s.cache.add(token, claims)
+		}
+	}
+
+	if !s.currentToken(claims) {
+		return nil, false
+	}
+
+	// guests have no grant
+	if !isGuest(claims) && !s.grantActive(claimKey(claims)) {
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// validClaims reports whether the "access" claim is a non-empty string and
+// every other internal claim present has the type this package issues it
+// with, so checks can't be confused by malformed tokens
+func validClaims(claims map[string]interface{}) bool {
+	key, ok := claims["access"].(string)
+	if !ok || key == "" {
+		return false
+	}
+
+	if tenant, ok := claims["tenant"]; ok {
+		if _, ok := tenant.(string); !ok {
+			return false
+		}
+	}
+
+	for _, name := range []string{"admin", "anon", "svc"} {
+		if flag, ok := claims[name]; ok {
+			if _, ok := flag.(bool); !ok {
+				return false
+			}
+		}
+	}
+
+	for _, name := range []string{"jti", "bind"} {
+		if s, ok := claims[name]; ok {
+			if _, ok := s.(string); !ok {
+				return false
+			}
+		}
+	}
+
+	for _, name := range []string{"roles", "scopes", "groups", "networks", "amr", "chain"} {
+		if list, ok := claims[name]; ok && !isStringList(list) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isStringList(claim interface{}) bool {
+	switch v := claim.(type) {
+	case []string:
+		return true
+
+	case []interface{}:
+		for _, s := range v {
+			if _, ok := s.(string); !ok {
+				return false
+			}
+		}
+
+		return true
+
+	default:
+		return false
+	}
+}
+
+// claimKey returns the key of the grant described by well formed claims,
+// namespaced by TenantKey for tenant grants
+func claimKey(claims map[string]interface{}) string {
+	key, _ := claims["access"].(string)
+	tenant, _ := claims["tenant"].(string)
+	return TenantKey(tenant, key)
+}
+
+// sameKey compares grant keys in constant time
+func sameKey(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// requestClaims returns the claims of a valid token sent in either the
+// Authorization header or the access cookie, or of an API key or request
+// signature. Tokens from the cookie are only accepted for state-changing
+// requests which send their CSRF token.
+func (s *Service) requestClaims(req *http.Request) (map[string]interface{}, bool) {
+	if req.Header.Get("Authorization") != "" || req.Header.Get(APIKeyHeader) != "" ||
+		req.Header.Get(SignatureHeader) != "" {
+		return s.grantedClaims(req, req.Header)
+	}
+
+	return s.grantedClaims(req, http.Cookie{})
+}
+
+// claimStrings returns a claim holding a list of strings, as decoded from a
+// token or set directly when minting one
+func claimStrings(claims map[string]interface{}, name string) []string {
+	return stringList(claims[name])
+}
+
+func stringList(claim interface{}) []string {
+	switch v := claim.(type) {
+	case []string:
+		return v
+
+	case []interface{}:
+		strs := make([]string, 0, len(v))
+		for _, s := range v {
+			if str, ok := s.(string); ok {
+				strs = append(strs, str)
+			}
+		}
+
+		return strs
+
+	default:
+		return nil
+	}
+}
+
+func containsString(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RequireClaim returns middleware which only calls next for requests holding a
+// valid token whose claim name satisfies match, making custom claims set with
+// Config.CustomClaims enforceable. Requests without a valid token are rejected
+// with 401 Unauthorized, and those whose claim is missing or doesn't match with
+// 403 Forbidden. Claims are as decoded from JSON, so numbers are float64 and
+// lists are []interface{}.
+func RequireClaim(name string, match func(claim interface{}) bool) func(next http.HandlerFunc) http.HandlerFunc {
+	return std.RequireClaim(name, match)
+}
+
+// RequireClaim is the package RequireClaim, verifying tokens with s's Signer
+func (s *Service) RequireClaim(name string, match func(claim interface{}) bool) func(next http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			claims, ok := s.requestClaims(req)
+			if !ok {
+				res.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			claim, ok := claims[name]
+			if !ok || !match(claim) {
+				res.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
+// ClaimEquals returns a matcher for RequireClaim which checks that a claim
+// equals value, comparing all numbers as float64
+func ClaimEquals(value interface{}) func(claim interface{}) bool {
+	want := normalizeClaim(value)
+	return func(claim interface{}) bool {
+		return reflect.DeepEqual(normalizeClaim(claim), want)
+	}
+}
+
+// ClaimOneOf returns a matcher for RequireClaim which checks that a claim
+// equals any of values
+func ClaimOneOf(values ...interface{}) func(claim interface{}) bool {
+	return func(claim interface{}) bool {
+		for _, v := range values {
+			if ClaimEquals(v)(claim) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// ClaimContains returns a matcher for RequireClaim which checks that a claim
+// holding a list of strings contains value
+func ClaimContains(value string) func(claim interface{}) bool {
+	return func(claim interface{}) bool {
+		return containsString(stringList(claim), value)
+	}
+}
+
+// normalizeClaim converts numbers to float64, as they are decoded from a token
+func normalizeClaim(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+
+	default:
+		return v
+	}
+}
@@ -0,0 +1,129 @@
+package access_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+func TestAudience(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	issuing := headerConfig("")
+	issuing.Audience = "billing"
+	_, err := access.Grant("aud@example.com", accesstest.Password, issuing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = access.SetRoles("aud@example.com", "editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := access.Login("aud@example.com", accesstest.Password, issuing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	billing := &access.Config{TokenStore: http.Header{}, Audience: "billing"}
+	reports := &access.Config{TokenStore: http.Header{}, Audience: "reports"}
+
+	req := bearer(http.MethodGet, a.Token)
+	if !access.IsGranted(req, billing) {
+		t.Error("token rejected for its own audience")
+	}
+
+	if !access.HasRole(req, billing, "editor") {
+		t.Error("HasRole rejected token for its own audience")
+	}
+
+	if _, ok := access.IdentityOf(req, billing); !ok {
+		t.Error("IdentityOf rejected token for its own audience")
+	}
+
+	checks := map[string]func() bool{
+		"IsGranted": func() bool { return access.IsGranted(req, reports) },
+		"IsOwner":   func() bool { return access.IsOwner(req, reports, "aud@example.com") },
+		"HasRole":   func() bool { return access.HasRole(req, reports, "editor") },
+		"IdentityOf": func() bool {
+			_, ok := access.IdentityOf(req, reports)
+			return ok
+		},
+	}
+	for name, check := range checks {
+		if check() {
+			t.Errorf("%s accepted a token for another audience", name)
+		}
+	}
+
+	_, err = access.CheckRequest(req, reports)
+	var checkErr *access.CheckError
+	if !errors.As(err, &checkErr) || checkErr.Reason != access.ReasonWrongAudience {
+		t.Errorf("CheckRequest: got %v, want %s", err, access.ReasonWrongAudience)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	_, err := access.Grant("csrf@example.com", accesstest.Password, headerConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = access.SetRoles("csrf@example.com", "editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	_, err = access.Login("csrf@example.com", accesstest.Password, &access.Config{
+		ExpireAfter:    time.Hour,
+		ResponseWriter: rec,
+		TokenStore:     http.Cookie{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := rec.Result().Cookies()
+	request := func(method string) *http.Request {
+		req := httptest.NewRequest(method, "/", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+
+		return req
+	}
+
+	checks := map[string]func(req *http.Request) bool{
+		"IsGranted": func(req *http.Request) bool { return access.IsGranted(req, http.Cookie{}) },
+		"IsOwner":   func(req *http.Request) bool { return access.IsOwner(req, http.Cookie{}, "csrf@example.com") },
+		"HasRole":   func(req *http.Request) bool { return access.HasRole(req, http.Cookie{}, "editor") },
+		"IdentityOf": func(req *http.Request) bool {
+			_, ok := access.IdentityOf(req, http.Cookie{})
+			return ok
+		},
+	}
+	for name, check := range checks {
+		if !check(request(http.MethodGet)) {
+			t.Errorf("%s rejected a safe request with the access cookie", name)
+		}
+
+		if check(request(http.MethodPost)) {
+			t.Errorf("%s accepted a POST with the access cookie but no CSRF token", name)
+		}
+
+		req := request(http.MethodPost)
+		req.Header.Set(access.CSRFHeader, access.CSRFToken(req, http.Cookie{}))
+		if !check(req) {
+			t.Errorf("%s rejected a POST sending its CSRF token", name)
+		}
+	}
+}
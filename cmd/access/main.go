@@ -0,0 +1,268 @@
+// Command access administers the API access grants of a Ponzu project, so
+// operators can manage API consumers without writing code or editing bolt
+// directly. Run it from the project's root to use its system database, or pass
+// -etcd to use an etcd cluster instead.
+//
+// Usage:
+//
+//	access [-etcd endpoints] <command> [flags]
+//
+// The commands are:
+//
+//	grant           create a grant, or update its password
+//	revoke          remove a grant and revoke its tokens
+//	restore         restore a grant removed by revoke
+//	list            list grants
+//	disable         disable a grant, revoking its tokens
+//	enable          re-enable a disabled grant
+//	reset-password  set a new password, revoking the grant's tokens
+//	mint-token      issue a token for a grant without its password
+//	service-account create a service account for a machine client
+//
+// Passwords are generated and printed when -password isn't given, as are the
+// secrets of service accounts created without -public-key.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/db"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/etcdstore"
+)
+
+func main() {
+	etcd := flag.String("etcd", "", "comma separated etcd endpoints to use instead of the Ponzu system database")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	closeStore, err := openStore(*etcd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "access:", err)
+		os.Exit(1)
+	}
+
+	err = run(flag.Arg(0), flag.Args()[1:])
+	closeStore()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "access:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: access [-etcd endpoints] <grant|revoke|restore|list|disable|enable|reset-password|mint-token|service-account> [flags]")
+	flag.PrintDefaults()
+}
+
+// openStore points the access package at the configured store, returning a
+// func to close it
+func openStore(etcd string) (func(), error) {
+	if etcd == "" {
+		db.Init()
+		return db.Close, nil
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   strings.Split(etcd, ","),
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to etcd, %v", err)
+	}
+
+	access.UseStore(etcdstore.New(client))
+	return func() { client.Close() }, nil
+}
+
+func run(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	key := fs.String("key", "", "key of the grant, such as an email address")
+	tenant := fs.String("tenant", "", "tenant of the grant")
+
+	switch cmd {
+	case "grant":
+		password := fs.String("password", "", "password of the grant, generated if empty")
+		scopes := fs.String("scopes", "", "comma separated scopes recorded on the grant")
+		fs.Parse(args)
+
+		pw, err := passwordOrGenerate(*password)
+		if err != nil {
+			return err
+		}
+
+		cfg := tokenConfig(*tenant, time.Minute)
+		if *scopes != "" {
+			cfg.Scopes = strings.Split(*scopes, ",")
+		}
+
+		_, err = access.Grant(*key, pw, cfg)
+		if err != nil {
+			return err
+		}
+
+		if *password == "" {
+			fmt.Println(pw)
+		}
+
+		return nil
+
+	case "revoke":
+		fs.Parse(args)
+		return access.ClearGrant(storeKey(*key, *tenant))
+
+	case "restore":
+		fs.Parse(args)
+		return access.RestoreGrant(storeKey(*key, *tenant))
+
+	case "list":
+		offset := fs.Int("offset", 0, "number of grants to skip")
+		limit := fs.Int("limit", 100, "maximum number of grants to list")
+		fs.Parse(args)
+
+		grants, err := access.ListGrants(*offset, *limit)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tTENANT\tROLES\tSCOPES\tDISABLED\tLAST LOGIN")
+		for _, a := range grants {
+			lastLogin := "never"
+			if !a.LastLoginAt.IsZero() {
+				lastLogin = a.LastLoginAt.Format(time.RFC3339)
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%s\n",
+				a.Key, a.Tenant, strings.Join(a.Roles, ","), strings.Join(a.Scopes, ","), a.Disabled, lastLogin)
+		}
+
+		return w.Flush()
+
+	case "disable":
+		fs.Parse(args)
+		return access.Disable(storeKey(*key, *tenant))
+
+	case "enable":
+		fs.Parse(args)
+		return access.Enable(storeKey(*key, *tenant))
+
+	case "reset-password":
+		password := fs.String("password", "", "new password of the grant, generated if empty")
+		fs.Parse(args)
+
+		pw, err := passwordOrGenerate(*password)
+		if err != nil {
+			return err
+		}
+
+		token, err := access.RequestPasswordReset(storeKey(*key, *tenant))
+		if err != nil {
+			return err
+		}
+
+		err = access.CompletePasswordReset(token, pw)
+		if err != nil {
+			return err
+		}
+
+		if *password == "" {
+			fmt.Println(pw)
+		}
+
+		return nil
+
+	case "mint-token":
+		expire := fs.Duration("expire", 24*time.Hour, "how long the token is valid for")
+		fs.Parse(args)
+
+		a, err := access.IssueToken(*key, tokenConfig(*tenant, *expire))
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(a.Token)
+		return nil
+
+	case "service-account":
+		roles := fs.String("roles", "", "comma separated roles of the service account")
+		scopes := fs.String("scopes", "", "comma separated scopes of the service account")
+		publicKey := fs.String("public-key", "", "PEM file of the public key the account signs assertions with, or a secret is generated")
+		fs.Parse(args)
+
+		gr := access.GrantRequest{Key: *key, Tenant: *tenant}
+		if *roles != "" {
+			gr.Roles = strings.Split(*roles, ",")
+		}
+		if *scopes != "" {
+			gr.Scopes = strings.Split(*scopes, ",")
+		}
+
+		var pem []byte
+		if *publicKey != "" {
+			var err error
+			pem, err = os.ReadFile(*publicKey)
+			if err != nil {
+				return err
+			}
+		}
+
+		secret, err := access.CreateServiceAccount(gr, pem)
+		if err != nil {
+			return err
+		}
+
+		if secret != "" {
+			fmt.Println(secret)
+		}
+
+		return nil
+
+	default:
+		usage()
+		return fmt.Errorf("unknown command %s", cmd)
+	}
+}
+
+// tokenConfig returns a Config which issues tokens without writing them to a
+// response, for the CLI to print
+func tokenConfig(tenant string, expireAfter time.Duration) *access.Config {
+	return &access.Config{
+		ExpireAfter: expireAfter,
+		TokenStore:  access.QueryParam(""),
+		TenantID:    tenant,
+	}
+}
+
+func storeKey(key, tenant string) string {
+	return access.TenantKey(tenant, key)
+}
+
+// passwordOrGenerate returns password, or a random one if it is empty
+func passwordOrGenerate(password string) (string, error) {
+	if password != "" {
+		return password, nil
+	}
+
+	b := make([]byte, 18)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate password, %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,176 @@
+package access
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Option sets a field of the Config built by NewConfig
+type Option func(cfg *Config)
+
+// NewConfig returns a Config with opts applied, or a descriptive error if the
+// result is invalid, as reported by Validate. Fields without an Option may be
+// set on the returned Config, and checked again with Validate.
+func NewConfig(opts ...Option) (*Config, error) {
+	cfg := &Config{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// WithExpireAfter sets how long tokens issued with the Config are valid for
+func WithExpireAfter(d time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.ExpireAfter = d
+	}
+}
+
+// WithTokenStore sets where tokens are written and read, an http.Header,
+// http.Cookie or QueryParam, along with the ResponseWriter header and cookie
+// tokens are written to
+func WithTokenStore(tokenStore reqHeaderOrHTTPCookie, res http.ResponseWriter) Option {
+	return func(cfg *Config) {
+		cfg.TokenStore = tokenStore
+		cfg.ResponseWriter = res
+	}
+}
+
+// WithSecureCookie sets whether token cookies are only sent over HTTPS
+func WithSecureCookie(secure bool) Option {
+	return func(cfg *Config) {
+		cfg.SecureCookie = secure
+	}
+}
+
+// WithCustomClaims sets claims added to every token issued with the Config
+func WithCustomClaims(claims map[string]interface{}) Option {
+	return func(cfg *Config) {
+		cfg.CustomClaims = claims
+	}
+}
+
+// WithTenant sets the tenant whose keyspace grants are stored in
+func WithTenant(tenantID string) Option {
+	return func(cfg *Config) {
+		cfg.TenantID = tenantID
+	}
+}
+
+// WithScopes sets the scopes recorded on grants by Grant
+func WithScopes(scopes ...string) Option {
+	return func(cfg *Config) {
+		cfg.Scopes = scopes
+	}
+}
+
+// WithAudience sets the "aud" claim issued and required by the Config
+func WithAudience(aud string) Option {
+	return func(cfg *Config) {
+		cfg.Audience = aud
+	}
+}
+
+// WithBinding binds tokens issued by the Config to the client of its Request
+func WithBinding(b Binding) Option {
+	return func(cfg *Config) {
+		cfg.Bind = b
+	}
+}
+
+// WithRequest sets the request being handled, so attempts can be rate
+// limited by source IP
+func WithRequest(req *http.Request) Option {
+	return func(cfg *Config) {
+		cfg.Request = req
+	}
+}
+
+// WithMaxSessions limits how many unexpired tokens a grant may hold at once,
+// and what happens to logins beyond it
+func WithMaxSessions(max int, action SessionLimitAction) Option {
+	return func(cfg *Config) {
+		cfg.MaxSessions = max
+		cfg.SessionLimit = action
+	}
+}
+
+// Validate reports the first problem found with the Config's settings for
+// issuing tokens, such as a missing ResponseWriter for a header or cookie
+// TokenStore, which would otherwise only surface once Login or Grant tries to
+// write a token. Configs only used to check requests, such as by GateKeeper,
+// need not pass it.
+func (cfg *Config) Validate() error {
+	if cfg.ExpireAfter <= 0 {
+		return fmt.Errorf("%s", "ExpireAfter must be greater than zero")
+	}
+
+	switch cfg.TokenStore.(type) {
+	case http.Header, http.Cookie:
+		if cfg.ResponseWriter == nil {
+			return fmt.Errorf("a ResponseWriter is required to write tokens to an %T", cfg.TokenStore)
+		}
+
+	case QueryParam:
+
+	case nil:
+		return fmt.Errorf("%s", "TokenStore must be set to an http.Header, http.Cookie or QueryParam")
+
+	default:
+		return fmt.Errorf("unrecognized token store %T, expected an http.Header, http.Cookie or QueryParam", cfg.TokenStore)
+	}
+
+	err := validateTenantID(cfg.TenantID)
+	if err != nil {
+		return err
+	}
+
+	for k := range cfg.CustomClaims {
+		if isReservedClaim(k) || k == "access" || k == "exp" {
+			return fmt.Errorf("custom Config claim [%s] collides with internal claim [%s], %s", k, k, "please rename custom claim")
+		}
+	}
+
+	switch cfg.CookiePrefix {
+	case "", SecurePrefix:
+
+	case HostPrefix:
+		if cfg.CookieDomain != "" || (cfg.CookiePath != "" && cfg.CookiePath != "/") {
+			return fmt.Errorf("%s", "cookies with the __Host- prefix must not set CookieDomain, and their CookiePath must be \"/\"")
+		}
+
+	default:
+		return fmt.Errorf("unrecognized CookiePrefix %s, expected HostPrefix or SecurePrefix", cfg.CookiePrefix)
+	}
+
+	if cfg.CookieMaxAge < 0 {
+		return fmt.Errorf("%s", "CookieMaxAge must not be negative")
+	}
+
+	if cfg.MaxSessions < 0 {
+		return fmt.Errorf("%s", "MaxSessions must not be negative")
+	}
+
+	switch cfg.SessionLimit {
+	case EvictOldestSession, RejectNewSession:
+	default:
+		return fmt.Errorf("unrecognized SessionLimit %d, expected EvictOldestSession or RejectNewSession", cfg.SessionLimit)
+	}
+
+	for _, ts := range cfg.TokenSources {
+		switch ts.(type) {
+		case http.Header, http.Cookie, QueryParam:
+		default:
+			return fmt.Errorf("unrecognized token source %T, expected an http.Header, http.Cookie or QueryParam", ts)
+		}
+	}
+
+	return nil
+}
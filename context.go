@@ -0,0 +1,81 @@
+package access
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+type identityContextKey struct{}
+
+// Authenticate returns middleware which validates the token sent in the
+// Authorization header or access cookie, and calls next with the identity it
+// describes stored in the request context, for handlers to read with
+// FromContext. Requests without a valid token are rejected with 401
+// Unauthorized.
+func Authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return std.Authenticate(next)
+}
+
+// Authenticate is the package Authenticate, verifying tokens with s's Signer
+func (s *Service) Authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := s.requestClaims(req)
+		if !ok {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(res, WithIdentity(req, identityFromClaims(claims)))
+	})
+}
+
+// WithIdentity returns a shallow copy of req with identity stored in its
+// context
+func WithIdentity(req *http.Request, identity *Identity) *http.Request {
+	return req.WithContext(NewContext(req.Context(), identity))
+}
+
+// NewContext returns a copy of ctx with identity stored in it, for services
+// authenticating requests other than over HTTP
+func NewContext(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, identity)
+}
+
+// FromContext returns the identity stored in ctx by Authenticate or
+// GateKeeper, if any
+func FromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityContextKey{}).(*Identity)
+	return identity, ok && identity != nil
+}
+
+// CheckScope returns an error unless ctx holds the identity of a token issued
+// with scope, for per-field authorization in GraphQL resolvers and other
+// handlers given only a context
+func CheckScope(ctx context.Context, scope string) error {
+	identity, ok := FromContext(ctx)
+	if !ok {
+		return fmt.Errorf("%s", "not authenticated")
+	}
+
+	if !identity.HasScope(scope) {
+		return fmt.Errorf("access token lacks scope %s", scope)
+	}
+
+	return nil
+}
+
+// CheckRole returns an error unless ctx holds the identity of a grant holding
+// role, directly or through the role hierarchy
+func CheckRole(ctx context.Context, role string) error {
+	identity, ok := FromContext(ctx)
+	if !ok {
+		return fmt.Errorf("%s", "not authenticated")
+	}
+
+	if !identity.HasRole(role) {
+		return fmt.Errorf("grant lacks role %s", role)
+	}
+
+	return nil
+}
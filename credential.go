@@ -0,0 +1,98 @@
+package access
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CredentialVerifier checks passwords against an external system, such as an
+// LDAP directory, instead of the hashes stored with grants
+type CredentialVerifier interface {
+	// VerifyCredentials reports whether password is the password of key. An
+	// error means the system could not be consulted.
+	VerifyCredentials(key, password string) (bool, error)
+}
+
+var (
+	credentialVerifierMu sync.RWMutex
+	credentialVerifier   CredentialVerifier
+)
+
+// UseCredentialVerifier makes Login check the passwords of grants without a
+// password of their own with v, while tokens are still issued and grants kept
+// by this package. A key without a grant gets one, created without a password
+// like ProvisionGrant, on its first successful login, so its roles, scopes and
+// sessions can be managed locally. Grants with a password, such as local admin
+// accounts, are still checked against it. A nil v, the default, makes Login
+// only check stored passwords.
+func UseCredentialVerifier(v CredentialVerifier) {
+	credentialVerifierMu.Lock()
+	credentialVerifier = v
+	credentialVerifierMu.Unlock()
+}
+
+// currentCredentialVerifier returns the CredentialVerifier set by
+// UseCredentialVerifier
+func currentCredentialVerifier() CredentialVerifier {
+	credentialVerifierMu.RLock()
+	defer credentialVerifierMu.RUnlock()
+
+	return credentialVerifier
+}
+
+// verifyCredentials checks password with the CredentialVerifier if the grant
+// for key has no password of its own, creating the grant if it doesn't exist,
+// and reports whether it did. Failed checks return ErrUnauthorized.
+func (s *Service) verifyCredentials(key, password string, cfg *Config) (bool, error) {
+	verifier := currentCredentialVerifier()
+	if verifier == nil {
+		return false, nil
+	}
+
+	storeKey := TenantKey(cfg.TenantID, key)
+
+	var a *APIAccess
+	err := s.store.View(func(tx Tx) error {
+		var err error
+		a, _, err = getGrant(tx, storeKey)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if a != nil {
+		if a.ServiceAccount {
+			return true, ErrUnauthorized
+		}
+
+		if a.Hash != "" {
+			return false, nil
+		}
+
+		// locked grants are rejected before reaching the directory, so its
+		// own lockout isn't triggered too
+		if time.Now().Before(a.LockedUntil) {
+			return true, ErrLocked
+		}
+	}
+
+	ok, err := verifier.VerifyCredentials(key, password)
+	if err != nil {
+		return true, fmt.Errorf("failed to verify credentials, %v", err)
+	}
+
+	if !ok {
+		return true, ErrUnauthorized
+	}
+
+	if a == nil {
+		_, err = s.ProvisionGrant(GrantRequest{Key: key, Tenant: cfg.TenantID})
+		if err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
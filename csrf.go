@@ -0,0 +1,100 @@
+package access
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+)
+
+const (
+	// CSRFHeader is the request header holding the CSRF token of a request
+	// authenticated by the access cookie
+	CSRFHeader = "X-CSRF-Token"
+
+	// CSRFFormField is the form field holding the CSRF token of a request
+	// authenticated by the access cookie, if CSRFHeader isn't sent
+	CSRFFormField = "csrf_token"
+
+	apiCSRFCookie = "_apiCSRFToken"
+)
+
+// CSRFToken returns the CSRF token of the valid access token held within
+// tokenStore, to embed in forms as CSRFFormField or send as CSRFHeader. Tokens
+// issued for an http.Cookie store carry a CSRF token, which is also set in a
+// cookie readable by scripts.
+func CSRFToken(req *http.Request, tokenStore reqHeaderOrHTTPCookie) string {
+	return std.CSRFToken(req, tokenStore)
+}
+
+// CSRFToken is the package CSRFToken, verifying tokens with s's Signer
+func (s *Service) CSRFToken(req *http.Request, tokenStore reqHeaderOrHTTPCookie) string {
+	// the token is only read, not granted, so forms can be rendered again in
+	// response to requests which didn't send it
+	token, err := getToken(req, tokenStore)
+	if err != nil {
+		return ""
+	}
+
+	claims, ok := s.tokenClaims(token, configSigner(tokenStore))
+	if !ok {
+		return ""
+	}
+
+	csrf, _ := claims["csrf"].(string)
+	return csrf
+}
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// validCSRF reports whether req may use a token read from the access cookie:
+// safe methods always may, and other methods must send the CSRF token from
+// the token's claims
+func validCSRF(req *http.Request, claims map[string]interface{}) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	want, _ := claims["csrf"].(string)
+	if want == "" {
+		return false
+	}
+
+	got := req.Header.Get(CSRFHeader)
+	if got == "" {
+		got = req.PostFormValue(CSRFFormField)
+	}
+
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// writeCSRFCookie sets the cookie holding csrf, the CSRF token of the access
+// token set in cookie
+func (cfg *Config) writeCSRFCookie(csrf string, cookie *http.Cookie) {
+	if csrf == "" {
+		return
+	}
+
+	c := *cookie
+	c.Name = cfg.csrfCookieName()
+	c.Value = csrf
+	c.HttpOnly = false
+	http.SetCookie(cfg.ResponseWriter, &c)
+}
+
+func (cfg *Config) csrfCookieName() string {
+	if cfg.CookieName != "" {
+		return cfg.cookieName() + "_csrf"
+	}
+
+	return string(cfg.CookiePrefix) + apiCSRFCookie
+}
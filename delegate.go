@@ -0,0 +1,108 @@
+package access
+
+import (
+	"fmt"
+	"time"
+)
+
+// Delegate returns a child of parentToken, a valid token issued by this
+// package, carrying only scopes, each of which parentToken must hold, for its
+// holder to hand a narrowly scoped token to a third-party integration. The
+// child expires after ttl, or with parentToken if that is sooner. It holds the
+// key, tenant, audience and networks of parentToken but not its roles, groups
+// or admin flag, so its scopes are its only authority. Its "chain" claim lists
+// the sessions of the tokens it descends from, and it is rejected as soon as
+// any of them is revoked or expires. Children can be delegated further,
+// narrowing their scopes again, and are listed by ListSessions so they can be
+// revoked on their own. Guest tokens and tokens from other issuers can't be
+// delegated.
+func Delegate(parentToken string, scopes []string, ttl time.Duration) (string, error) {
+	return std.Delegate(parentToken, scopes, ttl)
+}
+
+// Delegate is the package Delegate for the tokens s issues
+func (s *Service) Delegate(parentToken string, scopes []string, ttl time.Duration) (string, error) {
+	if len(scopes) == 0 {
+		return "", fmt.Errorf("%s", "scopes must not be empty")
+	}
+
+	if ttl <= 0 {
+		return "", fmt.Errorf("%s", "ttl must be positive")
+	}
+
+	if !s.signer.Verify(parentToken) {
+		return "", ErrUnauthorized
+	}
+
+	parent := s.signer.Claims(parentToken)
+	if !validClaims(parent) || isGuest(parent) || !s.currentToken(parent) {
+		return "", ErrUnauthorized
+	}
+
+	parentID, ok := parent["jti"].(string)
+	if !ok {
+		return "", fmt.Errorf("%s", "tokens issued without a session can't be delegated")
+	}
+
+	held := claimStrings(parent, "scopes")
+	for _, scope := range scopes {
+		if !containsString(held, scope) {
+			return "", fmt.Errorf("scope %s is not held by the parent token: %w", scope, ErrUnauthorized)
+		}
+	}
+
+	now := time.Now()
+	exp := now.Add(ttl)
+	if parentExp, ok := parent["exp"].(float64); ok && time.Unix(int64(parentExp), 0).Before(exp) {
+		exp = time.Unix(int64(parentExp), 0)
+	}
+
+	jti, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	child := map[string]interface{}{
+		"access": parent["access"],
+		"scopes": scopes,
+		"exp":    exp.Unix(),
+		"jti":    jti,
+		"chain":  append(claimStrings(parent, "chain"), parentID),
+	}
+
+	for _, name := range []string{"tenant", "aud", "networks", "ver", "svc", "bind"} {
+		if v, ok := parent[name]; ok {
+			child[name] = v
+		}
+	}
+
+	token, err := s.signer.Sign(child)
+	if err != nil {
+		return "", err
+	}
+
+	key := claimKey(parent)
+	err = s.store.Update(func(tx Tx) error {
+		active, err := sessions(tx, key)
+		if err != nil {
+			return err
+		}
+
+		return putSessions(tx, key, append(active, Session{
+			ID:        jti,
+			IssuedAt:  now,
+			ExpiresAt: exp,
+		}))
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// isDelegated reports whether claims are those of a token minted by Delegate
+func isDelegated(claims map[string]interface{}) bool {
+	_, ok := claims["chain"]
+	return ok
+}
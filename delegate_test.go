@@ -0,0 +1,100 @@
+package access_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+func TestDelegate(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	parent := accesstest.Token(t, "owner@example.com",
+		accesstest.WithScopes("reports:read", "reports:write"), accesstest.WithRoles("editor"))
+	parentIdentity, ok := access.VerifyToken(parent)
+	if !ok {
+		t.Fatal("parent token rejected")
+	}
+
+	child, err := access.Delegate(parent, []string{"reports:read"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, ok := access.VerifyToken(child)
+	if !ok {
+		t.Fatal("child token rejected")
+	}
+
+	if identity.Key != "owner@example.com" {
+		t.Errorf("got key %s, want the parent's", identity.Key)
+	}
+
+	if len(identity.Scopes) != 1 || identity.Scopes[0] != "reports:read" {
+		t.Errorf("got scopes %v, want [reports:read]", identity.Scopes)
+	}
+
+	if len(identity.Roles) != 0 {
+		t.Errorf("child holds the parent's roles %v", identity.Roles)
+	}
+
+	if len(identity.Chain) != 1 || identity.Chain[0] != parentIdentity.SessionID {
+		t.Errorf("got chain %v, want the parent's session %s", identity.Chain, parentIdentity.SessionID)
+	}
+
+	_, err = access.Delegate(child, []string{"reports:write"}, time.Hour)
+	if !errors.Is(err, access.ErrUnauthorized) {
+		t.Errorf("widening the scopes of a child: got %v, want ErrUnauthorized", err)
+	}
+
+	grandchild, err := access.Delegate(child, []string{"reports:read"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = access.RevokeSession("owner@example.com", parentIdentity.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, token := range map[string]string{"child": child, "grandchild": grandchild} {
+		if access.IsGranted(bearer(http.MethodGet, token), http.Header{}) {
+			t.Errorf("%s accepted after its parent's session was revoked", name)
+		}
+	}
+}
+
+func TestDelegateRejects(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	guest, err := access.GrantGuest(headerConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = access.Delegate(guest.Token, []string{"reports:read"}, time.Hour)
+	if !errors.Is(err, access.ErrUnauthorized) {
+		t.Errorf("guest token: got %v, want ErrUnauthorized", err)
+	}
+
+	_, err = access.Delegate(accesstest.TamperedToken(accesstest.Token(t, "owner@example.com")),
+		[]string{"reports:read"}, time.Hour)
+	if !errors.Is(err, access.ErrUnauthorized) {
+		t.Errorf("tampered token: got %v, want ErrUnauthorized", err)
+	}
+
+	parent := accesstest.Token(t, "owner@example.com", accesstest.WithScopes("reports:read"))
+	_, err = access.Delegate(parent, nil, time.Hour)
+	if err == nil {
+		t.Error("child delegated without scopes")
+	}
+
+	_, err = access.Delegate(parent, []string{"reports:read"}, 0)
+	if err == nil {
+		t.Error("child delegated without a ttl")
+	}
+}
@@ -0,0 +1,62 @@
+package access
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrDisabled is returned by Login, Grant and IssueToken when the grant has
+// been disabled by Disable
+var ErrDisabled = errors.New("grant is disabled")
+
+// Disable disables the grant for key, revoking every token issued for it and
+// rejecting its API keys and request signatures, until Enable is called. The
+// grant and its groups, grant data and API keys are kept. For tenant grants,
+// key should be the namespaced key returned by TenantKey.
+func Disable(key string) error {
+	return std.Disable(key)
+}
+
+// Disable is the package Disable for the grants in s's Store
+func (s *Service) Disable(key string) error {
+	return s.setDisabled(key, true)
+}
+
+// Enable re-enables the grant for key disabled by Disable. Tokens revoked when
+// it was disabled stay revoked, so its owner must Login again.
+func Enable(key string) error {
+	return std.Enable(key)
+}
+
+// Enable is the package Enable for the grants in s's Store
+func (s *Service) Enable(key string) error {
+	return s.setDisabled(key, false)
+}
+
+func (s *Service) setDisabled(key string, disabled bool) error {
+	if key == "" {
+		return fmt.Errorf("%s", "key must not be empty")
+	}
+
+	defer s.sessionStates.forget(key)
+	return s.store.Update(func(tx Tx) error {
+		a, _, err := getGrant(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if a == nil {
+			return notFound(key)
+		}
+
+		if disabled && !a.Disabled {
+			err = revokeTokens(tx, key)
+			if err != nil {
+				return err
+			}
+		}
+
+		a.Disabled = disabled
+		return putGrant(tx, a)
+	})
+}
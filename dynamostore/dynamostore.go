@@ -0,0 +1,465 @@
+// Package dynamostore implements an access.Store on Amazon DynamoDB, so grants
+// can be kept by Ponzu APIs running where a local bolt file isn't possible,
+// such as on Lambda.
+//
+// Records are kept in a single table with a string partition key "bucket" and a
+// string sort key "key", which CreateTable can provision. Transactions are
+// optimistic: every item read within an Update is checked, and every write is
+// conditional on the version of the item when it was read, so concurrent
+// attempts to register the same key cannot both succeed. An Update commits in
+// a single TransactWriteItems call, so it may write and read at most 100 items
+// in all, the most DynamoDB allows in one transaction.
+package dynamostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/nilslice/access"
+)
+
+const (
+	attrBucket  = "bucket"
+	attrKey     = "key"
+	attrValue   = "value"
+	attrVersion = "ver"
+
+	// maxTransactItems is the DynamoDB limit on items in a TransactWriteItems call
+	maxTransactItems = 100
+)
+
+// ErrConflict is returned by Update when the transaction still conflicts with
+// concurrent writes after all retries
+var ErrConflict = errors.New("dynamostore: transaction conflicted with a concurrent write")
+
+// ErrTooManyItems is returned by Update when its transaction writes and reads
+// more items than DynamoDB allows in one transaction. Nothing is written, as
+// splitting it would lose its atomicity.
+var ErrTooManyItems = fmt.Errorf("dynamostore: transaction touches more than %d items", maxTransactItems)
+
+// API is the subset of the DynamoDB client used by Store
+type API interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+// Store is an access.Store backed by a DynamoDB table
+type Store struct {
+	Client API
+	Table  string
+
+	// Timeout bounds each DynamoDB call, and defaults to 10 seconds
+	Timeout time.Duration
+
+	// MaxRetries is how many times an Update is retried after conflicting with
+	// a concurrent write, and defaults to 3
+	MaxRetries int
+}
+
+// New returns a Store using the named table
+func New(client API, table string) *Store {
+	return &Store{
+		Client:     client,
+		Table:      table,
+		Timeout:    10 * time.Second,
+		MaxRetries: 3,
+	}
+}
+
+// CreateTable creates a table with the key schema expected by Store, billed
+// per request
+func CreateTable(ctx context.Context, client *dynamodb.Client, table string) error {
+	_, err := client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(table),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(attrBucket), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String(attrKey), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(attrBucket), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String(attrKey), KeyType: types.KeyTypeRange},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create table %s, %v", table, err)
+	}
+
+	return nil
+}
+
+// View runs fn within a read-only transaction. Reads are strongly consistent,
+// but are not isolated from concurrent writes.
+func (s *Store) View(fn func(tx access.Tx) error) error {
+	return s.ViewContext(context.Background(), fn)
+}
+
+// ViewContext is View, with its DynamoDB calls bound to ctx
+func (s *Store) ViewContext(ctx context.Context, fn func(tx access.Tx) error) error {
+	return fn(s.newTx(ctx, false))
+}
+
+// Update runs fn within a read-write transaction, committing all of its writes
+// atomically. If a concurrent write changed any item read or written by fn, or
+// a concurrent transaction holds one of them, fn is run again, up to
+// MaxRetries times. Transactions touching more than 100 items fail with
+// ErrTooManyItems.
+func (s *Store) Update(fn func(tx access.Tx) error) error {
+	return s.UpdateContext(context.Background(), fn)
+}
+
+// UpdateContext is Update, with its DynamoDB calls bound to ctx
+func (s *Store) UpdateContext(ctx context.Context, fn func(tx access.Tx) error) error {
+	for attempt := 0; ; attempt++ {
+		tx := s.newTx(ctx, true)
+		err := fn(tx)
+		if err != nil {
+			return err
+		}
+
+		err = tx.commit()
+		if err == errConditionFailed && attempt < s.MaxRetries {
+			continue
+		}
+		if err == errConditionFailed {
+			return ErrConflict
+		}
+
+		return err
+	}
+}
+
+func (s *Store) newTx(ctx context.Context, writable bool) *tx {
+	return &tx{
+		store:    s,
+		ctx:      ctx,
+		writable: writable,
+		reads:    make(map[itemKey]int64),
+		scanned:  make(map[itemKey]int64),
+		writes:   make(map[itemKey][]byte),
+	}
+}
+
+func (s *Store) context(parent context.Context) (context.Context, context.CancelFunc) {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return context.WithTimeout(parent, timeout)
+}
+
+var errConditionFailed = errors.New("condition failed")
+
+type itemKey struct {
+	bucket string
+	key    string
+}
+
+// tx tracks the version of every item read, where 0 means the item did not
+// exist, and stages writes where a nil value marks a delete. Versions of items
+// seen by ForEach are tracked separately, as they are only checked if written.
+type tx struct {
+	store    *Store
+	ctx      context.Context
+	writable bool
+	reads    map[itemKey]int64
+	scanned  map[itemKey]int64
+	writes   map[itemKey][]byte
+}
+
+func (t *tx) Get(bucket, key string) ([]byte, error) {
+	k := itemKey{bucket, key}
+	if v, ok := t.writes[k]; ok {
+		return v, nil
+	}
+
+	ctx, cancel := t.store.context(t.ctx)
+	defer cancel()
+
+	out, err := t.store.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(t.store.Table),
+		Key:            primaryKey(bucket, key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s from %s, %v", key, bucket, err)
+	}
+
+	value, version, err := decodeItem(out.Item)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := t.reads[k]; !ok {
+		t.reads[k] = version
+	}
+
+	return value, nil
+}
+
+func (t *tx) Put(bucket, key string, value []byte) error {
+	if !t.writable {
+		return fmt.Errorf("%s", "dynamostore: tx not writable")
+	}
+
+	if value == nil {
+		value = []byte{}
+	}
+
+	t.writes[itemKey{bucket, key}] = value
+	return nil
+}
+
+func (t *tx) Delete(bucket, key string) error {
+	if !t.writable {
+		return fmt.Errorf("%s", "dynamostore: tx not writable")
+	}
+
+	t.writes[itemKey{bucket, key}] = nil
+	return nil
+}
+
+// ForEach queries every item in the bucket in key order, merged with writes
+// staged in this transaction. Items seen only through ForEach are not checked
+// for conflicts at commit unless they are also written.
+func (t *tx) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	items := make(map[string][]byte)
+
+	ctx, cancel := t.store.context(t.ctx)
+	defer cancel()
+
+	pages := dynamodb.NewQueryPaginator(t.store.Client, &dynamodb.QueryInput{
+		TableName:              aws.String(t.store.Table),
+		KeyConditionExpression: aws.String("#b = :b"),
+		ExpressionAttributeNames: map[string]string{
+			"#b": attrBucket,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":b": &types.AttributeValueMemberS{Value: bucket},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to query %s, %v", bucket, err)
+		}
+
+		for _, item := range page.Items {
+			key, ok := item[attrKey].(*types.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+
+			value, version, err := decodeItem(item)
+			if err != nil {
+				return err
+			}
+
+			k := itemKey{bucket, key.Value}
+			if _, ok := t.scanned[k]; !ok {
+				t.scanned[k] = version
+			}
+
+			items[key.Value] = value
+		}
+	}
+
+	for k, v := range t.writes {
+		if k.bucket != bucket {
+			continue
+		}
+
+		if v == nil {
+			delete(items, k.key)
+			continue
+		}
+
+		items[k.key] = v
+	}
+
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		err := fn(k, items[k])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commit writes all staged changes in a single TransactWriteItems call, each
+// conditional on the item version read earlier in the transaction. Items which
+// were read by Get but not written are included as condition checks, and count
+// toward the limit of maxTransactItems.
+func (t *tx) commit() error {
+	if len(t.writes) == 0 {
+		return nil
+	}
+
+	var items []types.TransactWriteItem
+	for k, v := range t.writes {
+		version, read := t.reads[k]
+		if !read {
+			version, read = t.scanned[k]
+		}
+
+		cond, names, values := versionCondition(version, read)
+
+		if v == nil {
+			items = append(items, types.TransactWriteItem{
+				Delete: &types.Delete{
+					TableName:                 aws.String(t.store.Table),
+					Key:                       primaryKey(k.bucket, k.key),
+					ConditionExpression:       cond,
+					ExpressionAttributeNames:  names,
+					ExpressionAttributeValues: values,
+				},
+			})
+			continue
+		}
+
+		item := primaryKey(k.bucket, k.key)
+		item[attrValue] = &types.AttributeValueMemberB{Value: v}
+		item[attrVersion] = &types.AttributeValueMemberN{
+			Value: strconv.FormatInt(nextVersion(version, read), 10),
+		}
+
+		items = append(items, types.TransactWriteItem{
+			Put: &types.Put{
+				TableName:                 aws.String(t.store.Table),
+				Item:                      item,
+				ConditionExpression:       cond,
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			},
+		})
+	}
+
+	for k, version := range t.reads {
+		if _, ok := t.writes[k]; ok {
+			continue
+		}
+
+		cond, names, values := versionCondition(version, true)
+		items = append(items, types.TransactWriteItem{
+			ConditionCheck: &types.ConditionCheck{
+				TableName:                 aws.String(t.store.Table),
+				Key:                       primaryKey(k.bucket, k.key),
+				ConditionExpression:       cond,
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			},
+		})
+	}
+
+	if len(items) > maxTransactItems {
+		return fmt.Errorf("%w: %d items", ErrTooManyItems, len(items))
+	}
+
+	ctx, cancel := t.store.context(t.ctx)
+	defer cancel()
+
+	_, err := t.store.Client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) {
+			// a transaction in progress on one of the items conflicts as
+			// much as a write made since it was read
+			for _, reason := range canceled.CancellationReasons {
+				switch aws.ToString(reason.Code) {
+				case "ConditionalCheckFailed", "TransactionConflict":
+					return errConditionFailed
+				}
+			}
+		}
+
+		return fmt.Errorf("failed to commit transaction, %v", err)
+	}
+
+	return nil
+}
+
+// versionCondition builds the condition that an item is still at the version
+// it was read at. Items which were never read are written unconditionally.
+func versionCondition(version int64, read bool) (*string, map[string]string, map[string]types.AttributeValue) {
+	if !read {
+		return nil, nil, nil
+	}
+
+	if version == 0 {
+		return aws.String("attribute_not_exists(#k)"),
+			map[string]string{"#k": attrKey},
+			nil
+	}
+
+	return aws.String("#v = :v"),
+		map[string]string{"#v": attrVersion},
+		map[string]types.AttributeValue{
+			":v": &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)},
+		}
+}
+
+// nextVersion returns the version to write for an item. Items written without
+// being read get a timestamp version, so any transaction which read them
+// earlier will still see a conflict.
+func nextVersion(version int64, read bool) int64 {
+	if !read {
+		return time.Now().UnixNano()
+	}
+
+	return version + 1
+}
+
+func primaryKey(bucket, key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		attrBucket: &types.AttributeValueMemberS{Value: bucket},
+		attrKey:    &types.AttributeValueMemberS{Value: key},
+	}
+}
+
+// decodeItem returns an item's value and version, or nil and 0 if item is empty
+func decodeItem(item map[string]types.AttributeValue) ([]byte, int64, error) {
+	if len(item) == 0 {
+		return nil, 0, nil
+	}
+
+	value, ok := item[attrValue].(*types.AttributeValueMemberB)
+	if !ok {
+		return nil, 0, fmt.Errorf("dynamostore: item is missing binary attribute %s", attrValue)
+	}
+
+	var version int64
+	if n, ok := item[attrVersion].(*types.AttributeValueMemberN); ok {
+		v, err := strconv.ParseInt(n.Value, 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("dynamostore: invalid item version %s, %v", n.Value, err)
+		}
+
+		version = v
+	}
+
+	if value.Value == nil {
+		return []byte{}, version, nil
+	}
+
+	return value.Value, version, nil
+}
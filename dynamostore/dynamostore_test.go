@@ -0,0 +1,240 @@
+package dynamostore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/dynamostore"
+)
+
+// fakeTable is an in-memory DynamoDB table understanding the conditions
+// Store writes with. conflicts is how many TransactWriteItems calls to cancel
+// as if another transaction held their items.
+type fakeTable struct {
+	mu        sync.Mutex
+	items     map[string]map[string]types.AttributeValue
+	conflicts int
+	commits   int
+}
+
+func newFakeTable() *fakeTable {
+	return &fakeTable{items: make(map[string]map[string]types.AttributeValue)}
+}
+
+func itemID(key map[string]types.AttributeValue) string {
+	bucket := key["bucket"].(*types.AttributeValueMemberS).Value
+	k := key["key"].(*types.AttributeValueMemberS).Value
+	return bucket + "/" + k
+}
+
+func (f *fakeTable) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return &dynamodb.GetItemOutput{Item: f.items[itemID(params.Key)]}, nil
+}
+
+func (f *fakeTable) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket := params.ExpressionAttributeValues[":b"].(*types.AttributeValueMemberS).Value
+	var items []map[string]types.AttributeValue
+	for _, item := range f.items {
+		if item["bucket"].(*types.AttributeValueMemberS).Value == bucket {
+			items = append(items, item)
+		}
+	}
+
+	return &dynamodb.QueryOutput{Items: items}, nil
+}
+
+// holds reports whether the stored item meets cond, as written by Store
+func (f *fakeTable) holds(key map[string]types.AttributeValue, cond *string, values map[string]types.AttributeValue) bool {
+	item, exists := f.items[itemID(key)]
+	switch aws.ToString(cond) {
+	case "":
+		return true
+	case "attribute_not_exists(#k)":
+		return !exists
+	case "#v = :v":
+		return exists && item["ver"].(*types.AttributeValueMemberN).Value ==
+			values[":v"].(*types.AttributeValueMemberN).Value
+	}
+
+	panic(fmt.Sprintf("unexpected condition %s", aws.ToString(cond)))
+}
+
+func (f *fakeTable) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(params.TransactItems) > 100 {
+		return nil, fmt.Errorf("%d items exceeds the limit", len(params.TransactItems))
+	}
+
+	reasons := make([]types.CancellationReason, len(params.TransactItems))
+	canceled := false
+	for i, item := range params.TransactItems {
+		code := "None"
+		switch {
+		case f.conflicts > 0:
+			code = "TransactionConflict"
+		case item.Put != nil && !f.holds(item.Put.Item, item.Put.ConditionExpression, item.Put.ExpressionAttributeValues),
+			item.Delete != nil && !f.holds(item.Delete.Key, item.Delete.ConditionExpression, item.Delete.ExpressionAttributeValues),
+			item.ConditionCheck != nil && !f.holds(item.ConditionCheck.Key, item.ConditionCheck.ConditionExpression, item.ConditionCheck.ExpressionAttributeValues):
+			code = "ConditionalCheckFailed"
+		}
+
+		reasons[i].Code = aws.String(code)
+		canceled = canceled || code != "None"
+	}
+
+	if f.conflicts > 0 {
+		f.conflicts--
+	}
+
+	if canceled {
+		return nil, &types.TransactionCanceledException{CancellationReasons: reasons}
+	}
+
+	for _, item := range params.TransactItems {
+		switch {
+		case item.Put != nil:
+			f.items[itemID(item.Put.Item)] = item.Put.Item
+		case item.Delete != nil:
+			delete(f.items, itemID(item.Delete.Key))
+		}
+	}
+
+	f.commits++
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func TestUpdateView(t *testing.T) {
+	s := dynamostore.New(newFakeTable(), "access")
+
+	err := s.Update(func(tx access.Tx) error {
+		for _, key := range []string{"b", "a", "c"} {
+			err := tx.Put("grants", key, []byte(key))
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Delete("grants", "c")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	err = s.View(func(tx access.Tx) error {
+		v, err := tx.Get("grants", "a")
+		if err != nil {
+			return err
+		}
+
+		if string(v) != "a" {
+			t.Errorf("got %q, want %q", v, "a")
+		}
+
+		return tx.ForEach("grants", func(key string, value []byte) error {
+			keys = append(keys, key)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(keys) != "[a b]" {
+		t.Errorf("ForEach got keys %v, want [a b]", keys)
+	}
+}
+
+func TestUpdateRetriesConflicts(t *testing.T) {
+	table := newFakeTable()
+	s := dynamostore.New(table, "access")
+
+	// the first attempt reads the key before a concurrent registration of it
+	attempts := 0
+	err := s.Update(func(tx access.Tx) error {
+		attempts++
+		v, err := tx.Get("grants", "alice")
+		if err != nil {
+			return err
+		}
+
+		if v != nil {
+			return access.ErrDuplicateKey
+		}
+
+		if attempts == 1 {
+			err = s.Update(func(tx access.Tx) error {
+				return tx.Put("grants", "alice", []byte("first"))
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Put("grants", "alice", []byte("second"))
+	})
+	if !errors.Is(err, access.ErrDuplicateKey) {
+		t.Errorf("got %v, want ErrDuplicateKey", err)
+	}
+
+	if attempts != 2 {
+		t.Errorf("ran %d attempts, want 2", attempts)
+	}
+
+	// transactions cancelled while another holds their items are retried too
+	table.conflicts = 2
+	err = s.Update(func(tx access.Tx) error {
+		return tx.Put("grants", "bob", []byte("bob"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table.conflicts = s.MaxRetries + 1
+	err = s.Update(func(tx access.Tx) error {
+		return tx.Put("grants", "carol", []byte("carol"))
+	})
+	if !errors.Is(err, dynamostore.ErrConflict) {
+		t.Errorf("got %v, want ErrConflict", err)
+	}
+}
+
+func TestUpdateTooManyItems(t *testing.T) {
+	table := newFakeTable()
+	s := dynamostore.New(table, "access")
+
+	err := s.Update(func(tx access.Tx) error {
+		for i := 0; i < 101; i++ {
+			err := tx.Put("sessions", strconv.Itoa(i), []byte{})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if !errors.Is(err, dynamostore.ErrTooManyItems) {
+		t.Errorf("got %v, want ErrTooManyItems", err)
+	}
+
+	if table.commits != 0 {
+		t.Errorf("%d transactions committed, want 0", table.commits)
+	}
+}
@@ -0,0 +1,45 @@
+// Package echomiddleware adapts the access package's middleware for the Echo
+// web framework, so Echo APIs consuming Ponzu content can be protected by
+// grants.
+package echomiddleware
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/nilslice/access"
+)
+
+// Wrap adapts net/http middleware from the access package, such as
+// access.RequireRole("editor") or access.RequireAdmin, into Echo middleware.
+// Requests the middleware rejects are answered with the response it wrote.
+func Wrap(mw func(next http.HandlerFunc) http.HandlerFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var err error
+			mw(func(res http.ResponseWriter, req *http.Request) {
+				c.SetRequest(req)
+				err = next(c)
+			})(c.Response(), c.Request())
+
+			return err
+		}
+	}
+}
+
+// GateKeeper returns Echo middleware which runs access.GateKeeper
+func GateKeeper() echo.MiddlewareFunc {
+	return Wrap(access.GateKeeper)
+}
+
+// Authenticate returns Echo middleware which runs access.Authenticate
+func Authenticate() echo.MiddlewareFunc {
+	return Wrap(access.Authenticate)
+}
+
+// Identity returns the identity stored for the request by GateKeeper or
+// Authenticate, if any
+func Identity(c echo.Context) (*access.Identity, bool) {
+	return access.FromContext(c.Request().Context())
+}
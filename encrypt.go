@@ -0,0 +1,197 @@
+package access
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const envelopeCipher = "aes-256-gcm"
+
+// KeyWrapper encrypts and decrypts the random per-record data keys used to
+// seal grants at rest. A KMS-backed implementation can be provided through
+// KMSKeyWrapper, or a local key through NewEnvKeyWrapper.
+type KeyWrapper interface {
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+// KMSKeyWrapper adapts a pair of callbacks, typically calls to a key management
+// service, into a KeyWrapper
+type KMSKeyWrapper struct {
+	Wrap   func(dataKey []byte) ([]byte, error)
+	Unwrap func(wrapped []byte) ([]byte, error)
+}
+
+// WrapKey calls the Wrap callback
+func (k KMSKeyWrapper) WrapKey(dataKey []byte) ([]byte, error) {
+	return k.Wrap(dataKey)
+}
+
+// UnwrapKey calls the Unwrap callback
+func (k KMSKeyWrapper) UnwrapKey(wrapped []byte) ([]byte, error) {
+	return k.Unwrap(wrapped)
+}
+
+// NewEnvKeyWrapper returns a KeyWrapper using a base64 encoded 32 byte master
+// key read from the named environment variable
+func NewEnvKeyWrapper(name string) (KeyWrapper, error) {
+	key, err := base64.StdEncoding.DecodeString(os.Getenv(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode master key from $%s, %v", name, err)
+	}
+
+	if len(key) != 32 {
+		return nil, fmt.Errorf("master key from $%s must be 32 bytes, got %d", name, len(key))
+	}
+
+	return aesKeyWrapper(key), nil
+}
+
+type aesKeyWrapper []byte
+
+func (k aesKeyWrapper) WrapKey(dataKey []byte) ([]byte, error) {
+	nonce, sealed, err := aesSeal(k, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(nonce, sealed...), nil
+}
+
+func (k aesKeyWrapper) UnwrapKey(wrapped []byte) ([]byte, error) {
+	gcm, err := newGCM(k)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%s", "wrapped key is too short")
+	}
+
+	n := gcm.NonceSize()
+	return gcm.Open(nil, wrapped[:n], wrapped[n:], nil)
+}
+
+// envelope is the stored form of an encrypted record
+type envelope struct {
+	Cipher  string `json:"enc"`
+	DataKey []byte `json:"dek"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+var (
+	keyWrapperMu sync.RWMutex
+	keyWrapper   KeyWrapper
+)
+
+// UseEncryption enables envelope encryption of records in the __apiAccess
+// bucket. Each record is sealed with its own random data key, which is in turn
+// wrapped by kw. Records written before encryption was enabled remain readable
+// and are sealed the next time they are saved. Passing nil disables encryption
+// of new writes.
+func UseEncryption(kw KeyWrapper) {
+	keyWrapperMu.Lock()
+	defer keyWrapperMu.Unlock()
+
+	keyWrapper = kw
+}
+
+// currentKeyWrapper returns the KeyWrapper set by UseEncryption
+func currentKeyWrapper() KeyWrapper {
+	keyWrapperMu.RLock()
+	defer keyWrapperMu.RUnlock()
+
+	return keyWrapper
+}
+
+// sealRecord encrypts a record if encryption is enabled
+func sealRecord(plain []byte) ([]byte, error) {
+	kw := currentKeyWrapper()
+	if kw == nil {
+		return plain, nil
+	}
+
+	dataKey := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate data key, %v", err)
+	}
+
+	wrapped, err := kw.WrapKey(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key, %v", err)
+	}
+
+	nonce, data, err := aesSeal(dataKey, plain)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(envelope{
+		Cipher:  envelopeCipher,
+		DataKey: wrapped,
+		Nonce:   nonce,
+		Data:    data,
+	})
+}
+
+// openRecord decrypts a sealed record, returning plaintext records unchanged
+func openRecord(record []byte) ([]byte, error) {
+	var env envelope
+	if json.Unmarshal(record, &env) != nil || env.Cipher == "" {
+		return record, nil
+	}
+
+	if env.Cipher != envelopeCipher {
+		return nil, fmt.Errorf("unsupported record cipher %s", env.Cipher)
+	}
+
+	kw := currentKeyWrapper()
+	if kw == nil {
+		return nil, fmt.Errorf("%s", "record is encrypted but no KeyWrapper is configured")
+	}
+
+	dataKey, err := kw.UnwrapKey(env.DataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key, %v", err)
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Open(nil, env.Nonce, env.Data, nil)
+}
+
+func aesSeal(key, plain []byte) (nonce, sealed []byte, err error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce = make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate nonce, %v", err)
+	}
+
+	return nonce, gcm.Seal(nil, nonce, plain, nil), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
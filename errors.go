@@ -0,0 +1,28 @@
+package access
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound is returned when there is no grant for a key
+	ErrNotFound = errors.New("no grant found")
+
+	// ErrUnauthorized is returned by Login, Grant and the other calls
+	// verifying a password when it is wrong, or when there is no grant to
+	// log in to
+	ErrUnauthorized = errors.New("User Not Authorized")
+
+	// ErrDuplicateKey is returned when a key is already held by an active
+	// grant
+	ErrDuplicateKey = errors.New("email already actively in use")
+
+	// ErrPending is returned when a key is already held by a pending user
+	ErrPending = errors.New("email already pending in use")
+)
+
+// notFound returns ErrNotFound for the grant for key
+func notFound(key string) error {
+	return fmt.Errorf("%w for %s", ErrNotFound, key)
+}
@@ -0,0 +1,289 @@
+// Package etcdstore implements an access.Store on etcd, so API servers in a
+// cluster share and quickly converge on the same access grants.
+//
+// Transactions run as etcd software transactional memory (STM), retried
+// automatically when they conflict with a concurrent write. Pending users can
+// be stored under a lease so etcd expires abandoned signups by itself, and
+// WatchRevocations lets every server react as soon as the tokens of a grant
+// are revoked.
+package etcdstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/nilslice/access"
+)
+
+const (
+	accessBucket       = "__apiAccess"
+	pendingBucket      = "__apiPending"
+	sessionBucket      = "__apiSessions"
+	tokenVersionBucket = "__apiTokenVersion"
+)
+
+// Store is an access.Store backed by etcd
+type Store struct {
+	Client *clientv3.Client
+
+	// Prefix is prepended to every key, and defaults to "access/"
+	Prefix string
+
+	// PendingTTL, if set, stores pending users under a lease of that duration
+	// so they are removed by etcd once it expires
+	PendingTTL time.Duration
+
+	// Timeout bounds each transaction, and defaults to 10 seconds
+	Timeout time.Duration
+}
+
+// New returns a Store using client
+func New(client *clientv3.Client) *Store {
+	return &Store{
+		Client:  client,
+		Prefix:  "access/",
+		Timeout: 10 * time.Second,
+	}
+}
+
+// View runs fn within a read-only transaction, reading from a single revision
+func (s *Store) View(fn func(tx access.Tx) error) error {
+	return s.run(context.Background(), false, fn)
+}
+
+// ViewContext is View, aborted once ctx is done
+func (s *Store) ViewContext(ctx context.Context, fn func(tx access.Tx) error) error {
+	return s.run(ctx, false, fn)
+}
+
+// Update runs fn within a serializable read-write transaction. fn may be run
+// more than once if the transaction conflicts with a concurrent write, so it
+// should not have side effects outside the transaction.
+func (s *Store) Update(fn func(tx access.Tx) error) error {
+	return s.run(context.Background(), true, fn)
+}
+
+// UpdateContext is Update, aborted once ctx is done
+func (s *Store) UpdateContext(ctx context.Context, fn func(tx access.Tx) error) error {
+	return s.run(ctx, true, fn)
+}
+
+func (s *Store) run(parent context.Context, writable bool, fn func(tx access.Tx) error) error {
+	ctx, cancel := s.context(parent)
+	defer cancel()
+
+	// the lease for pending users is granted once and kept across retries of
+	// the transaction, and revoked unless its last attempt committed with it
+	lease := &pendingLease{}
+	_, err := concurrency.NewSTM(s.Client, func(stm concurrency.STM) error {
+		lease.used = false
+		return fn(&tx{
+			store:    s,
+			ctx:      ctx,
+			stm:      stm,
+			writable: writable,
+			writes:   make(map[string]*string),
+			lease:    lease,
+		})
+	},
+		concurrency.WithAbortContext(ctx),
+		concurrency.WithIsolation(concurrency.SerializableSnapshot),
+	)
+
+	if lease.granted && (err != nil || !lease.used) {
+		revokeCtx, cancel := s.context(context.Background())
+		defer cancel()
+
+		// a lease which fails to be revoked still expires after PendingTTL
+		s.Client.Revoke(revokeCtx, lease.id)
+	}
+
+	return err
+}
+
+// pendingLease is the lease pending users are stored under by a transaction
+type pendingLease struct {
+	id      clientv3.LeaseID
+	granted bool
+	used    bool
+}
+
+// WatchRevocations calls fn with the key of every grant whose tokens are
+// revoked by any server in the cluster, until ctx is done: grants which are
+// removed from the store, have a session revoked or have their token version
+// bumped, as UpdatePassword and RevokeAllSessions do. fn is also called when
+// sessions start, as they share their record with revoked ones. Pass
+// access.InvalidateTokens, or the InvalidateTokens method of an
+// access.Service, to drop what the server has cached about those tokens.
+func (s *Store) WatchRevocations(ctx context.Context, fn func(key string)) error {
+	grants := s.bucketPrefix(accessBucket)
+	sessions := s.bucketPrefix(sessionBucket)
+	versions := s.bucketPrefix(tokenVersionBucket)
+
+	for resp := range s.Client.Watch(ctx, s.Prefix, clientv3.WithPrefix()) {
+		err := resp.Err()
+		if err != nil {
+			return fmt.Errorf("failed to watch %s, %v", s.Prefix, err)
+		}
+
+		for _, ev := range resp.Events {
+			key := string(ev.Kv.Key)
+			switch {
+			case strings.HasPrefix(key, grants):
+				if ev.Type == clientv3.EventTypeDelete {
+					fn(strings.TrimPrefix(key, grants))
+				}
+
+			case strings.HasPrefix(key, sessions):
+				fn(strings.TrimPrefix(key, sessions))
+
+			case strings.HasPrefix(key, versions):
+				fn(strings.TrimPrefix(key, versions))
+			}
+		}
+	}
+
+	return ctx.Err()
+}
+
+func (s *Store) context(parent context.Context) (context.Context, context.CancelFunc) {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return context.WithTimeout(parent, timeout)
+}
+
+func (s *Store) bucketPrefix(bucket string) string {
+	return s.Prefix + bucket + "/"
+}
+
+func (s *Store) key(bucket, key string) string {
+	return s.bucketPrefix(bucket) + key
+}
+
+// tx wraps an STM, keeping its own record of staged writes so they are visible
+// to ForEach, where a nil value marks a delete
+type tx struct {
+	store    *Store
+	ctx      context.Context
+	stm      concurrency.STM
+	writable bool
+	writes   map[string]*string
+	lease    *pendingLease
+}
+
+func (t *tx) Get(bucket, key string) ([]byte, error) {
+	k := t.store.key(bucket, key)
+	if v, ok := t.writes[k]; ok {
+		if v == nil {
+			return nil, nil
+		}
+
+		return []byte(*v), nil
+	}
+
+	v := t.stm.Get(k)
+	if v == "" && t.stm.Rev(k) == 0 {
+		return nil, nil
+	}
+
+	return []byte(v), nil
+}
+
+func (t *tx) Put(bucket, key string, value []byte) error {
+	if !t.writable {
+		return fmt.Errorf("%s", "etcdstore: tx not writable")
+	}
+
+	k := t.store.key(bucket, key)
+	v := string(value)
+
+	if bucket == pendingBucket && t.store.PendingTTL > 0 {
+		if !t.lease.granted {
+			ttl := int64((t.store.PendingTTL + time.Second - 1) / time.Second)
+			lease, err := t.store.Client.Grant(t.ctx, ttl)
+			if err != nil {
+				return fmt.Errorf("failed to grant lease for pending key %s, %v", key, err)
+			}
+
+			t.lease.id = lease.ID
+			t.lease.granted = true
+		}
+
+		t.lease.used = true
+		t.stm.Put(k, v, clientv3.WithLease(t.lease.id))
+	} else {
+		t.stm.Put(k, v)
+	}
+
+	t.writes[k] = &v
+	return nil
+}
+
+func (t *tx) Delete(bucket, key string) error {
+	if !t.writable {
+		return fmt.Errorf("%s", "etcdstore: tx not writable")
+	}
+
+	k := t.store.key(bucket, key)
+	t.stm.Del(k)
+	t.writes[k] = nil
+	return nil
+}
+
+// ForEach lists the keys in the bucket and reads each through the STM, so
+// values come from the transaction's snapshot and conflict with concurrent
+// writes, merged with writes staged in this transaction
+func (t *tx) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	prefix := t.store.bucketPrefix(bucket)
+	resp, err := t.store.Client.Get(t.ctx, prefix,
+		clientv3.WithPrefix(),
+		clientv3.WithKeysOnly(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to list %s, %v", bucket, err)
+	}
+
+	keys := make(map[string]bool)
+	for _, kv := range resp.Kvs {
+		keys[strings.TrimPrefix(string(kv.Key), prefix)] = true
+	}
+
+	for k := range t.writes {
+		if strings.HasPrefix(k, prefix) {
+			keys[strings.TrimPrefix(k, prefix)] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	for _, key := range sorted {
+		value, err := t.Get(bucket, key)
+		if err != nil {
+			return err
+		}
+
+		if value == nil {
+			continue
+		}
+
+		err = fn(key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
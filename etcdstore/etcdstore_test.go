@@ -0,0 +1,166 @@
+package etcdstore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/etcdstore"
+)
+
+// newStore returns a Store under a prefix of its own on the etcd cluster at
+// ETCD_ENDPOINTS, a comma separated list, removing its keys at the end of t.
+// Tests are skipped when no cluster is given.
+func newStore(t *testing.T) *etcdstore.Store {
+	t.Helper()
+
+	endpoints := os.Getenv("ETCD_ENDPOINTS")
+	if endpoints == "" {
+		t.Skip("ETCD_ENDPOINTS is not set")
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   strings.Split(endpoints, ","),
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := etcdstore.New(client)
+	s.Prefix = fmt.Sprintf("access-test-%d/", time.Now().UnixNano())
+
+	t.Cleanup(func() {
+		client.Delete(context.Background(), s.Prefix, clientv3.WithPrefix())
+		client.Close()
+	})
+
+	return s
+}
+
+func TestUpdateView(t *testing.T) {
+	s := newStore(t)
+
+	err := s.Update(func(tx access.Tx) error {
+		for _, key := range []string{"b", "a", "c"} {
+			err := tx.Put("grants", key, []byte(key))
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Delete("grants", "c")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// writes of a failed transaction are discarded
+	failed := errors.New("failed")
+	err = s.Update(func(tx access.Tx) error {
+		err := tx.Put("grants", "d", []byte("d"))
+		if err != nil {
+			return err
+		}
+
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("got %v, want the error of the transaction", err)
+	}
+
+	var keys []string
+	err = s.View(func(tx access.Tx) error {
+		v, err := tx.Get("grants", "a")
+		if err != nil {
+			return err
+		}
+
+		if string(v) != "a" {
+			t.Errorf("got %q, want %q", v, "a")
+		}
+
+		err = tx.Put("grants", "e", []byte("e"))
+		if err == nil {
+			t.Error("View allowed a write")
+		}
+
+		return tx.ForEach("grants", func(key string, value []byte) error {
+			keys = append(keys, key)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(keys) != "[a b]" {
+		t.Errorf("ForEach got keys %v, want [a b]", keys)
+	}
+}
+
+func TestWatchRevocations(t *testing.T) {
+	s := newStore(t)
+
+	svc := access.NewService(s, nil, nil, &access.Config{
+		ExpireAfter:    time.Hour,
+		ResponseWriter: httptest.NewRecorder(),
+		TokenStore:     http.Header{},
+	})
+	svc.UseHasher(access.BcryptHasher{Cost: bcrypt.MinCost})
+
+	_, err := svc.Grant("watched@example.com", "watched-Passw0rd-not-for-production", &access.Config{
+		ExpireAfter:    time.Hour,
+		ResponseWriter: httptest.NewRecorder(),
+		TokenStore:     http.Header{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	revoked := make(chan string, 16)
+	watching := make(chan error, 1)
+	go func() {
+		watching <- s.WatchRevocations(ctx, func(key string) {
+			revoked <- key
+		})
+	}()
+
+	// the watch starts asynchronously, so sessions are revoked until it sees
+	// them
+	deadline := time.After(10 * time.Second)
+	for {
+		err = svc.RevokeAllSessions("watched@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case key := <-revoked:
+			if key != "watched@example.com" {
+				t.Fatalf("got revocation of %s, want watched@example.com", key)
+			}
+
+			cancel()
+			<-watching
+			return
+
+		case <-time.After(100 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("revocation not seen by WatchRevocations")
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package access
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrGrantExpired is returned by Login, Grant and IssueToken when the grant is
+// past its ExpiresAt
+var ErrGrantExpired = errors.New("grant has expired")
+
+// SetGrantExpiry sets when the grant for key expires, after which Login fails
+// with ErrGrantExpired and its tokens, API keys and request signatures are
+// rejected. A zero at removes the expiry. Bringing the expiry forward revokes
+// every token issued for the grant, since they may outlive it. For tenant
+// grants, key should be the namespaced key returned by TenantKey.
+func SetGrantExpiry(key string, at time.Time) error {
+	return std.SetGrantExpiry(key, at)
+}
+
+// SetGrantExpiry is the package SetGrantExpiry for the grants in s's Store
+func (s *Service) SetGrantExpiry(key string, at time.Time) error {
+	if key == "" {
+		return fmt.Errorf("%s", "key must not be empty")
+	}
+
+	defer s.sessionStates.forget(key)
+	return s.store.Update(func(tx Tx) error {
+		a, _, err := getGrant(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if a == nil {
+			return notFound(key)
+		}
+
+		if !at.IsZero() && (a.ExpiresAt.IsZero() || at.Before(a.ExpiresAt)) {
+			err = revokeTokens(tx, key)
+			if err != nil {
+				return err
+			}
+		}
+
+		a.ExpiresAt = at
+		return putGrant(tx, a)
+	})
+}
+
+// ListExpiring returns the grants with an ExpiresAt before before, including
+// those already expired, in key order, such as to remind their owners to
+// renew. Password hashes, salts and TOTP secrets are omitted from the returned
+// grants.
+func ListExpiring(before time.Time) ([]APIAccess, error) {
+	return std.ListExpiring(before)
+}
+
+// ListExpiring is the package ListExpiring for the grants in s's Store
+func (s *Service) ListExpiring(before time.Time) ([]APIAccess, error) {
+	grants := []APIAccess{}
+	err := s.store.View(func(tx Tx) error {
+		return tx.ForEach(apiAccessStore, func(key string, value []byte) error {
+			a, _, err := decodeGrant(value)
+			if err != nil {
+				return fmt.Errorf("failed to decode grant for %s, %v", key, err)
+			}
+
+			if !a.ExpiresAt.IsZero() && a.ExpiresAt.Before(before) {
+				grants = append(grants, a.redacted())
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+// inactive returns ErrDisabled or ErrGrantExpired if the grant can't be used
+// at now
+func (a *APIAccess) inactive(now time.Time) error {
+	if a.Disabled {
+		return ErrDisabled
+	}
+
+	if !a.ExpiresAt.IsZero() && !now.Before(a.ExpiresAt) {
+		return ErrGrantExpired
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+// Package ginmiddleware adapts the access package's middleware for the Gin web
+// framework, so Gin APIs consuming Ponzu content can be protected by grants.
+package ginmiddleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nilslice/access"
+)
+
+// Wrap adapts net/http middleware from the access package, such as
+// access.RequireRole("editor") or access.RequireAdmin, into Gin middleware.
+// Requests the middleware rejects are aborted with the response it wrote.
+func Wrap(mw func(next http.HandlerFunc) http.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var passed bool
+		mw(func(res http.ResponseWriter, req *http.Request) {
+			passed = true
+			c.Request = req
+			c.Next()
+		})(c.Writer, c.Request)
+
+		if !passed {
+			c.Abort()
+		}
+	}
+}
+
+// GateKeeper returns Gin middleware which runs access.GateKeeper
+func GateKeeper() gin.HandlerFunc {
+	return Wrap(access.GateKeeper)
+}
+
+// Authenticate returns Gin middleware which runs access.Authenticate
+func Authenticate() gin.HandlerFunc {
+	return Wrap(access.Authenticate)
+}
+
+// Identity returns the identity stored for the request by GateKeeper or
+// Authenticate, if any
+func Identity(c *gin.Context) (*access.Identity, bool) {
+	return access.FromContext(c.Request.Context())
+}
@@ -0,0 +1,177 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ponzu-cms/ponzu/system/admin/user"
+)
+
+// grantSchemaVersion is the version of the APIAccess record format written by
+// this package. Bump it and append to grantMigrations when the format changes.
+const grantSchemaVersion = 1
+
+// grantMigrations upgrade a stored record by one version, where the func at
+// index n upgrades a version n record to version n+1
+var grantMigrations = []func(j []byte) ([]byte, error){
+	migrateGrantV0,
+}
+
+// migrateGrantV0 upgrades records which were stored as a marshalled user.User
+func migrateGrantV0(j []byte) ([]byte, error) {
+	var u user.User
+	err := json.Unmarshal(j, &u)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(APIAccess{
+		Version: 1,
+		Key:     u.Email,
+		Hash:    u.Hash,
+		Salt:    u.Salt,
+	})
+}
+
+// decodeGrant decrypts and unmarshals a stored record, migrating it to the
+// current schema version if needed. The returned bool reports whether the
+// record was migrated and should be saved again.
+func decodeGrant(j []byte) (*APIAccess, bool, error) {
+	j, err := openRecord(j)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var v struct {
+		Version int `json:"version"`
+	}
+	err = json.Unmarshal(j, &v)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if v.Version > grantSchemaVersion {
+		return nil, false, fmt.Errorf(
+			"grant schema version %d is newer than supported version %d",
+			v.Version, grantSchemaVersion,
+		)
+	}
+
+	for i := v.Version; i < grantSchemaVersion; i++ {
+		j, err = grantMigrations[i](j)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to migrate grant from schema version %d, %v", i, err)
+		}
+	}
+
+	a := new(APIAccess)
+	err = json.Unmarshal(j, a)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return a, v.Version < grantSchemaVersion, nil
+}
+
+// getGrant reads the grant stored for key, returning nil if there is none.
+// The returned bool reports whether the record was migrated.
+func getGrant(tx Tx, key string) (*APIAccess, bool, error) {
+	j, err := tx.Get(apiAccessStore, key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if j == nil {
+		return nil, false, nil
+	}
+
+	return decodeGrant(j)
+}
+
+// putGrant stores a grant at the current schema version. The token and groups
+// are never persisted with the grant.
+func putGrant(tx Tx, a *APIAccess) error {
+	rec := *a
+	rec.Version = grantSchemaVersion
+	rec.Token = ""
+	rec.Groups = nil
+
+	j, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal APIAccess to json, %v", err)
+	}
+
+	j, err = sealRecord(j)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt APIAccess, %v", err)
+	}
+
+	return tx.Put(apiAccessStore, TenantKey(a.Tenant, a.Key), j)
+}
+
+// MigrateGrants upgrades every stored grant to the current schema version and
+// returns the number of records which were migrated. Records are otherwise
+// migrated lazily as they are read and saved.
+func MigrateGrants() (int, error) {
+	return std.MigrateGrants()
+}
+
+// MigrateGrants is the package MigrateGrants for the grants in s's Store
+func (s *Service) MigrateGrants() (int, error) {
+	var migrated int
+	err := s.store.Update(func(tx Tx) error {
+		var upgrades []*APIAccess
+		err := tx.ForEach(apiAccessStore, func(key string, value []byte) error {
+			a, upgraded, err := decodeGrant(value)
+			if err != nil {
+				return fmt.Errorf("failed to decode grant for %s, %v", key, err)
+			}
+
+			if upgraded {
+				upgrades = append(upgrades, a)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, a := range upgrades {
+			err := putGrant(tx, a)
+			if err != nil {
+				return err
+			}
+		}
+
+		migrated = len(upgrades)
+		return nil
+	})
+
+	return migrated, err
+}
+
+// modifyGrant applies fn to the grant stored for key and saves the result
+func (s *Service) modifyGrant(key string, fn func(a *APIAccess) error) error {
+	if key == "" {
+		return fmt.Errorf("%s", "key must not be empty")
+	}
+
+	return s.store.Update(func(tx Tx) error {
+		a, _, err := getGrant(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if a == nil {
+			return notFound(key)
+		}
+
+		err = fn(a)
+		if err != nil {
+			return err
+		}
+
+		return putGrant(tx, a)
+	})
+}
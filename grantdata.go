@@ -0,0 +1,108 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SetGrantData stores value under name for the grant for key, for extensions
+// keeping their own state per grant, such as passkey credentials. A nil value
+// removes name. Grant data is removed along with the grant by ClearGrant, and
+// is encrypted at rest when UseEncryption is set.
+func SetGrantData(key, name string, value []byte) error {
+	return std.SetGrantData(key, name, value)
+}
+
+// SetGrantData is the package SetGrantData for the grants in s's Store
+func (s *Service) SetGrantData(key, name string, value []byte) error {
+	if name == "" {
+		return fmt.Errorf("%s", "name must not be empty")
+	}
+
+	return s.store.Update(func(tx Tx) error {
+		a, _, err := getGrant(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if a == nil {
+			return notFound(key)
+		}
+
+		data, err := grantData(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if value == nil {
+			delete(data, name)
+		} else {
+			data[name] = value
+		}
+
+		if len(data) == 0 {
+			return tx.Delete(apiGrantDataStore, key)
+		}
+
+		j, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		j, err = sealRecord(j)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt grant data, %v", err)
+		}
+
+		return tx.Put(apiGrantDataStore, key, j)
+	})
+}
+
+// GrantData returns the value stored under name for the grant for key, or nil
+// if there is none
+func GrantData(key, name string) ([]byte, error) {
+	return std.GrantData(key, name)
+}
+
+// GrantData is the package GrantData for the grants in s's Store
+func (s *Service) GrantData(key, name string) ([]byte, error) {
+	var value []byte
+	err := s.store.View(func(tx Tx) error {
+		data, err := grantData(tx, key)
+		if err != nil {
+			return err
+		}
+
+		value = data[name]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func grantData(tx Tx, key string) (map[string][]byte, error) {
+	j, err := tx.Get(apiGrantDataStore, key)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string][]byte)
+	if j == nil {
+		return data, nil
+	}
+
+	j, err = openRecord(j)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt grant data for %s, %v", key, err)
+	}
+
+	err = json.Unmarshal(j, &data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode grant data for %s, %v", key, err)
+	}
+
+	return data, nil
+}
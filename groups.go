@@ -0,0 +1,157 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// AddToGroup adds the grant for key to group. Groups are added to tokens as the
+// "groups" claim, so tokens issued before the change keep their previous
+// groups until the next Grant or Login.
+func AddToGroup(group, key string) error {
+	return std.AddToGroup(group, key)
+}
+
+// AddToGroup is the package AddToGroup for the grants in s's Store
+func (s *Service) AddToGroup(group, key string) error {
+	if group == "" {
+		return fmt.Errorf("%s", "group must not be empty")
+	}
+
+	return s.modifyGroups(key, func(groups []string) []string {
+		if containsString(groups, group) {
+			return groups
+		}
+
+		groups = append(groups, group)
+		sort.Strings(groups)
+		return groups
+	})
+}
+
+// RemoveFromGroup removes the grant for key from group
+func RemoveFromGroup(group, key string) error {
+	return std.RemoveFromGroup(group, key)
+}
+
+// RemoveFromGroup is the package RemoveFromGroup for the grants in s's Store
+func (s *Service) RemoveFromGroup(group, key string) error {
+	return s.modifyGroups(key, func(groups []string) []string {
+		kept := groups[:0]
+		for _, g := range groups {
+			if g != group {
+				kept = append(kept, g)
+			}
+		}
+
+		return kept
+	})
+}
+
+// GroupsOf returns the groups the grant for key belongs to
+func GroupsOf(key string) ([]string, error) {
+	return std.GroupsOf(key)
+}
+
+// GroupsOf is the package GroupsOf for the grants in s's Store
+func (s *Service) GroupsOf(key string) ([]string, error) {
+	if key == "" {
+		return nil, fmt.Errorf("%s", "key must not be empty")
+	}
+
+	var groups []string
+	err := s.store.View(func(tx Tx) error {
+		var err error
+		groups, err = groupsOf(tx, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// InGroup validates the access token held within the provided tokenStore and
+// checks whether its grant belongs to group
+func InGroup(req *http.Request, tokenStore reqHeaderOrHTTPCookie, group string) bool {
+	return std.InGroup(req, tokenStore, group)
+}
+
+// InGroup is the package InGroup, verifying tokens with s's Signer
+func (s *Service) InGroup(req *http.Request, tokenStore reqHeaderOrHTTPCookie, group string) bool {
+	claims, ok := s.grantedClaims(req, tokenStore)
+	if !ok {
+		return false
+	}
+
+	return containsString(claimStrings(claims, "groups"), group)
+}
+
+// RequireGroup returns middleware which only calls next for requests holding a
+// valid token whose grant belongs to group. Requests without a valid token are
+// rejected with 401 Unauthorized, and those outside the group with 403 Forbidden.
+func RequireGroup(group string) func(next http.HandlerFunc) http.HandlerFunc {
+	return std.RequireGroup(group)
+}
+
+// RequireGroup is the package RequireGroup, verifying tokens with s's Signer
+func (s *Service) RequireGroup(group string) func(next http.HandlerFunc) http.HandlerFunc {
+	return s.RequireClaim("groups", ClaimContains(group))
+}
+
+func groupsOf(tx Tx, key string) ([]string, error) {
+	j, err := tx.Get(apiGroupStore, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if j == nil {
+		return nil, nil
+	}
+
+	var groups []string
+	err = json.Unmarshal(j, &groups)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode groups for %s, %v", key, err)
+	}
+
+	return groups, nil
+}
+
+// modifyGroups applies fn to the groups of the grant for key and saves them
+func (s *Service) modifyGroups(key string, fn func(groups []string) []string) error {
+	if key == "" {
+		return fmt.Errorf("%s", "key must not be empty")
+	}
+
+	return s.store.Update(func(tx Tx) error {
+		a, _, err := getGrant(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if a == nil {
+			return notFound(key)
+		}
+
+		groups, err := groupsOf(tx, key)
+		if err != nil {
+			return err
+		}
+
+		groups = fn(groups)
+		if len(groups) == 0 {
+			return tx.Delete(apiGroupStore, key)
+		}
+
+		j, err := json.Marshal(groups)
+		if err != nil {
+			return err
+		}
+
+		return tx.Put(apiGroupStore, key, j)
+	})
+}
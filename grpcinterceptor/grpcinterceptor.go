@@ -0,0 +1,83 @@
+// Package grpcinterceptor provides gRPC server interceptors which authenticate
+// calls with access tokens, for services exposing Ponzu data over gRPC.
+//
+// Tokens are read from the "authorization" metadata of each call, as
+// "Bearer <token>", and validated as access.IsGranted does. The identity of a
+// valid token is stored in the call's context, for handlers to read with
+// access.FromContext.
+package grpcinterceptor
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/nilslice/access"
+)
+
+// UnaryServerInterceptor returns an interceptor rejecting unary calls without a
+// valid token with codes.Unauthenticated
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns an interceptor rejecting streams without a
+// valid token with codes.Unauthenticated
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context())
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authenticate returns ctx with the identity of the call's token stored in it
+func authenticate(ctx context.Context) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing access token")
+	}
+
+	var token string
+	for _, v := range md.Get("authorization") {
+		if len(v) > 7 && strings.EqualFold(v[:7], "Bearer ") {
+			token = v[7:]
+			break
+		}
+	}
+
+	if token == "" {
+		return nil, status.Error(codes.Unauthenticated, "missing access token")
+	}
+
+	identity, ok := access.VerifyToken(token)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid access token")
+	}
+
+	return access.NewContext(ctx, identity), nil
+}
+
+// serverStream replaces the context of a grpc.ServerStream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
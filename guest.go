@@ -0,0 +1,112 @@
+package access
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// guestPrefix begins the keys of guests, which have no grant
+const guestPrefix = "anon:"
+
+// maxGuestAge caps how long guest tokens are valid for
+const maxGuestAge = time.Hour
+
+// GrantGuest issues a token with the "anon" claim to a new guest, without
+// storing any credential, so public clients can call endpoints open to guests
+// while being rate limited and audited by their guest key. The token is valid
+// for cfg.ExpireAfter, at most an hour, and is written to cfg.TokenStore as
+// Login's are. Guest tokens are rejected by IsGranted, ValidToken and the other
+// checks for grants, and only let through GateKeeper by the GuestToken
+// Authorizer or a Policy. Revoke one with RevokeGuest.
+func GrantGuest(cfg *Config) (*APIAccess, error) {
+	return std.GrantGuest(cfg)
+}
+
+// GrantGuest is the package GrantGuest, tracking guests in s's Store
+func (s *Service) GrantGuest(cfg *Config) (*APIAccess, error) {
+	err := validateTenantID(cfg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	guestCfg := *cfg
+	if guestCfg.ExpireAfter > maxGuestAge {
+		guestCfg.ExpireAfter = maxGuestAge
+	}
+
+	apiAccess := &APIAccess{
+		Key:    guestPrefix + id,
+		Tenant: cfg.TenantID,
+		Scopes: cfg.Scopes,
+		anon:   true,
+	}
+
+	storeKey := TenantKey(cfg.TenantID, apiAccess.Key)
+	var exp time.Time
+	err = s.store.Update(func(tx Tx) error {
+		exp, err = apiAccess.newToken(s.signer, &guestCfg)
+		if err != nil {
+			return err
+		}
+
+		return startSession(tx, storeKey, apiAccess, &guestCfg, exp)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = apiAccess.writeToken(&guestCfg, exp)
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(AuditGuest, storeKey, cfg.Request, "")
+	return apiAccess, nil
+}
+
+// RevokeGuest revokes every token issued to the guest with key, as found in
+// Identity.Key, namespaced by TenantKey for guests of a tenant
+func RevokeGuest(key string) error {
+	return std.RevokeGuest(key)
+}
+
+// RevokeGuest is the package RevokeGuest for the grants in s's Store
+func (s *Service) RevokeGuest(key string) error {
+	if !isGuestKey(key) {
+		return fmt.Errorf("%s", "key must be the key of a guest")
+	}
+
+	defer s.sessionStates.forget(key)
+	return s.store.Update(func(tx Tx) error {
+		return tx.Delete(apiSessionStore, key)
+	})
+}
+
+// GuestToken authorizes requests holding a valid guest token issued by
+// GrantGuest. Add it to Config.Authorizers along with ValidToken for endpoints
+// open to guests and grants.
+func GuestToken(req *http.Request, identity *Identity) bool {
+	return identity != nil && identity.Anonymous
+}
+
+// isGuest reports whether well formed claims are those of a guest token
+func isGuest(claims map[string]interface{}) bool {
+	anon, _ := claims["anon"].(bool)
+	return anon
+}
+
+// isGuestKey reports whether key, namespaced by TenantKey, is a guest's
+func isGuestKey(key string) bool {
+	if strings.HasPrefix(key, "@") {
+		key = key[strings.Index(key, "/")+1:]
+	}
+
+	return strings.HasPrefix(key, guestPrefix) && len(key) > len(guestPrefix)
+}
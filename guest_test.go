@@ -0,0 +1,66 @@
+package access_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+func TestGrantGuest(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	cfg := headerConfig("")
+	cfg.ExpireAfter = 24 * time.Hour
+	guest, err := access.GrantGuest(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := access.CheckRequest(bearer(http.MethodGet, guest.Token), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp, _ := identity.Claims["exp"].(float64)
+	if time.Unix(int64(exp), 0).After(time.Now().Add(time.Hour + time.Minute)) {
+		t.Errorf("guest token expires at %v, more than an hour away", time.Unix(int64(exp), 0))
+	}
+
+	if access.IsGranted(bearer(http.MethodGet, guest.Token), http.Header{}) {
+		t.Error("guest token accepted by IsGranted")
+	}
+
+	serve := func(cfg *access.Config) int {
+		res := httptest.NewRecorder()
+		cfg.GateKeeper(func(res http.ResponseWriter, req *http.Request) {}).
+			ServeHTTP(res, bearer(http.MethodGet, guest.Token))
+		return res.Code
+	}
+
+	if code := serve(&access.Config{}); code != http.StatusForbidden {
+		t.Errorf("GateKeeper without GuestToken: got status %d, want %d", code, http.StatusForbidden)
+	}
+
+	open := &access.Config{Authorizers: []access.Authorizer{access.ValidToken, access.GuestToken}}
+	if code := serve(open); code != http.StatusOK {
+		t.Errorf("GateKeeper with GuestToken: got status %d, want %d", code, http.StatusOK)
+	}
+
+	err = access.RevokeGuest("owner@example.com")
+	if err == nil {
+		t.Error("RevokeGuest accepted the key of a grant")
+	}
+
+	err = access.RevokeGuest(identity.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code := serve(open); code != http.StatusUnauthorized {
+		t.Errorf("GateKeeper after RevokeGuest: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,433 @@
+package access
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/ponzu-cms/ponzu/system/admin/user"
+)
+
+// hashAlgorithmBcrypt is recorded on grants hashed with bcrypt. Grants without
+// an algorithm were hashed by Ponzu's user.New.
+const hashAlgorithmBcrypt = "bcrypt"
+
+// Hasher hashes grant passwords. The algorithm of each hash is stored with its
+// grant, so passwords are checked by the Hasher which hashed them even after
+// another is chosen with UseHasher.
+type Hasher interface {
+	// Algorithm names the hashes made by the Hasher, such as "argon2id"
+	Algorithm() string
+
+	// Hash returns the hash of password, holding its salt and parameters
+	Hash(password string) (string, error)
+
+	// Verify reports whether password matches hash
+	Verify(hash, password string) bool
+
+	// NeedsRehash reports whether hash was made with parameters other than
+	// the Hasher's
+	NeedsRehash(hash string) bool
+}
+
+// hasherSet is the Hasher hashing new passwords and the Hashers checking
+// stored ones, by algorithm. It is read by every Login, so it is guarded for
+// UseHasher to be called while requests are served.
+type hasherSet struct {
+	mu      sync.RWMutex
+	current Hasher
+	byAlg   map[string]Hasher
+}
+
+func newHasherSet() *hasherSet {
+	return &hasherSet{
+		current: Argon2idHasher{},
+		byAlg: map[string]Hasher{
+			"argon2id":          Argon2idHasher{},
+			"scrypt":            ScryptHasher{},
+			hashAlgorithmBcrypt: BcryptHasher{},
+		},
+	}
+}
+
+// use makes h the Hasher of new passwords, and of stored ones hashed with its
+// algorithm
+func (hs *hasherSet) use(h Hasher) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	hs.current = h
+	hs.byAlg[h.Algorithm()] = h
+}
+
+// hasher returns the Hasher of new passwords
+func (hs *hasherSet) hasher() Hasher {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+
+	return hs.current
+}
+
+// lookup returns the Hasher of passwords hashed with algorithm
+func (hs *hasherSet) lookup(algorithm string) (Hasher, bool) {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+
+	h, ok := hs.byAlg[algorithm]
+	return h, ok
+}
+
+// UseHasher sets the Hasher used to hash grant passwords, and to check the
+// passwords of grants hashed with its algorithm. A nil Hasher restores the
+// default, argon2id with its default parameters. Grants hashed with another
+// algorithm, or other parameters, are transparently re-hashed with h the next
+// time they Login successfully, including grants hashed by Ponzu's user.New.
+// It is safe to call while requests are served.
+func UseHasher(h Hasher) {
+	std.UseHasher(h)
+}
+
+// UseHasher is the package UseHasher for the grants in s's Store
+func (s *Service) UseHasher(h Hasher) {
+	if h == nil {
+		h = Argon2idHasher{}
+	}
+
+	s.hashers.use(h)
+}
+
+// SetHashCost hashes grant passwords with bcrypt at cost, as
+// UseHasher(BcryptHasher{Cost: cost}) does.
+func SetHashCost(cost int) error {
+	return std.SetHashCost(cost)
+}
+
+// SetHashCost is the package SetHashCost for the grants in s's Store
+func (s *Service) SetHashCost(cost int) error {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return fmt.Errorf(
+			"hash cost must be between %d and %d, got %d",
+			bcrypt.MinCost, bcrypt.MaxCost, cost,
+		)
+	}
+
+	s.UseHasher(BcryptHasher{Cost: cost})
+	return nil
+}
+
+// Argon2idHasher hashes passwords with argon2id. Zero parameters take the
+// defaults recommended by RFC 9106: 3 passes over 64 MiB with 4 threads.
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32 // in KiB
+	Threads uint8
+
+	// KeyLen and SaltLen are in bytes, and default to 32 and 16
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+func (h Argon2idHasher) params() Argon2idHasher {
+	if h.Time == 0 {
+		h.Time = 3
+	}
+	if h.Memory == 0 {
+		h.Memory = 64 * 1024
+	}
+	if h.Threads == 0 {
+		h.Threads = 4
+	}
+	if h.KeyLen == 0 {
+		h.KeyLen = 32
+	}
+	if h.SaltLen == 0 {
+		h.SaltLen = 16
+	}
+
+	return h
+}
+
+func (Argon2idHasher) Algorithm() string { return "argon2id" }
+
+// Hash returns the hash of password in the PHC string format, such as
+// "$argon2id$v=19$m=65536,t=3,p=4$salt$key"
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	h = h.params()
+	salt, err := newSalt(h.SaltLen)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (Argon2idHasher) Verify(hash, password string) bool {
+	p, salt, key, ok := parseArgon2id(hash)
+	if !ok {
+		return false
+	}
+
+	got := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1
+}
+
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	p, salt, key, ok := parseArgon2id(hash)
+	h = h.params()
+	return !ok || p.Time != h.Time || p.Memory != h.Memory || p.Threads != h.Threads ||
+		uint32(len(key)) != h.KeyLen || uint32(len(salt)) != h.SaltLen
+}
+
+func parseArgon2id(hash string) (p Argon2idHasher, salt, key []byte, ok bool) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return p, nil, nil, false
+	}
+
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads)
+	if err != nil || p.Time == 0 || p.Threads == 0 {
+		return p, nil, nil, false
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, false
+	}
+
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, false
+	}
+
+	return p, salt, key, true
+}
+
+// ScryptHasher hashes passwords with scrypt. Zero parameters take the defaults
+// N=32768, r=8 and p=1.
+type ScryptHasher struct {
+	N int // a power of 2 greater than 1
+	R int
+	P int
+
+	// KeyLen and SaltLen are in bytes, and default to 32 and 16
+	KeyLen  int
+	SaltLen int
+}
+
+func (h ScryptHasher) params() ScryptHasher {
+	if h.N == 0 {
+		h.N = 1 << 15
+	}
+	if h.R == 0 {
+		h.R = 8
+	}
+	if h.P == 0 {
+		h.P = 1
+	}
+	if h.KeyLen == 0 {
+		h.KeyLen = 32
+	}
+	if h.SaltLen == 0 {
+		h.SaltLen = 16
+	}
+
+	return h
+}
+
+func (ScryptHasher) Algorithm() string { return "scrypt" }
+
+// Hash returns the hash of password in the form
+// "$scrypt$n=32768,r=8,p=1$salt$key"
+func (h ScryptHasher) Hash(password string) (string, error) {
+	h = h.params()
+	salt, err := newSalt(uint32(h.SaltLen))
+	if err != nil {
+		return "", err
+	}
+
+	key, err := scrypt.Key([]byte(password), salt, h.N, h.R, h.P, h.KeyLen)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password, %v", err)
+	}
+
+	return fmt.Sprintf("$scrypt$n=%d,r=%d,p=%d$%s$%s",
+		h.N, h.R, h.P,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (ScryptHasher) Verify(hash, password string) bool {
+	p, salt, key, ok := parseScrypt(hash)
+	if !ok {
+		return false
+	}
+
+	got, err := scrypt.Key([]byte(password), salt, p.N, p.R, p.P, len(key))
+	return err == nil && subtle.ConstantTimeCompare(got, key) == 1
+}
+
+func (h ScryptHasher) NeedsRehash(hash string) bool {
+	p, salt, key, ok := parseScrypt(hash)
+	h = h.params()
+	return !ok || p.N != h.N || p.R != h.R || p.P != h.P ||
+		len(key) != h.KeyLen || len(salt) != h.SaltLen
+}
+
+func parseScrypt(hash string) (p ScryptHasher, salt, key []byte, ok bool) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 || parts[1] != "scrypt" {
+		return p, nil, nil, false
+	}
+
+	_, err := fmt.Sscanf(parts[2], "n=%d,r=%d,p=%d", &p.N, &p.R, &p.P)
+	if err != nil {
+		return p, nil, nil, false
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return p, nil, nil, false
+	}
+
+	key, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, false
+	}
+
+	return p, salt, key, true
+}
+
+// BcryptHasher hashes passwords with bcrypt at Cost, which defaults to
+// bcrypt.DefaultCost. bcrypt only uses the first 72 bytes of a password.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) cost() int {
+	if h.Cost == 0 {
+		return bcrypt.DefaultCost
+	}
+
+	return h.Cost
+}
+
+func (BcryptHasher) Algorithm() string { return hashAlgorithmBcrypt }
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost())
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password, %v", err)
+	}
+
+	return string(hash), nil
+}
+
+func (BcryptHasher) Verify(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.cost()
+}
+
+func newSalt(n uint32) ([]byte, error) {
+	salt := make([]byte, n)
+	_, err := io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate salt, %v", err)
+	}
+
+	return salt, nil
+}
+
+// hashPassword sets a new hash for the password on a with the Hasher set by
+// UseHasher, with the current pepper mixed in if SetPeppers is set
+func (s *Service) hashPassword(a *APIAccess, password string) error {
+	version := currentPepper()
+	password, _ = pepper(password, version)
+
+	hasher := s.hashers.hasher()
+	var hash string
+	var err error
+	busy := hashPool.do(func() {
+		hash, err = hasher.Hash(password)
+	})
+	if busy != nil {
+		return busy
+	}
+	if err != nil {
+		return err
+	}
+
+	a.Hash = hash
+	a.Salt = ""
+	a.HashAlgorithm = hasher.Algorithm()
+	a.PepperVersion = version
+	return nil
+}
+
+// checkPassword reports whether password matches the hash stored on a, or
+// returns ErrBusy if SetHashConcurrency's limits are reached
+func (s *Service) checkPassword(a *APIAccess, password string) (bool, error) {
+	var ok bool
+	err := hashPool.do(func() {
+		ok = s.matchPassword(a, password)
+	})
+
+	return ok, err
+}
+
+func (s *Service) matchPassword(a *APIAccess, password string) bool {
+	if a.HashAlgorithm == "" {
+		return user.IsUser(&user.User{
+			Email: a.Key,
+			Hash:  a.Hash,
+			Salt:  a.Salt,
+		}, password)
+	}
+
+	h, ok := s.hashers.lookup(a.HashAlgorithm)
+	if !ok {
+		return false
+	}
+
+	password, ok = pepper(password, a.PepperVersion)
+	if !ok {
+		return false
+	}
+
+	// grants hashed with bcrypt before Hasher was introduced prefix their
+	// password with a separate salt, as Ponzu does
+	if a.HashAlgorithm == hashAlgorithmBcrypt && a.Salt != "" {
+		salt, err := base64.StdEncoding.DecodeString(a.Salt)
+		if err != nil {
+			return false
+		}
+
+		password = string(salt) + password
+	}
+
+	return h.Verify(a.Hash, password)
+}
+
+// needsRehash reports whether a was hashed with outdated parameters
+func (s *Service) needsRehash(a *APIAccess) bool {
+	hasher := s.hashers.hasher()
+	return a.HashAlgorithm != hasher.Algorithm() || a.Salt != "" ||
+		hasher.NeedsRehash(a.Hash) || a.PepperVersion != currentPepper()
+}
@@ -0,0 +1,42 @@
+package access_test
+
+import (
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+// TestUseHasherWhileServing is meant to be run with -race
+func TestUseHasherWhileServing(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	_, err := access.Grant("hasher@example.com", accesstest.Password, headerConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				_, err := access.Login("hasher@example.com", accesstest.Password, headerConfig(""))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		access.UseHasher(access.BcryptHasher{Cost: bcrypt.MinCost + i%2})
+	}
+
+	wg.Wait()
+}
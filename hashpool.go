@@ -0,0 +1,65 @@
+package access
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrBusy is returned by Login, Grant and the other calls hashing or checking
+// passwords when the limits set by SetHashConcurrency are reached
+var ErrBusy = errors.New("server is busy, try again later")
+
+var hashPool *workPool
+
+// SetHashConcurrency caps the passwords hashed or checked at once at workers,
+// so floods of logins can't use up the CPU. Up to queue more calls wait for a
+// worker, and calls beyond that fail at once with ErrBusy, which handlers
+// answer with 503 Service Unavailable. Zero workers, the default, removes the
+// cap. It must be called before passwords are hashed.
+func SetHashConcurrency(workers, queue int) {
+	if workers <= 0 {
+		hashPool = nil
+		return
+	}
+
+	if queue < 0 {
+		queue = 0
+	}
+
+	hashPool = &workPool{
+		workers: make(chan struct{}, workers),
+		queue:   int64(queue),
+	}
+}
+
+// workPool bounds how many calls of do run at once, and how many may wait
+type workPool struct {
+	workers chan struct{}
+	queue   int64
+	waiting int64
+}
+
+// do runs fn once a worker is free, or returns ErrBusy if the queue is full.
+// A nil pool runs fn at once.
+func (p *workPool) do(fn func()) error {
+	if p == nil {
+		fn()
+		return nil
+	}
+
+	select {
+	case p.workers <- struct{}{}:
+	default:
+		if atomic.AddInt64(&p.waiting, 1) > p.queue {
+			atomic.AddInt64(&p.waiting, -1)
+			return ErrBusy
+		}
+
+		p.workers <- struct{}{}
+		atomic.AddInt64(&p.waiting, -1)
+	}
+	defer func() { <-p.workers }()
+
+	fn()
+	return nil
+}
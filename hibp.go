@@ -0,0 +1,69 @@
+package access
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HaveIBeenPwned is a PasswordChecker using the Pwned Passwords range API.
+// Only the first 5 characters of the password's SHA-1 hash are sent, so the
+// service never learns the password.
+type HaveIBeenPwned struct {
+	// Client sends the requests, and defaults to a client with a 5 second
+	// timeout
+	Client *http.Client
+
+	// URL is the range API endpoint, and defaults to
+	// "https://api.pwnedpasswords.com/range/"
+	URL string
+}
+
+// Breached reports whether password appears in the Pwned Passwords list
+func (h *HaveIBeenPwned) Breached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	url := h.URL
+	if url == "" {
+		url = "https://api.pwnedpasswords.com/range/"
+	}
+
+	client := h.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url+prefix, nil)
+	if err != nil {
+		return false, err
+	}
+
+	// padded responses hide which prefix was requested from observers
+	req.Header.Set("Add-Padding", "true")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("pwned passwords range request failed with status %d", res.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		s, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if ok && s == suffix && count != "0" {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
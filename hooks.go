@@ -0,0 +1,80 @@
+package access
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Hooks are called on lifecycle events of grants, such as to send
+// notifications or keep external systems in sync. Each is optional, and is
+// called synchronously once the event's changes are committed. Keys are the
+// storage keys of grants, namespaced by TenantKey for tenant grants.
+type Hooks struct {
+	// OnGrant is called when Grant creates a grant
+	OnGrant func(key string)
+
+	// OnLogin is called when a token is issued for an existing grant, with the
+	// methods used to authenticate
+	OnLogin func(key string, amr []string)
+
+	// OnLoginFailed is called when Login or Grant fails for an existing or
+	// unknown grant
+	OnLoginFailed func(key string, err error)
+
+	// OnRevoke is called when ClearGrant removes a grant
+	OnRevoke func(key string)
+
+	// OnDeny is called when GateKeeper rejects a request, as Config.OnDenied
+	// is for a single Config
+	OnDeny func(req *http.Request, denial Denial)
+}
+
+// UseHooks sets the Hooks called on lifecycle events, replacing any set before
+func UseHooks(h Hooks) {
+	std.UseHooks(h)
+}
+
+// UseHooks is the package UseHooks for the events of s
+func (s *Service) UseHooks(h Hooks) {
+	s.hooks = h
+}
+
+// grantCreated records the creation of the grant for key
+func (s *Service) grantCreated(key string, req *http.Request) {
+	s.audit(AuditGrant, key, req, "")
+	if s.hooks.OnGrant != nil {
+		s.hooks.OnGrant(key)
+	}
+}
+
+// loggedIn records a token issued for the grant for key
+func (s *Service) loggedIn(key string, req *http.Request, amr []string) {
+	s.audit(AuditLogin, key, req, strings.Join(amr, " "))
+	if s.hooks.OnLogin != nil {
+		s.hooks.OnLogin(key, amr)
+	}
+}
+
+// loginFailed records a failed login for the grant for key
+func (s *Service) loginFailed(key string, req *http.Request, err error) {
+	s.audit(AuditLoginFailed, key, req, err.Error())
+	if s.hooks.OnLoginFailed != nil {
+		s.hooks.OnLoginFailed(key, err)
+	}
+}
+
+// revoked records the removal of the grant for key
+func (s *Service) revoked(key string) {
+	s.audit(AuditRevoke, key, nil, "")
+	if s.hooks.OnRevoke != nil {
+		s.hooks.OnRevoke(key)
+	}
+}
+
+// denied records a request GateKeeper rejected
+func (s *Service) denied(req *http.Request, denial Denial) {
+	s.audit(AuditDenied, denial.Key, req, string(denial.Reason))
+	if s.hooks.OnDeny != nil {
+		s.hooks.OnDeny(req, denial)
+	}
+}
@@ -0,0 +1,161 @@
+package access
+
+import "net/http"
+
+// Identity is the authenticated grant behind a request, as described by the
+// claims of its access token
+type Identity struct {
+	Key    string
+	Tenant string
+	Roles  []string
+	Scopes []string
+	Groups []string
+	Admin  bool
+	Claims map[string]interface{}
+
+	// Anonymous is set for guests issued tokens by GrantGuest, whose Key is
+	// their guest key rather than a grant's
+	Anonymous bool
+
+	// ServiceAccount is set for service accounts created by
+	// CreateServiceAccount
+	ServiceAccount bool
+
+	// Chain lists the session IDs of the tokens a token minted by Delegate
+	// descends from, oldest first, and is empty for other tokens
+	Chain []string
+
+	// SessionID is the ID of the token's session, as listed by ListSessions,
+	// or empty for tokens without one and for API keys and signed requests
+	SessionID string
+
+	// Source is the token store the token was read from, such as http.Header
+	// or QueryParam, or APIKeyHeader or SignatureHeader for API keys and signed
+	// requests, when known
+	Source interface{}
+}
+
+// HasRole reports whether the identity holds role, directly or through the
+// role hierarchy
+func (id *Identity) HasRole(role string) bool {
+	return id != nil && hasRole(id.Roles, role)
+}
+
+// IsOwner reports whether the identity is the grant for key. For tenant grants,
+// key is the namespaced key returned by TenantKey.
+func (id *Identity) IsOwner(key string) bool {
+	return id != nil && sameKey(TenantKey(id.Tenant, id.Key), key)
+}
+
+// HasScope reports whether the identity's token was issued with scope
+func (id *Identity) HasScope(scope string) bool {
+	return id != nil && containsString(id.Scopes, scope)
+}
+
+// InGroup reports whether the identity's grant belongs to group
+func (id *Identity) InGroup(group string) bool {
+	return id != nil && containsString(id.Groups, group)
+}
+
+func identityFromClaims(claims map[string]interface{}) *Identity {
+	key, _ := claims["access"].(string)
+	tenant, _ := claims["tenant"].(string)
+	admin, _ := claims["admin"].(bool)
+	jti, _ := claims["jti"].(string)
+	anon, _ := claims["anon"].(bool)
+	svc, _ := claims["svc"].(bool)
+
+	return &Identity{
+		Key:    key,
+		Tenant: tenant,
+		Roles:  claimStrings(claims, "roles"),
+		Scopes: claimStrings(claims, "scopes"),
+		Groups: claimStrings(claims, "groups"),
+		Admin:  admin,
+		Claims: copyClaims(claims),
+
+		Anonymous:      anon,
+		ServiceAccount: svc,
+		Chain:          claimStrings(claims, "chain"),
+		SessionID:      jti,
+	}
+}
+
+// IdentityOf validates the access token held within the provided tokenStore
+// and returns the identity it describes. Guest tokens are rejected, as are
+// tokens read from the access cookie by state-changing requests without their
+// CSRF token.
+func IdentityOf(req *http.Request, tokenStore reqHeaderOrHTTPCookie) (*Identity, bool) {
+	return std.IdentityOf(req, tokenStore)
+}
+
+// IdentityOf is the package IdentityOf, verifying tokens with s's Signer
+func (s *Service) IdentityOf(req *http.Request, tokenStore reqHeaderOrHTTPCookie) (*Identity, bool) {
+	token, source, err := findToken(req, tokenStore)
+	if err != nil {
+		return nil, false
+	}
+
+	claims, ok := s.tokenClaims(token, configSigner(tokenStore))
+	if !ok || isGuest(claims) || !forAudience(tokenStore, claims) ||
+		!boundToClient(req, claims) || !fromAllowedNetwork(req, claims) {
+		return nil, false
+	}
+
+	if _, ok := source.(http.Cookie); ok && !validCSRF(req, claims) {
+		return nil, false
+	}
+
+	identity := identityFromClaims(claims)
+	identity.Source = source
+	return identity, true
+}
+
+// VerifyToken validates token as IsGranted does and returns the identity it
+// describes, for tokens received other than in an HTTP request
+func VerifyToken(token string) (*Identity, bool) {
+	return std.VerifyToken(token)
+}
+
+// VerifyToken is the package VerifyToken, verifying tokens with s's Signer
+func (s *Service) VerifyToken(token string) (*Identity, bool) {
+	claims, ok := s.tokenClaims(token, nil)
+	if !ok || isGuest(claims) {
+		return nil, false
+	}
+
+	return identityFromClaims(claims), true
+}
+
+// copyClaims returns a copy of claims for callers to keep, as claims may be
+// shared by the token cache. Lists and objects are copied too, so callers
+// can't reach the cached claims through them.
+func copyClaims(claims map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(claims))
+	for k, v := range claims {
+		c[k] = copyClaim(v)
+	}
+
+	return c
+}
+
+func copyClaim(claim interface{}) interface{} {
+	switch v := claim.(type) {
+	case map[string]interface{}:
+		return copyClaims(v)
+
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, e := range v {
+			c[i] = copyClaim(e)
+		}
+
+		return c
+
+	case []string:
+		return append([]string(nil), v...)
+
+	default:
+		return v
+	}
+}
@@ -0,0 +1,255 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// inviteRecord is the stored value for an invitation in the __apiInvite
+// bucket, keyed by the hash of its token, holding the grant to create once the
+// invitation is accepted
+type inviteRecord struct {
+	Expires time.Time `json:"expires"`
+	Grant   APIAccess `json:"grant"`
+}
+
+var (
+	inviteMu  sync.RWMutex
+	inviteTTL = 7 * 24 * time.Hour
+	inviteURL string
+)
+
+// SetInviteTTL sets how long invitations are valid for, which is a week by
+// default
+func SetInviteTTL(ttl time.Duration) {
+	inviteMu.Lock()
+	inviteTTL = ttl
+	inviteMu.Unlock()
+}
+
+// SetInviteURL sets the link sent by the Mailer to accept an invitation, to
+// which the token is added as the "token" query parameter
+func SetInviteURL(u string) {
+	inviteMu.Lock()
+	inviteURL = u
+	inviteMu.Unlock()
+}
+
+// inviteSettings returns the TTL and URL of invitations
+func inviteSettings() (time.Duration, string) {
+	inviteMu.RLock()
+	defer inviteMu.RUnlock()
+
+	return inviteTTL, inviteURL
+}
+
+// Invite adds key to pending status, as Pending does, and returns a token which
+// grants it access when passed to AcceptInvite with a password of the invitee's
+// choosing, such as for an admin inviting a partner. The grant is created in
+// cfg.TenantID with cfg.Scopes and cfg.Metadata. If a Mailer has been set, the
+// token is also sent to key as a link to the invite URL. Only a hash of the
+// token is stored.
+func Invite(key string, cfg *Config) (string, error) {
+	return std.Invite(key, cfg)
+}
+
+// Invite is the package Invite for s's Store
+func (s *Service) Invite(key string, cfg *Config) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("%s", "key must not be empty")
+	}
+
+	err := validateTenantID(cfg.TenantID)
+	if err != nil {
+		return "", err
+	}
+
+	err = validateLocalKey(key, cfg.TenantID)
+	if err != nil {
+		return "", err
+	}
+
+	ttl, link := inviteSettings()
+	j, err := json.Marshal(inviteRecord{
+		Expires: time.Now().Add(ttl),
+		Grant: APIAccess{
+			Key:      key,
+			Tenant:   cfg.TenantID,
+			Scopes:   cfg.Scopes,
+			Metadata: cfg.Metadata,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	j, err = sealRecord(j)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt invitation, %v", err)
+	}
+
+	token, hash, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	storeKey := TenantKey(cfg.TenantID, key)
+	err = s.store.Update(func(tx Tx) error {
+		err := checkRegistrable(tx, storeKey)
+		if err != nil {
+			return err
+		}
+
+		p, err := newPendingRecord()
+		if err != nil {
+			return err
+		}
+
+		err = tx.Put(apiPendingUserStore, storeKey, p)
+		if err != nil {
+			return err
+		}
+
+		return tx.Put(apiInviteStore, hash, j)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = s.sendMail(key, MailInvite, token, tokenLink(link, token))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// AcceptInvite creates the grant an invitation token was issued for with
+// password, which must meet the PasswordPolicy, and issues its token as Grant
+// does. The invitation can't be used again once accepted.
+func AcceptInvite(token, password string, cfg *Config) (*APIAccess, error) {
+	return std.AcceptInvite(token, password, cfg)
+}
+
+// AcceptInvite is the package AcceptInvite for s's Store
+func (s *Service) AcceptInvite(token, password string, cfg *Config) (*APIAccess, error) {
+	if password == "" {
+		return nil, fmt.Errorf("%s", "password must not be empty")
+	}
+
+	// the invitation is checked before the password, whose PasswordChecker
+	// may make network calls and whose hash is costly, so requests with made
+	// up tokens are turned away cheaply
+	var expired bool
+	hash := hashSecret(token)
+	err := s.store.View(func(tx Tx) error {
+		rec, err := readInvite(tx, hash)
+		if err != nil {
+			return err
+		}
+
+		if time.Now().After(rec.Expires) {
+			expired = true
+			return nil
+		}
+
+		active, err := tx.Get(apiAccessStore, TenantKey(rec.Grant.Tenant, rec.Grant.Key))
+		if err != nil {
+			return err
+		}
+
+		if active != nil {
+			return ErrDuplicateKey
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if expired {
+		return nil, s.expireToken(apiInviteStore, hash, "invitation token has expired")
+	}
+
+	err = s.checkNewPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	hashed := &APIAccess{}
+	err = s.hashPassword(hashed, password)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiAccess *APIAccess
+	var exp time.Time
+	err = s.store.Update(func(tx Tx) error {
+		rec, err := readInvite(tx, hash)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Delete(apiInviteStore, hash)
+		if err != nil {
+			return err
+		}
+
+		if time.Now().After(rec.Expires) {
+			expired = true
+			return nil
+		}
+
+		apiAccess = &rec.Grant
+		apiAccess.Hash = hashed.Hash
+		apiAccess.Salt = hashed.Salt
+		apiAccess.HashAlgorithm = hashed.HashAlgorithm
+		apiAccess.PepperVersion = hashed.PepperVersion
+
+		exp, err = s.activatePending(tx, apiAccess, cfg)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if expired {
+		return nil, fmt.Errorf("%s", "invitation token has expired")
+	}
+
+	err = apiAccess.writeToken(cfg, exp)
+	if err != nil {
+		return nil, err
+	}
+
+	s.grantCreated(TenantKey(apiAccess.Tenant, apiAccess.Key), cfg.Request)
+	return apiAccess, nil
+}
+
+// readInvite returns the record of the invitation token stored under hash
+func readInvite(tx Tx, hash string) (*inviteRecord, error) {
+	j, err := tx.Get(apiInviteStore, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if j == nil {
+		return nil, fmt.Errorf("%s", "invalid invitation token")
+	}
+
+	j, err = openRecord(j)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt invitation, %v", err)
+	}
+
+	var rec inviteRecord
+	err = json.Unmarshal(j, &rec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode invitation, %v", err)
+	}
+
+	return &rec, nil
+}
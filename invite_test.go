@@ -0,0 +1,112 @@
+package access_test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+// sentMail records the messages a Mailer is asked to send
+type sentMail struct {
+	to       string
+	template string
+	data     map[string]string
+}
+
+type recordingMailer struct {
+	sent []sentMail
+}
+
+func (m *recordingMailer) Send(to, template string, data map[string]string) error {
+	m.sent = append(m.sent, sentMail{to, template, data})
+	return nil
+}
+
+func TestInvite(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	mailer := &recordingMailer{}
+	access.UseMailer(mailer)
+	access.SetInviteURL("https://example.com/accept")
+	t.Cleanup(func() {
+		access.UseMailer(nil)
+		access.SetInviteURL("")
+	})
+
+	token, err := access.Invite("partner@example.com", &access.Config{
+		Scopes: []string{"reports:read"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mailer.sent) != 1 || mailer.sent[0].to != "partner@example.com" ||
+		mailer.sent[0].template != access.MailInvite {
+		t.Fatalf("got mail %+v, want one invitation to partner@example.com", mailer.sent)
+	}
+
+	link := mailer.sent[0].data["link"]
+	if !strings.HasPrefix(link, "https://example.com/accept?") || !strings.Contains(link, token) {
+		t.Errorf("got link %q, want the invite URL with the token", link)
+	}
+
+	err = access.Check("partner@example.com")
+	if !errors.Is(err, access.ErrPending) {
+		t.Errorf("Check: got %v, want ErrPending", err)
+	}
+
+	_, err = access.Invite("partner@example.com", &access.Config{})
+	if err == nil {
+		t.Error("key invited twice")
+	}
+
+	a, err := access.AcceptInvite(token, accesstest.Password, headerConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a.Scopes) != 1 || a.Scopes[0] != "reports:read" {
+		t.Errorf("got scopes %v, want the invitation's", a.Scopes)
+	}
+
+	if !access.IsGranted(bearer(http.MethodGet, a.Token), http.Header{}) {
+		t.Error("token of an accepted invitation rejected")
+	}
+
+	_, err = access.AcceptInvite(token, "another "+accesstest.Password, headerConfig(""))
+	if err == nil {
+		t.Error("invitation accepted twice")
+	}
+
+	_, err = access.Login("partner@example.com", accesstest.Password, headerConfig(""))
+	if err != nil {
+		t.Errorf("password chosen when accepting rejected: %v", err)
+	}
+}
+
+func TestInviteExpired(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	access.SetInviteTTL(-time.Minute)
+	t.Cleanup(func() { access.SetInviteTTL(7 * 24 * time.Hour) })
+
+	token, err := access.Invite("late@example.com", &access.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = access.AcceptInvite(token, accesstest.Password, headerConfig(""))
+	if err == nil {
+		t.Fatal("expired invitation accepted")
+	}
+
+	_, err = access.GetGrant("late@example.com")
+	if !errors.Is(err, access.ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,351 @@
+package access
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers the hashes of RS256 and ES256
+	_ "crypto/sha512" // registers the hashes of RS384, RS512, ES384 and ES512
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minRSABits is the smallest RSA key accepted from a JWKS document
+const minRSABits = 2048
+
+// jwk is a JSON Web Key, as published in a JWKS document
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the RSA or ECDSA public key k describes
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("%s", "RSA exponent is too large")
+		}
+
+		if n.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key is smaller than %d bits", minRSABits)
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("%s", "EC point is not on its curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("%s", "malformed key parameter")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// fetchJWKS returns the signing keys published at url by their key IDs
+func fetchJWKS(client *http.Client, url string) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	err := getJSON(client, url, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		pub, err := k.publicKey()
+		if err != nil {
+			// keys of unsupported types are skipped, as a set may hold keys
+			// for other uses
+			continue
+		}
+
+		keys[k.Kid] = pub
+	}
+
+	return keys, nil
+}
+
+// getJSON decodes the JSON document at url into v
+func getJSON(client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	return doJSON(client, req, v)
+}
+
+// doJSON sends req with client, or a client with a 5 second timeout if it is
+// nil, and decodes the JSON response into v
+func doJSON(client *http.Client, req *http.Request, v interface{}) error {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s, %v", req.URL, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch %s, status %d", req.URL, res.StatusCode)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s, %v", req.URL, err)
+	}
+
+	return nil
+}
+
+// jwtHeaderOf returns the "alg" and "kid" of token's header
+func jwtHeaderOf(token string) (alg, kid string, ok bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", "", false
+	}
+
+	j, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", "", false
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	err = json.Unmarshal(j, &header)
+	if err != nil {
+		return "", "", false
+	}
+
+	return header.Alg, header.Kid, true
+}
+
+// verifyJWTSignature reports whether token is signed with key using alg, one of
+// the RS and ES algorithms. HMAC and "none" are never accepted, so a public key
+// can't be used as a shared secret.
+func verifyJWTSignature(token, alg string, key crypto.PublicKey) bool {
+	i := strings.LastIndex(token, ".")
+	if i < 0 || len(alg) != 5 {
+		return false
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil {
+		return false
+	}
+
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return false
+	}
+
+	h := hash.New()
+	h.Write([]byte(token[:i]))
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, hash, digest, sig) == nil
+
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return false
+		}
+
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(pub, digest, r, s)
+
+	default:
+		return false
+	}
+}
+
+// minKeyRefresh limits how often a keySet is fetched again for tokens signed
+// by unknown keys, so they can't be used to flood the key's publisher
+const minKeyRefresh = time.Minute
+
+// keySet caches the signing keys published in a JWKS document. The keys are
+// fetched again without holding its lock, so tokens signed by cached keys are
+// still checked while a fetch is under way.
+type keySet struct {
+	client *http.Client
+	url    string
+	ttl    time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetched   time.Time
+	attempted time.Time
+
+	// fetching is closed when the fetch under way, if any, is done
+	fetching chan struct{}
+	err      error
+}
+
+func newKeySet(client *http.Client, url string, ttl time.Duration) *keySet {
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+
+	return &keySet{client: client, url: url, ttl: ttl}
+}
+
+// refresh fetches the keys again, keeping those cached before if it fails
+func (ks *keySet) refresh() error {
+	ks.mu.Lock()
+	done := ks.fetch(nil)
+	ks.mu.Unlock()
+
+	<-done
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	return ks.err
+}
+
+// fetch starts fetching the keys again, unless a fetch is already under way,
+// and returns a channel closed once it is done. Failures are logged to logger
+// if it isn't nil. ks.mu must be held.
+func (ks *keySet) fetch(logger Logger) chan struct{} {
+	if ks.fetching != nil {
+		return ks.fetching
+	}
+
+	done := make(chan struct{})
+	ks.fetching = done
+	ks.attempted = time.Now()
+
+	go func() {
+		keys, err := fetchJWKS(ks.client, ks.url)
+
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+
+		ks.err = err
+		if err == nil {
+			ks.keys = keys
+			ks.fetched = time.Now()
+		} else if logger != nil {
+			logger.Error("failed to refresh signing keys", "url", ks.url, "err", err)
+		}
+
+		ks.fetching = nil
+		close(done)
+	}()
+
+	return done
+}
+
+// key returns the key with ID kid, fetching the keys again if they are stale
+// or don't hold it, such as after the publisher rotated its keys. Stale keys
+// are returned while they are fetched, and only unknown keys wait for it.
+func (ks *keySet) key(kid string, logger Logger) (crypto.PublicKey, bool) {
+	ks.mu.Lock()
+	key, ok := ks.keys[kid]
+	stale := time.Since(ks.fetched) > ks.ttl
+	if (ok && !stale) || (ks.fetching == nil && time.Since(ks.attempted) < minKeyRefresh) {
+		ks.mu.Unlock()
+		return key, ok
+	}
+
+	done := ks.fetch(logger)
+	ks.mu.Unlock()
+
+	if ok {
+		return key, true
+	}
+
+	<-done
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok = ks.keys[kid]
+	return key, ok
+}
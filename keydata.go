@@ -0,0 +1,394 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// KeyData is every record held about a key, as returned by ExportKeyData to
+// answer data subject access requests
+type KeyData struct {
+	// Key is the namespaced key returned by TenantKey
+	Key string `json:"key"`
+
+	// Grant is the active grant for key, with its groups and without its
+	// password hash, salt or TOTP secret
+	Grant *APIAccess `json:"grant,omitempty"`
+
+	// Pending reports whether key is pending
+	Pending bool `json:"pending,omitempty"`
+
+	GrantData map[string][]byte `json:"grant_data,omitempty"`
+
+	// ACL holds the permissions of the grant, by resource ID
+	ACL map[string][]string `json:"acl,omitempty"`
+
+	APIKeys  []APIKey     `json:"api_keys,omitempty"`
+	Sessions []Session    `json:"sessions,omitempty"`
+	Audit    []AuditEvent `json:"audit,omitempty"`
+
+	// Usage holds the requests counted by MeterUsage, by billing period
+	Usage map[string]int `json:"usage,omitempty"`
+
+	// Deleted is set if a grant for key was removed by ClearGrant and can
+	// still be restored
+	Deleted *DeletedGrant `json:"deleted,omitempty"`
+}
+
+// ExportKeyData returns every record held about the grant for key: the grant
+// and its groups, grant data, ACL entries, API keys, sessions, audit events
+// and usage, along with whether it is pending or was removed by ClearGrant.
+// For tenant grants, key should be the namespaced key returned by TenantKey.
+func ExportKeyData(key string) (*KeyData, error) {
+	return std.ExportKeyData(key)
+}
+
+// ExportKeyData is the package ExportKeyData for the records in s's Store
+func (s *Service) ExportKeyData(key string) (*KeyData, error) {
+	if key == "" {
+		return nil, fmt.Errorf("%s", "key must not be empty")
+	}
+
+	data := &KeyData{Key: key}
+	err := s.store.View(func(tx Tx) error {
+		a, _, err := getGrant(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if a != nil {
+			grant := a.redacted()
+			grant.Groups, err = groupsOf(tx, key)
+			if err != nil {
+				return err
+			}
+
+			data.Grant = &grant
+		}
+
+		pending, err := tx.Get(apiPendingUserStore, key)
+		if err != nil {
+			return err
+		}
+
+		data.Pending = pending != nil
+
+		grantData, err := grantData(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if len(grantData) > 0 {
+			data.GrantData = grantData
+		}
+
+		err = tx.ForEach(apiACLStore, func(resourceID string, value []byte) error {
+			var acl map[string][]string
+			err := json.Unmarshal(value, &acl)
+			if err != nil {
+				return fmt.Errorf("failed to decode ACL of %s, %v", resourceID, err)
+			}
+
+			if perms, ok := acl[key]; ok {
+				if data.ACL == nil {
+					data.ACL = make(map[string][]string)
+				}
+
+				data.ACL[resourceID] = perms
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = forEachAPIKey(tx, key, func(hash string, k APIKey) error {
+			data.APIKeys = append(data.APIKeys, k)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		data.Sessions, err = sessions(tx, key)
+		if err != nil {
+			return err
+		}
+
+		err = tx.ForEach(apiAuditStore, func(id string, value []byte) error {
+			var ev AuditEvent
+			err := json.Unmarshal(value, &ev)
+			if err != nil {
+				return fmt.Errorf("failed to decode audit event %s, %v", id, err)
+			}
+
+			if ev.Key == key {
+				data.Audit = append(data.Audit, ev)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.ForEach(apiUsageStore, func(id string, value []byte) error {
+			period, k, _ := strings.Cut(id, "|")
+			if k != key {
+				return nil
+			}
+
+			count, err := usageCount(tx, id)
+			if err != nil {
+				return err
+			}
+
+			if data.Usage == nil {
+				data.Usage = make(map[string]int)
+			}
+
+			data.Usage[period] = count
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		d, err := getDeletedGrant(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if d != nil && !d.expired(time.Now()) {
+			data.Deleted = &DeletedGrant{Key: key, DeletedAt: d.DeletedAt}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// EraseKeyData removes every record held about the grant for key, to answer
+// data subject erasure requests. Unlike ClearGrant, the grant isn't kept for
+// RestoreGrant. Its pending status, groups, grant data, ACL entries, API keys,
+// sessions, usage, rate limit state and outstanding reset, verification,
+// invitation and nonce records are removed, and its audit events are kept for
+// their times and types but have their key and IP address removed. Only the
+// token version of key is kept, holding nothing but a number, so that tokens
+// issued for the grant can't be accepted again. For tenant grants, key should
+// be the namespaced key returned by TenantKey.
+func EraseKeyData(key string) error {
+	return std.EraseKeyData(key)
+}
+
+// EraseKeyData is the package EraseKeyData for the records in s's Store
+func (s *Service) EraseKeyData(key string) error {
+	if key == "" {
+		return fmt.Errorf("%s", "key must not be empty")
+	}
+
+	defer s.sessionStates.forget(key)
+	return s.store.Update(func(tx Tx) error {
+		err := revokeTokens(tx, key)
+		if err != nil {
+			return err
+		}
+
+		err = clearAPIKeys(tx, key)
+		if err != nil {
+			return err
+		}
+
+		for _, bucket := range []string{
+			apiAccessStore,
+			apiPendingUserStore,
+			apiGroupStore,
+			apiGrantDataStore,
+			apiServiceCredentialStore,
+			apiAccessDeletedStore,
+		} {
+			err = tx.Delete(bucket, key)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.Delete(apiRateLimitStore, "key:"+key)
+		if err != nil {
+			return err
+		}
+
+		err = deleteWhere(tx, apiUsageStore, func(id string, value []byte) (bool, error) {
+			_, k, _ := strings.Cut(id, "|")
+			return k == key, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// ids of request windows are prefixed by a limit and window, which
+		// hold no colon
+		err = deleteWhere(tx, apiRequestLimitStore, func(id string, value []byte) (bool, error) {
+			_, k, _ := strings.Cut(id, ":")
+			return k == key, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = deleteWhere(tx, apiNonceStore, func(id string, value []byte) (bool, error) {
+			var rec nonceRecord
+			return json.Unmarshal(value, &rec) == nil && rec.Key == key, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = deleteWhere(tx, apiResetStore, func(id string, value []byte) (bool, error) {
+			var rec resetRecord
+			return json.Unmarshal(value, &rec) == nil && rec.Key == key, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// verification and invitation records hold the grant they create,
+		// possibly sealed by UseEncryption
+		for _, bucket := range []string{apiVerifyStore, apiInviteStore} {
+			err = deleteWhere(tx, bucket, func(id string, value []byte) (bool, error) {
+				j, err := openRecord(value)
+				if err != nil {
+					return false, fmt.Errorf("failed to decrypt %s record, %v", bucket, err)
+				}
+
+				var rec struct {
+					Grant APIAccess `json:"grant"`
+				}
+
+				return json.Unmarshal(j, &rec) == nil &&
+					TenantKey(rec.Grant.Tenant, rec.Grant.Key) == key, nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		err = eraseFromACLs(tx, key)
+		if err != nil {
+			return err
+		}
+
+		return anonymizeAudit(tx, key)
+	})
+}
+
+// deleteWhere deletes the records of bucket which match reports true for
+func deleteWhere(tx Tx, bucket string, match func(id string, value []byte) (bool, error)) error {
+	var ids []string
+	err := tx.ForEach(bucket, func(id string, value []byte) error {
+		ok, err := match(id, value)
+		if ok {
+			ids = append(ids, id)
+		}
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err = tx.Delete(bucket, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// eraseFromACLs removes the grant for key from the ACL of every resource
+func eraseFromACLs(tx Tx, key string) error {
+	changed := make(map[string]map[string][]string)
+	err := tx.ForEach(apiACLStore, func(resourceID string, value []byte) error {
+		var acl map[string][]string
+		err := json.Unmarshal(value, &acl)
+		if err != nil {
+			return fmt.Errorf("failed to decode ACL of %s, %v", resourceID, err)
+		}
+
+		if _, ok := acl[key]; ok {
+			delete(acl, key)
+			changed[resourceID] = acl
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for resourceID, acl := range changed {
+		if len(acl) == 0 {
+			err = tx.Delete(apiACLStore, resourceID)
+		} else {
+			var j []byte
+			j, err = json.Marshal(acl)
+			if err == nil {
+				err = tx.Put(apiACLStore, resourceID, j)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// anonymizeAudit removes the key and IP address from the audit events of the
+// grant for key
+func anonymizeAudit(tx Tx, key string) error {
+	changed := make(map[string][]byte)
+	err := tx.ForEach(apiAuditStore, func(id string, value []byte) error {
+		var ev AuditEvent
+		err := json.Unmarshal(value, &ev)
+		if err != nil {
+			return fmt.Errorf("failed to decode audit event %s, %v", id, err)
+		}
+
+		if ev.Key != key {
+			return nil
+		}
+
+		ev.Key = ""
+		ev.IP = ""
+
+		j, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+
+		changed[id] = j
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for id, j := range changed {
+		err = tx.Put(apiAuditStore, id, j)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
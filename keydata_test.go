@@ -0,0 +1,69 @@
+package access_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+func TestEraseKeyData(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	token := accesstest.Token(t, "erase@example.com")
+	err := access.SetGrantData("erase@example.com", "profile", []byte("personal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = access.SetACL("doc-1", "erase@example.com", "read")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := access.ExportKeyData("erase@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.Grant == nil || len(data.Sessions) == 0 || len(data.ACL) == 0 || len(data.GrantData) == 0 {
+		t.Fatalf("export is missing records: %+v", data)
+	}
+
+	err = access.EraseKeyData("erase@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = access.ExportKeyData("erase@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.Grant != nil || data.Pending || len(data.Sessions) != 0 || len(data.ACL) != 0 ||
+		len(data.GrantData) != 0 || len(data.APIKeys) != 0 || len(data.Audit) != 0 {
+		t.Errorf("records remain after EraseKeyData: %+v", data)
+	}
+
+	acl, err := access.GetACL("doc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(acl) != 0 {
+		t.Errorf("ACL entries remain after EraseKeyData: %v", acl)
+	}
+
+	err = access.RestoreGrant("erase@example.com")
+	if !errors.Is(err, access.ErrNotFound) {
+		t.Errorf("RestoreGrant after EraseKeyData: got %v, want ErrNotFound", err)
+	}
+
+	// tokens of the erased grant stay revoked when the key is granted again
+	accesstest.Token(t, "erase@example.com")
+	if access.IsGranted(accesstest.Request(http.MethodGet, "/", token), http.Header{}) {
+		t.Error("token of an erased grant accepted by a new grant at its key")
+	}
+}
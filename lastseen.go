@@ -0,0 +1,151 @@
+package access
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	lastSeenIntervalMu sync.RWMutex
+	lastSeenInterval   time.Duration
+)
+
+// lastSeenSize bounds how many grants a Service remembers recording as seen.
+// Grants forgotten before their interval is up are only recorded early.
+const lastSeenSize = 10000
+
+// SetLastSeenInterval records the time each grant was last seen holding a
+// valid token, API key or signature, as its LastSeenAt, writing it at most
+// once per interval per grant so busy grants don't write on every request. A
+// zero interval, the default, disables it. LastLoginAt is always recorded.
+func SetLastSeenInterval(interval time.Duration) {
+	lastSeenIntervalMu.Lock()
+	lastSeenInterval = interval
+	lastSeenIntervalMu.Unlock()
+}
+
+// currentLastSeenInterval returns the interval set by SetLastSeenInterval
+func currentLastSeenInterval() time.Duration {
+	lastSeenIntervalMu.RLock()
+	defer lastSeenIntervalMu.RUnlock()
+
+	return lastSeenInterval
+}
+
+// ListDormant returns the grants which haven't logged in or been seen since
+// before, in key order, such as to expire unused API keys. Grants never seen
+// are dormant since their last login. Password hashes, salts and TOTP secrets
+// are omitted from the returned grants.
+func ListDormant(before time.Time) ([]APIAccess, error) {
+	return std.ListDormant(before)
+}
+
+// ListDormant is the package ListDormant for the grants in s's Store
+func (s *Service) ListDormant(before time.Time) ([]APIAccess, error) {
+	grants := []APIAccess{}
+	err := s.store.View(func(tx Tx) error {
+		return tx.ForEach(apiAccessStore, func(key string, value []byte) error {
+			a, _, err := decodeGrant(value)
+			if err != nil {
+				return fmt.Errorf("failed to decode grant for %s, %v", key, err)
+			}
+
+			if a.LastLoginAt.Before(before) && a.LastSeenAt.Before(before) {
+				grants = append(grants, a.redacted())
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+// markSeen records that the grant of claims was seen, unless it was recorded
+// within lastSeenInterval. Guests have no grant, and are skipped. The write is
+// made in its own transaction, and failures are logged.
+func (s *Service) markSeen(claims map[string]interface{}) {
+	interval := currentLastSeenInterval()
+	if interval <= 0 || isGuest(claims) {
+		return
+	}
+
+	key := claimKey(claims)
+	now := time.Now()
+	if !s.seen.record(key, now, interval) {
+		return
+	}
+
+	err := s.store.Update(func(tx Tx) error {
+		a, _, err := getGrant(tx, key)
+		if err != nil || a == nil {
+			return err
+		}
+
+		a.LastSeenAt = now
+		return putGrant(tx, a)
+	})
+	if err != nil {
+		s.logger.Error("failed to record grant last seen", "key", key, "err", err)
+	}
+}
+
+// seenWrites is an LRU cache of the times grants were last recorded as seen,
+// so markSeen writes each at most once per interval. Entries are kept in the
+// order they were written, so those older than the interval are dropped from
+// the back.
+type seenWrites struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type seenWrite struct {
+	key string
+	at  time.Time
+}
+
+func newSeenWrites(size int) *seenWrites {
+	return &seenWrites{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// record reports whether the grant for key is due to be recorded as seen at
+// now, remembering that it was if so
+func (c *seenWrites) record(key string, now time.Time, interval time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for oldest := c.order.Back(); oldest != nil; oldest = c.order.Back() {
+		w := oldest.Value.(*seenWrite)
+		if now.Sub(w.at) < interval {
+			break
+		}
+
+		c.order.Remove(oldest)
+		delete(c.entries, w.key)
+	}
+
+	if _, ok := c.entries[key]; ok {
+		return false
+	}
+
+	c.entries[key] = c.order.PushFront(&seenWrite{key: key, at: now})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*seenWrite).key)
+	}
+
+	return true
+}
@@ -0,0 +1,44 @@
+package access_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+func TestLastSeen(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	access.SetLastSeenInterval(time.Hour)
+	t.Cleanup(func() { access.SetLastSeenInterval(0) })
+
+	token := accesstest.Token(t, "seen@example.com")
+	if !access.IsGranted(bearer(http.MethodGet, token), http.Header{}) {
+		t.Fatal("token rejected")
+	}
+
+	a, err := access.GetGrant("seen@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.LastSeenAt.IsZero() {
+		t.Fatal("LastSeenAt not recorded")
+	}
+
+	if !access.IsGranted(bearer(http.MethodGet, token), http.Header{}) {
+		t.Fatal("token rejected")
+	}
+
+	again, err := access.GetGrant("seen@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !again.LastSeenAt.Equal(a.LastSeenAt) {
+		t.Error("LastSeenAt recorded again within the interval")
+	}
+}
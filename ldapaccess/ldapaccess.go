@@ -0,0 +1,147 @@
+// Package ldapaccess checks the passwords of access grants against an LDAP
+// directory, such as Active Directory, so API consumers log in with their
+// directory credentials while tokens are still issued by the access package.
+//
+// A Verifier is an access.CredentialVerifier: install it with
+// access.UseCredentialVerifier, and access.Login delegates the password checks
+// of grants without a password of their own to the directory. Each check finds
+// the key's entry with a search and binds as it with the password.
+package ldapaccess
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Verifier checks credentials against the directory at URL
+type Verifier struct {
+	// URL is the directory's address, such as "ldaps://dc.example.com:636"
+	URL string
+
+	// StartTLS upgrades "ldap://" connections to TLS before binding, and
+	// TLSConfig, if set, configures TLS for either scheme
+	StartTLS  bool
+	TLSConfig *tls.Config
+
+	// BindDN and BindPassword, if set, are the credentials of the service
+	// account searching the directory. Otherwise it is searched anonymously.
+	BindDN       string
+	BindPassword string
+
+	// BaseDN is where searches start, such as "dc=example,dc=com"
+	BaseDN string
+
+	// Filter finds the entry of a key, with %s replaced by the escaped key,
+	// and defaults to "(&(objectClass=person)(uid=%s))". For Active Directory,
+	// use "(&(objectClass=user)(sAMAccountName=%s))" or
+	// "(&(objectClass=user)(userPrincipalName=%s))".
+	Filter string
+
+	// Timeout limits each connection and request, and defaults to 5 seconds
+	Timeout time.Duration
+}
+
+// VerifyCredentials reports whether password is the directory password of the
+// single entry matching key
+func (v *Verifier) VerifyCredentials(key, password string) (bool, error) {
+	// an empty password makes an unauthenticated bind, which many
+	// directories accept for any DN
+	if key == "" || password == "" {
+		return false, nil
+	}
+
+	conn, err := v.dial()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if v.BindDN != "" {
+		err = conn.Bind(v.BindDN, v.BindPassword)
+		if err != nil {
+			return false, fmt.Errorf("failed to bind as %s, %v", v.BindDN, err)
+		}
+	}
+
+	filter := v.Filter
+	if filter == "" {
+		filter = "(&(objectClass=person)(uid=%s))"
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		v.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2,
+		int(v.timeout()/time.Second),
+		false,
+		fmt.Sprintf(filter, ldap.EscapeFilter(key)),
+		[]string{"dn"},
+		nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return false, fmt.Errorf("failed to search for %s, %v", key, err)
+	}
+
+	// keys matching no entry, or more than one, can't be told apart from
+	// wrong passwords
+	if result == nil || len(result.Entries) != 1 {
+		return false, nil
+	}
+
+	err = conn.Bind(result.Entries[0].DN, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to bind as %s, %v", key, err)
+	}
+
+	return true, nil
+}
+
+func (v *Verifier) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(v.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: v.timeout()}),
+		ldap.DialWithTLSConfig(v.TLSConfig),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s, %v", v.URL, err)
+	}
+
+	conn.SetTimeout(v.timeout())
+
+	if v.StartTLS {
+		config := v.TLSConfig
+		if config == nil {
+			u, err := url.Parse(v.URL)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+
+			config = &tls.Config{ServerName: u.Hostname()}
+		}
+
+		err = conn.StartTLS(config)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start TLS with %s, %v", v.URL, err)
+		}
+	}
+
+	return conn, nil
+}
+
+func (v *Verifier) timeout() time.Duration {
+	if v.Timeout <= 0 {
+		return 5 * time.Second
+	}
+
+	return v.Timeout
+}
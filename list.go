@@ -0,0 +1,137 @@
+package access
+
+import "fmt"
+
+// GetGrant returns the grant for key along with its groups, such as to display
+// it to its owner or an operator. Password hashes, salts and TOTP secrets are
+// omitted. For tenant grants, key should be the namespaced key returned by
+// TenantKey.
+func GetGrant(key string) (*APIAccess, error) {
+	return std.GetGrant(key)
+}
+
+// GetGrant is the package GetGrant for the grants in s's Store
+func (s *Service) GetGrant(key string) (*APIAccess, error) {
+	if key == "" {
+		return nil, fmt.Errorf("%s", "key must not be empty")
+	}
+
+	var grant APIAccess
+	err := s.store.View(func(tx Tx) error {
+		a, _, err := getGrant(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if a == nil {
+			return notFound(key)
+		}
+
+		grant = a.redacted()
+		grant.Groups, err = groupsOf(tx, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &grant, nil
+}
+
+// ListGrants returns up to limit grants, skipping the first offset, in key
+// order. Password hashes, salts and TOTP secrets are omitted from the returned
+// grants.
+func ListGrants(offset, limit int) ([]APIAccess, error) {
+	return std.ListGrants(offset, limit)
+}
+
+// ListGrants is the package ListGrants for the grants in s's Store
+func (s *Service) ListGrants(offset, limit int) ([]APIAccess, error) {
+	if offset < 0 || limit < 0 {
+		return nil, fmt.Errorf("%s", "offset and limit must not be negative")
+	}
+
+	grants := []APIAccess{}
+	err := s.store.View(func(tx Tx) error {
+		var i int
+		return tx.ForEach(apiAccessStore, func(key string, value []byte) error {
+			if len(grants) == limit {
+				return errStopIteration
+			}
+
+			i++
+			if i <= offset {
+				return nil
+			}
+
+			a, _, err := decodeGrant(value)
+			if err != nil {
+				return fmt.Errorf("failed to decode grant for %s, %v", key, err)
+			}
+
+			grants = append(grants, a.redacted())
+			return nil
+		})
+	})
+	if err != nil && err != errStopIteration {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+// ListGrantsAfter returns up to limit grants whose stored key sorts after
+// cursor, in key order, along with the cursor to pass to fetch the next page.
+// An empty cursor starts from the first grant, and the returned cursor is empty
+// once there are no more grants. Password hashes, salts and TOTP secrets are
+// omitted from the returned grants.
+func ListGrantsAfter(cursor string, limit int) ([]APIAccess, string, error) {
+	return std.ListGrantsAfter(cursor, limit)
+}
+
+// ListGrantsAfter is the package ListGrantsAfter for the grants in s's Store
+func (s *Service) ListGrantsAfter(cursor string, limit int) ([]APIAccess, string, error) {
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("%s", "limit must be greater than zero")
+	}
+
+	grants := []APIAccess{}
+	var next string
+	err := s.store.View(func(tx Tx) error {
+		var last string
+		return tx.ForEach(apiAccessStore, func(key string, value []byte) error {
+			if cursor != "" && key <= cursor {
+				return nil
+			}
+
+			if len(grants) == limit {
+				next = last
+				return errStopIteration
+			}
+
+			a, _, err := decodeGrant(value)
+			if err != nil {
+				return fmt.Errorf("failed to decode grant for %s, %v", key, err)
+			}
+
+			grants = append(grants, a.redacted())
+			last = key
+			return nil
+		})
+	})
+	if err != nil && err != errStopIteration {
+		return nil, "", err
+	}
+
+	return grants, next, nil
+}
+
+// redacted returns a copy of the grant without its password hash, salt and
+// TOTP secret
+func (a *APIAccess) redacted() APIAccess {
+	r := *a
+	r.Hash = ""
+	r.Salt = ""
+	r.TOTPSecret = ""
+	return r
+}
@@ -0,0 +1,91 @@
+package access
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLocked is returned by Login and Grant when the grant is locked after too
+// many failed logins
+var ErrLocked = errors.New("grant is locked after too many failed logins")
+
+var (
+	lockoutMu        sync.RWMutex
+	lockoutThreshold int
+	lockoutDuration  time.Duration
+	lockoutHook      func(key string, until time.Time)
+)
+
+// SetLockout locks a grant for duration after maxFailures consecutive failed
+// logins, during which Login and Grant fail with ErrLocked even for the
+// correct password. A successful login resets the count. A zero maxFailures,
+// the default, disables lockout.
+func SetLockout(maxFailures int, duration time.Duration) {
+	lockoutMu.Lock()
+	defer lockoutMu.Unlock()
+
+	lockoutThreshold = maxFailures
+	lockoutDuration = duration
+}
+
+// OnLockout sets fn to be called with the storage key of each grant locked by
+// SetLockout, and the time its lock expires, such as to alert its owner
+func OnLockout(fn func(key string, until time.Time)) {
+	lockoutMu.Lock()
+	defer lockoutMu.Unlock()
+
+	lockoutHook = fn
+}
+
+// Unlock clears the lock and failed login count of the grant for key
+func Unlock(key string) error {
+	return std.Unlock(key)
+}
+
+// Unlock is the package Unlock for the grants in s's Store
+func (s *Service) Unlock(key string) error {
+	return s.modifyGrant(key, func(a *APIAccess) error {
+		a.FailedLogins = 0
+		a.LockedUntil = time.Time{}
+		return nil
+	})
+}
+
+// recordLoginFailure counts a failed login for the grant stored under
+// storeKey, locking it once lockoutThreshold is reached. Failures are recorded
+// in their own transaction, since the failed login's is rolled back.
+func (s *Service) recordLoginFailure(storeKey string) {
+	lockoutMu.RLock()
+	threshold, duration, hook := lockoutThreshold, lockoutDuration, lockoutHook
+	lockoutMu.RUnlock()
+
+	if threshold <= 0 {
+		return
+	}
+
+	var until time.Time
+	err := s.store.Update(func(tx Tx) error {
+		a, _, err := getGrant(tx, storeKey)
+		if err != nil || a == nil {
+			return err
+		}
+
+		a.FailedLogins++
+		if a.FailedLogins >= threshold {
+			a.FailedLogins = 0
+			a.LockedUntil = time.Now().Add(duration)
+			until = a.LockedUntil
+		}
+
+		return putGrant(tx, a)
+	})
+	if err != nil {
+		s.logger.Error("failed to record failed login", "key", storeKey, "err", err)
+		return
+	}
+
+	if !until.IsZero() && hook != nil {
+		hook(storeKey, until)
+	}
+}
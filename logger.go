@@ -0,0 +1,54 @@
+package access
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger receives the messages logged by the package, as a message followed by
+// alternating keys and values. *slog.Logger satisfies it.
+type Logger interface {
+	Info(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// UseLogger replaces the Logger used by the package, which defaults to the
+// standard log package. A nil Logger silences the package.
+func UseLogger(l Logger) {
+	if l == nil {
+		l = discardLogger{}
+	}
+
+	std.logger = l
+}
+
+// stdLogger writes messages to the standard logger as key=value pairs
+type stdLogger struct{}
+
+func (stdLogger) Info(msg string, args ...interface{}) {
+	log.Println(formatLog("INFO", msg, args))
+}
+
+func (stdLogger) Error(msg string, args ...interface{}) {
+	log.Println(formatLog("ERROR", msg, args))
+}
+
+func formatLog(level, msg string, args []interface{}) string {
+	var b strings.Builder
+	b.WriteString(level + " " + msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", args[i])
+		}
+	}
+
+	return b.String()
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Info(msg string, args ...interface{})  {}
+func (discardLogger) Error(msg string, args ...interface{}) {}
@@ -0,0 +1,50 @@
+package access
+
+import "fmt"
+
+// The templates of the messages this package sends through a Mailer
+const (
+	MailVerify        = "verify"
+	MailInvite        = "invite"
+	MailPasswordReset = "password_reset"
+)
+
+// Mailer sends the messages this package issues to the owners of grants, such
+// as email verification links. template names the message, one of MailVerify,
+// MailInvite or MailPasswordReset, and data holds the values to render it
+// with: "key", "token" and "link", which is the token added to the URL set for
+// the message, or the token itself if none is set.
+type Mailer interface {
+	Send(to, template string, data map[string]string) error
+}
+
+// UseMailer sets the Mailer used to send messages to the owners of grants, such
+// as an SMTPMailer. Without one, tokens are only returned to the caller to
+// deliver.
+func UseMailer(m Mailer) {
+	std.UseMailer(m)
+}
+
+// UseMailer is the package UseMailer for the grants in s's Store
+func (s *Service) UseMailer(m Mailer) {
+	s.mailer = m
+}
+
+// sendMail sends the message template to the owner of key, whose key must be
+// an email address, if a Mailer has been set for s
+func (s *Service) sendMail(key, template, token, link string) error {
+	if s.mailer == nil {
+		return nil
+	}
+
+	err := s.mailer.Send(key, template, map[string]string{
+		"key":   key,
+		"token": token,
+		"link":  link,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send mail to %s, %v", key, err)
+	}
+
+	return nil
+}
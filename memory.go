@@ -0,0 +1,155 @@
+package access
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryStore is a Store which keeps all records in memory. It is intended for
+// tests, CI runs and stateless demo environments where no bolt file is
+// available, and loses everything when the process exits.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]map[string][]byte),
+	}
+}
+
+// View runs fn within a read-only transaction
+func (m *MemoryStore) View(fn func(tx Tx) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return fn(&memoryTx{store: m})
+}
+
+// Update runs fn within a read-write transaction. Writes are staged and only
+// applied to the store if fn returns a nil error.
+func (m *MemoryStore) Update(fn func(tx Tx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := &memoryTx{
+		store:    m,
+		writable: true,
+		writes:   make(map[string]map[string][]byte),
+	}
+
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	for bucket, writes := range tx.writes {
+		b, ok := m.buckets[bucket]
+		if !ok {
+			b = make(map[string][]byte)
+			m.buckets[bucket] = b
+		}
+
+		for k, v := range writes {
+			if v == nil {
+				delete(b, k)
+				continue
+			}
+
+			b[k] = v
+		}
+	}
+
+	return nil
+}
+
+// memoryTx stages writes in a per-bucket overlay, where a nil value marks a
+// deleted key
+type memoryTx struct {
+	store    *MemoryStore
+	writable bool
+	writes   map[string]map[string][]byte
+}
+
+func (t *memoryTx) Get(bucket, key string) ([]byte, error) {
+	if v, ok := t.writes[bucket][key]; ok {
+		return copyBytes(v), nil
+	}
+
+	return copyBytes(t.store.buckets[bucket][key]), nil
+}
+
+func (t *memoryTx) Put(bucket, key string, value []byte) error {
+	if !t.writable {
+		return errTxNotWritable
+	}
+
+	if value == nil {
+		value = []byte{}
+	}
+
+	t.stage(bucket)[key] = copyBytes(value)
+	return nil
+}
+
+func (t *memoryTx) Delete(bucket, key string) error {
+	if !t.writable {
+		return errTxNotWritable
+	}
+
+	t.stage(bucket)[key] = nil
+	return nil
+}
+
+func (t *memoryTx) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	merged := make(map[string][]byte)
+	for k, v := range t.store.buckets[bucket] {
+		merged[k] = v
+	}
+
+	for k, v := range t.writes[bucket] {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+
+		merged[k] = v
+	}
+
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		err := fn(k, copyBytes(merged[k]))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *memoryTx) stage(bucket string) map[string][]byte {
+	w, ok := t.writes[bucket]
+	if !ok {
+		w = make(map[string][]byte)
+		t.writes[bucket] = w
+	}
+
+	return w
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
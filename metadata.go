@@ -0,0 +1,31 @@
+package access
+
+// UpdateGrantMetadata sets the entries of metadata on the Metadata of the grant
+// for key, keeping its other entries. Entries with an empty value are removed.
+// For tenant grants, key should be the namespaced key returned by TenantKey.
+func UpdateGrantMetadata(key string, metadata map[string]string) error {
+	return std.UpdateGrantMetadata(key, metadata)
+}
+
+// UpdateGrantMetadata is the package UpdateGrantMetadata for s's Store
+func (s *Service) UpdateGrantMetadata(key string, metadata map[string]string) error {
+	return s.modifyGrant(key, func(a *APIAccess) error {
+		if a.Metadata == nil {
+			a.Metadata = make(map[string]string)
+		}
+
+		for k, v := range metadata {
+			if v == "" {
+				delete(a.Metadata, k)
+			} else {
+				a.Metadata[k] = v
+			}
+		}
+
+		if len(a.Metadata) == 0 {
+			a.Metadata = nil
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,41 @@
+package access
+
+import "net/http"
+
+// SetNetworks limits the grant for key to requests from any of cidrs, such as
+// "203.0.113.0/24", so its tokens, API keys and signed requests are rejected
+// from anywhere else. Passing no cidrs removes the limit. Networks are added to
+// tokens as the "networks" claim, so tokens issued before the change keep their
+// previous networks until the next Grant or Login.
+func SetNetworks(key string, cidrs ...string) error {
+	return std.SetNetworks(key, cidrs...)
+}
+
+// SetNetworks is the package SetNetworks for the grants in s's Store
+func (s *Service) SetNetworks(key string, cidrs ...string) error {
+	_, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+
+	return s.modifyGrant(key, func(a *APIAccess) error {
+		a.Networks = cidrs
+		return nil
+	})
+}
+
+// fromAllowedNetwork reports whether req comes from any of the networks in
+// claims, or claims hold none. Malformed networks match no request.
+func fromAllowedNetwork(req *http.Request, claims map[string]interface{}) bool {
+	cidrs := claimStrings(claims, "networks")
+	if len(cidrs) == 0 {
+		return true
+	}
+
+	networks, err := parseCIDRs(cidrs)
+	if err != nil {
+		return false
+	}
+
+	return inNetworks(remoteIP(req), networks)
+}
@@ -0,0 +1,221 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// NonceHeader is the request header RequireNonce reads nonces from
+const NonceHeader = "X-Access-Nonce"
+
+// nonceRecord is the stored value for a nonce in the __apiNonce bucket, keyed
+// by the nonce's hash
+type nonceRecord struct {
+	Key     string    `json:"key"`
+	Expires time.Time `json:"expires"`
+}
+
+var (
+	nonceTTLMu sync.RWMutex
+	nonceTTL   = 5 * time.Minute
+)
+
+// SetNonceTTL sets how long nonces are valid for, which is 5 minutes by default
+func SetNonceTTL(ttl time.Duration) {
+	nonceTTLMu.Lock()
+	nonceTTL = ttl
+	nonceTTLMu.Unlock()
+}
+
+// currentNonceTTL returns the TTL set by SetNonceTTL
+func currentNonceTTL() time.Duration {
+	nonceTTLMu.RLock()
+	defer nonceTTLMu.RUnlock()
+
+	return nonceTTL
+}
+
+// NewNonce returns a single-use nonce bound to the grant for key, for its owner
+// to send with a sensitive request so the request can't be replayed. Only a
+// hash of the nonce is stored. For tenant grants, key should be the namespaced
+// key returned by TenantKey.
+func NewNonce(key string) (string, error) {
+	return std.NewNonce(key)
+}
+
+// NewNonce is the package NewNonce for s's Store
+func (s *Service) NewNonce(key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("%s", "key must not be empty")
+	}
+
+	nonce, hash, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	j, err := json.Marshal(nonceRecord{
+		Key:     key,
+		Expires: time.Now().Add(currentNonceTTL()),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = s.store.Update(func(tx Tx) error {
+		return tx.Put(apiNonceStore, hash, j)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return nonce, nil
+}
+
+// ConsumeNonce checks that nonce was issued to the grant for key and hasn't
+// expired, and removes it so it can't be used again
+func ConsumeNonce(key, nonce string) error {
+	return std.ConsumeNonce(key, nonce)
+}
+
+// ConsumeNonce is the package ConsumeNonce for s's Store
+func (s *Service) ConsumeNonce(key, nonce string) error {
+	if nonce == "" {
+		return fmt.Errorf("%s", "nonce must not be empty")
+	}
+
+	hash := hashSecret(nonce)
+	var rec nonceRecord
+	err := s.store.Update(func(tx Tx) error {
+		j, err := tx.Get(apiNonceStore, hash)
+		if err != nil {
+			return err
+		}
+
+		if j == nil {
+			return fmt.Errorf("%s", "invalid nonce")
+		}
+
+		err = json.Unmarshal(j, &rec)
+		if err != nil {
+			return fmt.Errorf("failed to decode nonce, %v", err)
+		}
+
+		if !sameKey(rec.Key, key) {
+			return fmt.Errorf("%s", "invalid nonce")
+		}
+
+		return tx.Delete(apiNonceStore, hash)
+	})
+	if err != nil {
+		return err
+	}
+
+	if time.Now().After(rec.Expires) {
+		return fmt.Errorf("%s", "nonce has expired")
+	}
+
+	return nil
+}
+
+// PurgeNonces removes expired nonces which were never used, and returns the
+// number removed
+func PurgeNonces() (int, error) {
+	return std.PurgeNonces()
+}
+
+// PurgeNonces is the package PurgeNonces for s's Store
+func (s *Service) PurgeNonces() (int, error) {
+	var purged int
+	now := time.Now()
+	err := s.store.Update(func(tx Tx) error {
+		var expired []string
+		err := tx.ForEach(apiNonceStore, func(hash string, value []byte) error {
+			var rec nonceRecord
+			if json.Unmarshal(value, &rec) != nil || now.After(rec.Expires) {
+				expired = append(expired, hash)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, hash := range expired {
+			err := tx.Delete(apiNonceStore, hash)
+			if err != nil {
+				return err
+			}
+		}
+
+		purged = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
+
+// NonceHandler handles POST requests holding a valid token by responding with a
+// new nonce for its grant
+func NonceHandler() http.HandlerFunc {
+	return std.NonceHandler()
+}
+
+// NonceHandler is the package NonceHandler for s's Store
+func (s *Service) NonceHandler() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, ok := s.requestClaims(req)
+		if !ok {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		nonce, err := s.NewNonce(claimKey(claims))
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Cache-Control", "no-store")
+		res.Write([]byte(nonce))
+	}
+}
+
+// RequireNonce is middleware which only calls next for requests holding a valid
+// token and a nonce issued to its grant in the X-Access-Nonce header, which is
+// consumed. Requests without a valid token are rejected with 401 Unauthorized,
+// and those without a valid nonce with 403 Forbidden.
+func RequireNonce(next http.HandlerFunc) http.HandlerFunc {
+	return std.RequireNonce(next)
+}
+
+// RequireNonce is the package RequireNonce, verifying tokens with s's Signer
+func (s *Service) RequireNonce(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := s.requestClaims(req)
+		if !ok {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		err := s.ConsumeNonce(claimKey(claims), req.Header.Get(NonceHeader))
+		if err != nil {
+			res.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
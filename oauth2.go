@@ -0,0 +1,142 @@
+package access
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OAuth2TokenHandler returns an OAuth 2.0 token endpoint for the
+// client_credentials grant type, so tools expecting OAuth 2.0 can obtain
+// tokens. A client's ID is the key of its grant, and its secret is the grant's
+// password, or the secret of a service account, sent with HTTP Basic
+// authentication or as the client_id and client_secret form values. Service
+// accounts with a public key instead send a client_assertion signed by it, of
+// type ServiceAccountAssertionType, whose audience is the https URL of the
+// endpoint. Tokens are issued with defaults, whose TokenStore is ignored, and
+// returned in a standard token response. A requested scope must be held by the
+// grant, and the token carries all of the grant's scopes.
+func OAuth2TokenHandler(defaults *Config) http.HandlerFunc {
+	return std.OAuth2TokenHandler(defaults)
+}
+
+// OAuth2TokenHandler is the package OAuth2TokenHandler for the grants in
+// s's Store
+func (s *Service) OAuth2TokenHandler(defaults *Config) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if req.PostFormValue("grant_type") != "client_credentials" {
+			oauth2Error(res, http.StatusBadRequest, "unsupported_grant_type", "only client_credentials is supported")
+			return
+		}
+
+		cfg := *defaults
+		cfg.ResponseWriter = res
+		cfg.Request = req
+		cfg.TokenStore = QueryParam("")
+		if cfg.ExpireAfter <= 0 {
+			cfg.ExpireAfter = time.Hour
+		}
+
+		id, secret, basic := req.BasicAuth()
+		if !basic {
+			id, secret = req.PostFormValue("client_id"), req.PostFormValue("client_secret")
+		}
+
+		var a *APIAccess
+		var err error
+		switch {
+		case req.PostFormValue("client_assertion_type") == ServiceAccountAssertionType:
+			// the assertion's audience is the token endpoint, which is
+			// served over TLS even behind a proxy terminating it
+			endpoint := "https://" + req.Host + req.URL.Path
+			a, err = s.ServiceAccountAssertion(req.PostFormValue("client_assertion"), endpoint, &cfg)
+
+		case id == "" || secret == "":
+			oauth2Error(res, http.StatusUnauthorized, "invalid_client", "client credentials are required")
+			return
+
+		default:
+			a, err = s.clientToken(id, secret, &cfg)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrLocked), errors.Is(err, ErrRateLimited):
+				oauth2Error(res, http.StatusTooManyRequests, "invalid_client", err.Error())
+
+			case errors.Is(err, ErrUnauthorized), errors.Is(err, ErrTOTPRequired),
+				errors.Is(err, ErrDisabled), errors.Is(err, ErrGrantExpired):
+				if basic {
+					res.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+				}
+
+				oauth2Error(res, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+
+			default:
+				s.writeError(res, err)
+			}
+
+			return
+		}
+
+		for _, scope := range strings.Fields(req.PostFormValue("scope")) {
+			if !containsString(a.Scopes, scope) {
+				s.RevokeSession(TenantKey(a.Tenant, a.Key), a.jti)
+				oauth2Error(res, http.StatusBadRequest, "invalid_scope", "scope "+scope+" is not granted to the client")
+				return
+			}
+		}
+
+		expiresIn := cfg.ExpireAfter
+		if !a.ExpiresAt.IsZero() && time.Until(a.ExpiresAt) < expiresIn {
+			expiresIn = time.Until(a.ExpiresAt)
+		}
+
+		body := map[string]interface{}{
+			"access_token": a.Token,
+			"token_type":   "Bearer",
+			"expires_in":   int(expiresIn / time.Second),
+		}
+
+		if len(a.Scopes) > 0 {
+			body["scope"] = strings.Join(a.Scopes, " ")
+		}
+
+		res.Header().Set("Pragma", "no-cache")
+		writeJSON(res, http.StatusOK, body)
+	}
+}
+
+// clientToken issues a token for the client with id, checking secret as the
+// secret of a service account or the password of any other grant
+func (s *Service) clientToken(id, secret string, cfg *Config) (*APIAccess, error) {
+	var svc bool
+	err := s.store.View(func(tx Tx) error {
+		a, _, err := getGrant(tx, TenantKey(cfg.TenantID, id))
+		svc = a != nil && a.ServiceAccount
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if svc {
+		return s.ServiceAccountToken(id, secret, cfg)
+	}
+
+	return s.Login(id, secret, cfg)
+}
+
+// oauth2Error writes an OAuth 2.0 error response
+func oauth2Error(res http.ResponseWriter, status int, code, description string) {
+	res.Header().Set("Pragma", "no-cache")
+	writeJSON(res, status, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
@@ -0,0 +1,220 @@
+package access
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Issuer is an external OpenID Connect identity provider whose tokens are
+// accepted alongside those this package issues
+type Issuer struct {
+	// URL is the issuer identifier, which its tokens carry as the "iss" claim
+	// and which serves its discovery document
+	URL string
+
+	// Audience is the "aud" claim its tokens must hold, such as the client ID
+	// registered with the provider
+	Audience string
+
+	// TenantID and Scopes are given to the grants created for the issuer's
+	// subjects on first use
+	TenantID string
+	Scopes   []string
+
+	// JWKSURL, if set, is where the issuer publishes its signing keys, for
+	// SSO systems without a discovery document
+	JWKSURL string
+
+	// KeyCacheTTL is how long signing keys are cached before being fetched
+	// again, an hour if not set. Keys are also fetched again when a token is
+	// signed by an unknown key, at most once a minute.
+	KeyCacheTTL time.Duration
+
+	// Client fetches the discovery document and signing keys, and defaults
+	// to a client with a 5 second timeout
+	Client *http.Client
+}
+
+// clockSkew is the leeway given to the "exp" and "nbf" claims of tokens from
+// other issuers, whose clocks may differ from the server's
+const clockSkew = time.Minute
+
+type trustedIssuer struct {
+	Issuer
+	keys *keySet
+}
+
+// issuerSet holds the issuers trusted by a Service, by URL
+type issuerSet struct {
+	mu    sync.RWMutex
+	byURL map[string]*trustedIssuer
+}
+
+func newIssuerSet() *issuerSet {
+	return &issuerSet{byURL: map[string]*trustedIssuer{}}
+}
+
+func (is *issuerSet) trust(t *trustedIssuer) {
+	is.mu.Lock()
+	is.byURL[t.URL] = t
+	is.mu.Unlock()
+}
+
+func (is *issuerSet) lookup(url string) (*trustedIssuer, bool) {
+	is.mu.RLock()
+	defer is.mu.RUnlock()
+
+	t, ok := is.byURL[url]
+	return t, ok
+}
+
+// TrustIssuer makes IsGranted, GateKeeper and the other checks accept ID and
+// access tokens signed by iss, as found at its JWKSURL or through its
+// discovery document, which is fetched along with its signing keys before
+// TrustIssuer returns. The
+// subject of a token is mapped to a local grant, keyed by ExternalKey, which
+// is created without a password the first time it is seen. Its roles, scopes,
+// groups and admin flag are those of the grant, and disabling it rejects the
+// subject's tokens. Tokens are also rejected if a grant at that key exists
+// which wasn't created for the subject, or has a password.
+func TrustIssuer(iss Issuer) error {
+	return std.TrustIssuer(iss)
+}
+
+// TrustIssuer is the package TrustIssuer, mapping subjects to grants in s's
+// Store
+func (s *Service) TrustIssuer(iss Issuer) error {
+	if iss.URL == "" || iss.Audience == "" {
+		return fmt.Errorf("%s", "issuer URL and audience must not be empty")
+	}
+
+	err := validateTenantID(iss.TenantID)
+	if err != nil {
+		return err
+	}
+
+	jwksURL := iss.JWKSURL
+	if jwksURL == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+
+		err = getJSON(iss.Client, strings.TrimSuffix(iss.URL, "/")+"/.well-known/openid-configuration", &discovery)
+		if err != nil {
+			return err
+		}
+
+		if discovery.Issuer != iss.URL {
+			return fmt.Errorf("discovery document is for issuer %s, not %s", discovery.Issuer, iss.URL)
+		}
+
+		jwksURL = discovery.JWKSURI
+	}
+
+	keys := newKeySet(iss.Client, jwksURL, iss.KeyCacheTTL)
+	err = keys.refresh()
+	if err != nil {
+		return err
+	}
+
+	s.issuers.trust(&trustedIssuer{Issuer: iss, keys: keys})
+
+	return nil
+}
+
+// ExternalKey returns the key of the grant created for subject of the issuer
+// at issuerURL, such as "accounts.example.com|248289761001", to manage with
+// SetRoles and the other functions taking a grant's key. For issuers with a
+// TenantID, namespace it with TenantKey.
+func ExternalKey(issuerURL, subject string) string {
+	host := issuerURL
+	if u, err := url.Parse(issuerURL); err == nil && u.Host != "" {
+		host = u.Host + strings.TrimSuffix(u.Path, "/")
+	}
+
+	return host + "|" + subject
+}
+
+// issuerClaims returns the claims of the local grant for the subject of a
+// token from a trusted issuer, if it passes verification
+func (s *Service) issuerClaims(token string) (map[string]interface{}, bool) {
+	alg, kid, ok := jwtHeaderOf(token)
+	if !ok {
+		return nil, false
+	}
+
+	claims := jwtClaims(token)
+	iss, _ := claims["iss"].(string)
+
+	trusted, ok := s.issuers.lookup(iss)
+	if !ok {
+		return nil, false
+	}
+
+	key, ok := trusted.keys.key(kid, s.logger)
+	if !ok || !verifyJWTSignature(token, alg, key) {
+		return nil, false
+	}
+
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.Add(-clockSkew).Unix() > int64(exp) {
+		return nil, false
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Unix() < int64(nbf) {
+		return nil, false
+	}
+
+	sub, _ := claims["sub"].(string)
+	if sub == "" || !hasAudience(claims, trusted.Audience) {
+		return nil, false
+	}
+
+	// grants which are cleared, disabled or expired, or weren't created for
+	// sub, are refused rather than failing
+	a, err := s.externalGrant(trusted.Issuer, sub)
+	if errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrDisabled) || errors.Is(err, ErrGrantExpired) {
+		return nil, false
+	}
+	if err != nil {
+		s.logger.Error("failed to map external subject to a grant", "iss", iss, "err", err)
+		return nil, false
+	}
+
+	local := a.claims()
+	local["exp"] = int64(exp)
+	local["iss"] = iss
+	return local, true
+}
+
+// externalGrant returns the grant for sub of iss with its groups, creating it
+// if it doesn't exist. An existing grant is only returned if it was created
+// for sub of iss, without a password, so a grant registered at its key by
+// other means can't be taken over by the issuer's tokens.
+func (s *Service) externalGrant(iss Issuer, sub string) (*APIAccess, error) {
+	a, err := s.ProvisionGrant(GrantRequest{
+		Key:    ExternalKey(iss.URL, sub),
+		Tenant: iss.TenantID,
+		Scopes: iss.Scopes,
+		Metadata: map[string]string{
+			"iss": iss.URL,
+			"sub": sub,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Hash != "" || a.Metadata["iss"] != iss.URL || a.Metadata["sub"] != sub {
+		return nil, fmt.Errorf("%s was not created for its issuer: %w", TenantKey(a.Tenant, a.Key), ErrUnauthorized)
+	}
+
+	return a, nil
+}
@@ -0,0 +1,190 @@
+package access_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+// identityProvider serves the discovery document and signing key of an
+// OpenID Connect issuer, and signs ID tokens for it
+type identityProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+}
+
+func newIdentityProvider(t *testing.T) *identityProvider {
+	return newIdentityProviderBits(t, 2048)
+}
+
+// newIdentityProviderBits returns an identityProvider signing with an RSA key
+// of bits
+func newIdentityProviderBits(t *testing.T, bits int) *identityProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &identityProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(res http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(res).Encode(map[string]string{
+			"issuer":   idp.URL,
+			"jwks_uri": idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(res http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(res).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"n":   b64(key.N.Bytes()),
+				"e":   b64(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// token returns an ID token for sub, for the "api" audience
+func (idp *identityProvider) token(t *testing.T, sub string) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss": idp.URL,
+		"sub": sub,
+		"aud": "api",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	unsigned := b64(header) + "." + b64(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return unsigned + "." + b64(sig)
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestExternalKeyIsolation(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+	idp := newIdentityProvider(t)
+
+	err := access.TrustIssuer(access.Issuer{URL: idp.URL, Audience: "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, ok := access.VerifyToken(idp.token(t, "alice"))
+	if !ok || identity.Key != access.ExternalKey(idp.URL, "alice") {
+		t.Fatalf("token of alice: got %+v, %v", identity, ok)
+	}
+
+	// self-service grants can't be registered at an external key before its
+	// subject first signs in
+	squat := access.ExternalKey(idp.URL, "bob")
+	_, err = access.Grant(squat, accesstest.Password, headerConfig(""))
+	if err == nil {
+		t.Fatal("granted an external key with a password")
+	}
+
+	err = access.Pending(squat)
+	if err == nil {
+		t.Error("added an external key to pending status")
+	}
+
+	// nor are grants provisioned at an external key by other means taken
+	// over by the issuer's tokens
+	_, err = access.ProvisionGrant(access.GrantRequest{Key: squat})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok = access.VerifyToken(idp.token(t, "bob"))
+	if ok {
+		t.Error("token of bob accepted for a grant not created for him")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+idp.token(t, "bob"))
+	if access.IsGranted(req, http.Header{}) {
+		t.Error("IsGranted accepted bob's token for a grant not created for him")
+	}
+}
+
+func TestExternalGrantRevoked(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+	idp := newIdentityProvider(t)
+
+	err := access.TrustIssuer(access.Issuer{URL: idp.URL, Audience: "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := access.ExternalKey(idp.URL, "carol")
+	for name, revoke := range map[string][2]func(key string) error{
+		"disabled": {access.Disable, access.Enable},
+		"cleared":  {access.ClearGrant, access.RestoreGrant},
+	} {
+		if _, ok := access.VerifyToken(idp.token(t, "carol")); !ok {
+			t.Fatalf("%s: token of carol rejected before", name)
+		}
+
+		err = revoke[0](key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+idp.token(t, "carol"))
+		if access.IsGranted(req, http.Header{}) {
+			t.Errorf("%s: IsGranted accepted a token for the grant", name)
+		}
+
+		_, err = access.ProvisionGrant(access.GrantRequest{Key: key})
+		if err == nil {
+			t.Errorf("%s: grant provisioned again", name)
+		}
+
+		err = revoke[1](key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIssuerWeakKey(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+	idp := newIdentityProviderBits(t, 1024)
+
+	err := access.TrustIssuer(access.Issuer{URL: idp.URL, Audience: "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := access.VerifyToken(idp.token(t, "dave")); ok {
+		t.Error("token signed with a 1024 bit RSA key accepted")
+	}
+}
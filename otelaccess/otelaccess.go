@@ -0,0 +1,257 @@
+// Package otelaccess instruments the access package with OpenTelemetry
+// tracing, so the latency and failures of authentication show up in existing
+// distributed traces.
+//
+// GateKeeper records a span for each decision, with the grant, decision, reason
+// and token source as attributes. Store wraps an access.Store to record a span
+// for each transaction, and IsGranted and VerifyToken record a span for each
+// token validation.
+package otelaccess
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/nilslice/access"
+)
+
+const instrumentationName = "github.com/nilslice/access/otelaccess"
+
+// Span attributes recorded by the instrumentation
+const (
+	KeyAttribute      = attribute.Key("access.key")
+	DecisionAttribute = attribute.Key("access.decision")
+	ReasonAttribute   = attribute.Key("access.reason")
+	SourceAttribute   = attribute.Key("access.source")
+	ReadsAttribute    = attribute.Key("access.store.reads")
+	WritesAttribute   = attribute.Key("access.store.writes")
+)
+
+// Instrumentation records spans with Tracer
+type Instrumentation struct {
+	Tracer trace.Tracer
+}
+
+// New returns Instrumentation using a tracer of the global TracerProvider
+func New() *Instrumentation {
+	return &Instrumentation{Tracer: otel.GetTracerProvider().Tracer(instrumentationName)}
+}
+
+// decision is the outcome of a request passing through GateKeeper
+type decision struct {
+	parent  trace.Span
+	span    trace.Span
+	allowed bool
+}
+
+type decisionKey struct{}
+
+// GateKeeper returns cfg.GateKeeper recording an "access.GateKeeper" span for
+// each request, ended before next is called so the span measures only the
+// decision. cfg may be nil to use the package GateKeeper's defaults, and its
+// OnDenied is still called.
+func (i *Instrumentation) GateKeeper(cfg *access.Config) func(next http.HandlerFunc) http.HandlerFunc {
+	traced := access.Config{}
+	if cfg != nil {
+		traced = *cfg
+	}
+
+	onDenied := traced.OnDenied
+	traced.OnDenied = func(req *http.Request, denial access.Denial) {
+		if d, ok := req.Context().Value(decisionKey{}).(*decision); ok {
+			d.span.SetAttributes(
+				DecisionAttribute.String("deny"),
+				ReasonAttribute.String(string(denial.Reason)),
+			)
+			if denial.Key != "" {
+				d.span.SetAttributes(KeyAttribute.String(denial.Key))
+			}
+		}
+
+		if onDenied != nil {
+			onDenied(req, denial)
+		}
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		gate := traced.GateKeeper(func(res http.ResponseWriter, req *http.Request) {
+			d, ok := req.Context().Value(decisionKey{}).(*decision)
+			if !ok {
+				next(res, req)
+				return
+			}
+
+			d.allowed = true
+			d.span.SetAttributes(DecisionAttribute.String("allow"))
+			if identity, ok := access.FromContext(req.Context()); ok {
+				d.span.SetAttributes(
+					KeyAttribute.String(access.TenantKey(identity.Tenant, identity.Key)),
+					SourceAttribute.String(source(identity.Source)),
+				)
+			}
+			d.span.End()
+
+			ctx := context.WithValue(req.Context(), decisionKey{}, nil)
+			next(res, req.WithContext(trace.ContextWithSpan(ctx, d.parent)))
+		})
+
+		return func(res http.ResponseWriter, req *http.Request) {
+			parent := trace.SpanFromContext(req.Context())
+			ctx, span := i.Tracer.Start(req.Context(), "access.GateKeeper")
+			d := &decision{parent: parent, span: span}
+
+			gate(res, req.WithContext(context.WithValue(ctx, decisionKey{}, d)))
+			if !d.allowed {
+				span.SetStatus(codes.Error, "request denied")
+				span.End()
+			}
+		}
+	}
+}
+
+// IsGranted is access.IsGranted recording an "access.IsGranted" span
+func (i *Instrumentation) IsGranted(req *http.Request, tokenStore interface{}) bool {
+	_, span := i.Tracer.Start(req.Context(), "access.IsGranted")
+	defer span.End()
+
+	granted := access.IsGranted(req, tokenStore)
+	span.SetAttributes(attribute.Bool("access.granted", granted))
+	return granted
+}
+
+// VerifyToken is access.VerifyToken recording an "access.VerifyToken" span
+// within ctx
+func (i *Instrumentation) VerifyToken(ctx context.Context, token string) (*access.Identity, bool) {
+	_, span := i.Tracer.Start(ctx, "access.VerifyToken")
+	defer span.End()
+
+	identity, ok := access.VerifyToken(token)
+	if !ok {
+		span.SetStatus(codes.Error, "invalid token")
+		return nil, false
+	}
+
+	span.SetAttributes(KeyAttribute.String(access.TenantKey(identity.Tenant, identity.Key)))
+	return identity, true
+}
+
+// Store returns s recording an "access.Store.View" or "access.Store.Update"
+// span for each transaction, with the number of reads and writes made. The
+// returned Store is an access.ContextStore, so transactions run through the
+// context variants, such as access.GrantContext, join the request's trace;
+// others start new traces.
+func (i *Instrumentation) Store(s access.Store) access.Store {
+	return &tracedStore{store: s, tracer: i.Tracer}
+}
+
+type tracedStore struct {
+	store  access.Store
+	tracer trace.Tracer
+}
+
+func (s *tracedStore) View(fn func(tx access.Tx) error) error {
+	return s.ViewContext(context.Background(), fn)
+}
+
+func (s *tracedStore) Update(fn func(tx access.Tx) error) error {
+	return s.UpdateContext(context.Background(), fn)
+}
+
+func (s *tracedStore) ViewContext(ctx context.Context, fn func(tx access.Tx) error) error {
+	ctx, span := s.tracer.Start(ctx, "access.Store.View")
+	defer span.End()
+
+	run := s.store.View
+	if cs, ok := s.store.(access.ContextStore); ok {
+		run = func(fn func(tx access.Tx) error) error {
+			return cs.ViewContext(ctx, fn)
+		}
+	}
+
+	return s.run(span, run, fn)
+}
+
+func (s *tracedStore) UpdateContext(ctx context.Context, fn func(tx access.Tx) error) error {
+	ctx, span := s.tracer.Start(ctx, "access.Store.Update")
+	defer span.End()
+
+	run := s.store.Update
+	if cs, ok := s.store.(access.ContextStore); ok {
+		run = func(fn func(tx access.Tx) error) error {
+			return cs.UpdateContext(ctx, fn)
+		}
+	}
+
+	return s.run(span, run, fn)
+}
+
+func (s *tracedStore) run(span trace.Span, run func(fn func(tx access.Tx) error) error, fn func(tx access.Tx) error) error {
+	var t *tracedTx
+	err := run(func(tx access.Tx) error {
+		t = &tracedTx{Tx: tx}
+		return fn(t)
+	})
+
+	if t != nil {
+		span.SetAttributes(
+			ReadsAttribute.Int(t.reads),
+			WritesAttribute.Int(t.writes),
+		)
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
+
+// tracedTx counts the reads and writes of a transaction
+type tracedTx struct {
+	access.Tx
+	reads  int
+	writes int
+}
+
+func (t *tracedTx) Get(bucket, key string) ([]byte, error) {
+	t.reads++
+	return t.Tx.Get(bucket, key)
+}
+
+func (t *tracedTx) Put(bucket, key string, value []byte) error {
+	t.writes++
+	return t.Tx.Put(bucket, key, value)
+}
+
+func (t *tracedTx) Delete(bucket, key string) error {
+	t.writes++
+	return t.Tx.Delete(bucket, key)
+}
+
+func (t *tracedTx) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	return t.Tx.ForEach(bucket, func(key string, value []byte) error {
+		t.reads++
+		return fn(key, value)
+	})
+}
+
+// source names the token store an identity's token was read from
+func source(src interface{}) string {
+	switch s := src.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	case access.QueryParam:
+		return "query"
+	default:
+		return fmt.Sprintf("%T", s)
+	}
+}
@@ -0,0 +1,303 @@
+// Package passkey lets API consumers log in to access grants with passkeys
+// (WebAuthn) instead of passwords.
+//
+// Registration and login are each a two step ceremony: Begin returns options
+// for the browser's navigator.credentials API, storing the challenge with the
+// grant, and Finish verifies the browser's response against it. Credentials
+// are kept per grant with access.SetGrantData, and a successful login issues a
+// token with access.IssueToken, recording "hwk" in its "amr" claim.
+package passkey
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+
+	"github.com/nilslice/access"
+)
+
+const (
+	credentialsData = "passkey.credentials"
+	sessionData     = "passkey.session"
+)
+
+// Passkeys runs WebAuthn ceremonies for access grants
+type Passkeys struct {
+	WebAuthn *webauthn.WebAuthn
+}
+
+// New returns Passkeys for the relying party described by config
+func New(config *webauthn.Config) (*Passkeys, error) {
+	w, err := webauthn.New(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Passkeys{WebAuthn: w}, nil
+}
+
+// BeginRegistration returns the options to create a new passkey for the grant
+// for key, whose owner must already be authenticated
+func (p *Passkeys) BeginRegistration(key string) (*protocol.CredentialCreation, error) {
+	u, err := loadUser(key)
+	if err != nil {
+		return nil, err
+	}
+
+	creation, session, err := p.WebAuthn.BeginRegistration(u,
+		webauthn.WithExclusions(webauthn.Credentials(u.credentials).CredentialDescriptors()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = saveSession(key, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return creation, nil
+}
+
+// FinishRegistration verifies the browser's response to BeginRegistration in
+// req and adds the new passkey to the grant for key
+func (p *Passkeys) FinishRegistration(key string, req *http.Request) error {
+	u, err := loadUser(key)
+	if err != nil {
+		return err
+	}
+
+	session, err := takeSession(key)
+	if err != nil {
+		return err
+	}
+
+	cred, err := p.WebAuthn.FinishRegistration(u, *session, req)
+	if err != nil {
+		return err
+	}
+
+	return saveCredentials(key, append(u.credentials, *cred))
+}
+
+// BeginLogin returns the options to log in to the grant for key with one of
+// its passkeys
+func (p *Passkeys) BeginLogin(key string) (*protocol.CredentialAssertion, error) {
+	u, err := loadUser(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(u.credentials) == 0 {
+		return nil, fmt.Errorf("no passkeys registered for %s", key)
+	}
+
+	assertion, session, err := p.WebAuthn.BeginLogin(u)
+	if err != nil {
+		return nil, err
+	}
+
+	err = saveSession(key, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return assertion, nil
+}
+
+// FinishLogin verifies the browser's response to BeginLogin in req and issues
+// a token for the grant for key as cfg describes
+func (p *Passkeys) FinishLogin(key string, req *http.Request, cfg *access.Config) (*access.APIAccess, error) {
+	u, err := loadUser(key)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := takeSession(key)
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := p.WebAuthn.FinishLogin(u, *session, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// record the credential's new sign count, so cloned authenticators are
+	// detected on their next use
+	for i := range u.credentials {
+		if string(u.credentials[i].ID) == string(cred.ID) {
+			u.credentials[i] = *cred
+		}
+	}
+
+	err = saveCredentials(key, u.credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	return access.IssueToken(key, cfg, "hwk")
+}
+
+// RegistrationHandlers returns handlers for the two steps of registering a
+// passkey, to serve behind access.Authenticate so the grant is that of the
+// request's token. begin responds with JSON options for
+// navigator.credentials.create, and finish with 201 Created.
+func (p *Passkeys) RegistrationHandlers() (begin, finish http.HandlerFunc) {
+	begin = func(res http.ResponseWriter, req *http.Request) {
+		key, ok := identityKey(req)
+		if !ok {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		creation, err := p.BeginRegistration(key)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(res, creation)
+	}
+
+	finish = func(res http.ResponseWriter, req *http.Request) {
+		key, ok := identityKey(req)
+		if !ok {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		err := p.FinishRegistration(key, req)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		res.WriteHeader(http.StatusCreated)
+	}
+
+	return begin, finish
+}
+
+// LoginHandlers returns handlers for the two steps of logging in with a
+// passkey, for the grant named by the "key" query parameter. begin responds
+// with JSON options for navigator.credentials.get, and finish writes the new
+// token to the response as cfg describes.
+func (p *Passkeys) LoginHandlers(cfg *access.Config) (begin, finish http.HandlerFunc) {
+	begin = func(res http.ResponseWriter, req *http.Request) {
+		assertion, err := p.BeginLogin(req.URL.Query().Get("key"))
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(res, assertion)
+	}
+
+	finish = func(res http.ResponseWriter, req *http.Request) {
+		c := *cfg
+		c.ResponseWriter = res
+		c.Request = req
+
+		_, err := p.FinishLogin(req.URL.Query().Get("key"), req, &c)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		res.WriteHeader(http.StatusNoContent)
+	}
+
+	return begin, finish
+}
+
+// user adapts a grant to webauthn.User
+type user struct {
+	key         string
+	credentials []webauthn.Credential
+}
+
+func (u *user) WebAuthnID() []byte                         { return []byte(u.key) }
+func (u *user) WebAuthnName() string                       { return u.key }
+func (u *user) WebAuthnDisplayName() string                { return u.key }
+func (u *user) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+func loadUser(key string) (*user, error) {
+	j, err := access.GrantData(key, credentialsData)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &user{key: key}
+	if j != nil {
+		err = json.Unmarshal(j, &u.credentials)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode passkeys for %s, %v", key, err)
+		}
+	}
+
+	return u, nil
+}
+
+func saveCredentials(key string, creds []webauthn.Credential) error {
+	j, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+
+	return access.SetGrantData(key, credentialsData, j)
+}
+
+func saveSession(key string, session *webauthn.SessionData) error {
+	j, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	return access.SetGrantData(key, sessionData, j)
+}
+
+// takeSession returns the stored challenge for key and removes it, so each
+// challenge can only be answered once
+func takeSession(key string) (*webauthn.SessionData, error) {
+	j, err := access.GrantData(key, sessionData)
+	if err != nil {
+		return nil, err
+	}
+
+	if j == nil {
+		return nil, fmt.Errorf("no passkey ceremony in progress for %s", key)
+	}
+
+	err = access.SetGrantData(key, sessionData, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var session webauthn.SessionData
+	err = json.Unmarshal(j, &session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode passkey ceremony for %s, %v", key, err)
+	}
+
+	return &session, nil
+}
+
+func identityKey(req *http.Request) (string, bool) {
+	// delegated tokens can't register passkeys, which would let their
+	// holders log in with all of the grant's authority
+	identity, ok := access.FromContext(req.Context())
+	if !ok || len(identity.Chain) > 0 {
+		return "", false
+	}
+
+	return access.TenantKey(identity.Tenant, identity.Key), true
+}
+
+func writeJSON(res http.ResponseWriter, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(v)
+}
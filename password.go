@@ -0,0 +1,183 @@
+package access
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rules of a PasswordPolicy, as reported by PasswordPolicyError
+const (
+	RuleMinLength = "min_length"
+	RuleUpper     = "upper"
+	RuleLower     = "lower"
+	RuleDigit     = "digit"
+	RuleSymbol    = "symbol"
+	RuleBanned    = "banned"
+	RuleCustom    = "custom"
+
+	// RuleBreached is reported for passwords the PasswordChecker has found in
+	// a data breach
+	RuleBreached = "breached"
+)
+
+// PasswordPolicy is the set of rules passwords must meet when a grant is
+// created or its password is changed
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters
+	MinLength int
+
+	// RequireUpper, RequireLower, RequireDigit and RequireSymbol each require
+	// at least one character of their class
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+
+	// Banned lists passwords which are rejected regardless of case, such as
+	// "password" or the product's name
+	Banned []string
+
+	// Validate, if set, is called with passwords meeting every other rule, and
+	// any error it returns rejects the password
+	Validate func(password string) error
+}
+
+// PasswordPolicyError is returned for passwords which break a rule of the
+// PasswordPolicy
+type PasswordPolicyError struct {
+	Rule    string
+	Message string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return e.Message
+}
+
+// PasswordChecker reports whether a password is known to be compromised, such
+// as HaveIBeenPwned
+type PasswordChecker interface {
+	Breached(password string) (bool, error)
+}
+
+// UsePasswordPolicy sets the policy passwords must meet when Grant creates a
+// grant or its password is changed. Existing passwords are unaffected. The
+// zero PasswordPolicy, the default, accepts any non-empty password.
+func UsePasswordPolicy(policy PasswordPolicy) {
+	std.UsePasswordPolicy(policy)
+}
+
+// UsePasswordPolicy is the package UsePasswordPolicy for the grants in s's
+// Store
+func (s *Service) UsePasswordPolicy(policy PasswordPolicy) {
+	s.passwordPolicy = policy
+}
+
+// UsePasswordChecker sets the PasswordChecker consulted when Grant creates a
+// grant or its password is changed, after the PasswordPolicy. Breached
+// passwords are rejected with a *PasswordPolicyError for RuleBreached, and
+// passwords can't be set while the checker fails.
+func UsePasswordChecker(checker PasswordChecker) {
+	std.UsePasswordChecker(checker)
+}
+
+// UsePasswordChecker is the package UsePasswordChecker for the grants in s's
+// Store
+func (s *Service) UsePasswordChecker(checker PasswordChecker) {
+	s.passwordChecker = checker
+}
+
+// checkNewPassword checks a password being set against the PasswordPolicy and
+// the PasswordChecker of s
+func (s *Service) checkNewPassword(password string) error {
+	err := s.passwordPolicy.Check(password)
+	if err != nil {
+		return err
+	}
+
+	if s.passwordChecker == nil {
+		return nil
+	}
+
+	breached, err := s.passwordChecker.Breached(password)
+	if err != nil {
+		return fmt.Errorf("failed to check password, %v", err)
+	}
+
+	if breached {
+		return &PasswordPolicyError{
+			Rule:    RuleBreached,
+			Message: "password has appeared in a data breach",
+		}
+	}
+
+	return nil
+}
+
+// Check returns a *PasswordPolicyError describing the first rule password
+// breaks, or nil if it meets the policy
+func (p PasswordPolicy) Check(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return &PasswordPolicyError{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("password must be at least %d characters", p.MinLength),
+		}
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			symbol = true
+		}
+	}
+
+	classes := []struct {
+		required bool
+		present  bool
+		rule     string
+		name     string
+	}{
+		{p.RequireUpper, upper, RuleUpper, "an uppercase letter"},
+		{p.RequireLower, lower, RuleLower, "a lowercase letter"},
+		{p.RequireDigit, digit, RuleDigit, "a digit"},
+		{p.RequireSymbol, symbol, RuleSymbol, "a symbol"},
+	}
+
+	for _, c := range classes {
+		if c.required && !c.present {
+			return &PasswordPolicyError{
+				Rule:    c.rule,
+				Message: "password must contain " + c.name,
+			}
+		}
+	}
+
+	for _, banned := range p.Banned {
+		if strings.EqualFold(password, banned) {
+			return &PasswordPolicyError{
+				Rule:    RuleBanned,
+				Message: "password is too common",
+			}
+		}
+	}
+
+	if p.Validate != nil {
+		err := p.Validate(password)
+		if err != nil {
+			return &PasswordPolicyError{
+				Rule:    RuleCustom,
+				Message: err.Error(),
+			}
+		}
+	}
+
+	return nil
+}
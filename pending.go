@@ -0,0 +1,208 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// pendingRecord is the stored value for a key in the __apiPending bucket
+type pendingRecord struct {
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var (
+	pendingTTLMu sync.RWMutex
+	pendingTTL   time.Duration
+)
+
+// SetPendingTTL sets how long a key may stay pending before it is considered
+// abandoned and no longer blocks registration. A zero TTL, the default, keeps
+// pending keys until they are granted or cleared. Pending keys stored before
+// timestamps were recorded are treated as stale once a TTL is set.
+func SetPendingTTL(ttl time.Duration) {
+	pendingTTLMu.Lock()
+	pendingTTL = ttl
+	pendingTTLMu.Unlock()
+}
+
+// currentPendingTTL returns the TTL set by SetPendingTTL
+func currentPendingTTL() time.Duration {
+	pendingTTLMu.RLock()
+	defer pendingTTLMu.RUnlock()
+
+	return pendingTTL
+}
+
+// StartPendingSweeper purges stale pending keys every interval until the
+// returned stop func is called. It has no effect unless a TTL has been set
+// with SetPendingTTL.
+func StartPendingSweeper(interval time.Duration) (stop func()) {
+	return std.StartPendingSweeper(interval)
+}
+
+// StartPendingSweeper is the package StartPendingSweeper for the pending
+// users in s's Store
+func (s *Service) StartPendingSweeper(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				n, err := s.PurgeStalePending()
+				if err != nil {
+					s.logger.Error("failed to purge stale pending keys", "err", err)
+					continue
+				}
+
+				if n > 0 {
+					s.logger.Info("purged stale pending keys", "count", n)
+				}
+
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// PurgeStalePending removes all pending keys older than the pending TTL and
+// returns the number of keys removed
+func PurgeStalePending() (int, error) {
+	return std.PurgeStalePending()
+}
+
+// PurgeStalePending is the package PurgeStalePending for the pending users
+// in s's Store
+func (s *Service) PurgeStalePending() (int, error) {
+	if currentPendingTTL() <= 0 {
+		return 0, nil
+	}
+
+	var purged int
+	err := s.store.Update(func(tx Tx) error {
+		var stale []string
+		err := tx.ForEach(apiPendingUserStore, func(key string, value []byte) error {
+			if isStalePending(value) {
+				stale = append(stale, key)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range stale {
+			err := tx.Delete(apiPendingUserStore, key)
+			if err != nil {
+				return err
+			}
+		}
+
+		purged = len(stale)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Pending: %v", err)
+	}
+
+	return purged, nil
+}
+
+func newPendingRecord() ([]byte, error) {
+	return json.Marshal(pendingRecord{
+		Status:    "pending",
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+// isStalePending reports whether a stored pending value has outlived the
+// pending TTL
+func isStalePending(value []byte) bool {
+	ttl := currentPendingTTL()
+	if value == nil || ttl <= 0 {
+		return false
+	}
+
+	var rec pendingRecord
+	err := json.Unmarshal(value, &rec)
+	if err != nil || rec.CreatedAt.IsZero() {
+		return true
+	}
+
+	return time.Since(rec.CreatedAt) > ttl
+}
+
+// checkRegistrable fails if storeKey already has a grant, or is pending a
+// verification or invitation which hasn't gone stale
+func checkRegistrable(tx Tx, storeKey string) error {
+	active, err := tx.Get(apiAccessStore, storeKey)
+	if err != nil {
+		return err
+	}
+
+	if active != nil {
+		return ErrDuplicateKey
+	}
+
+	pending, err := tx.Get(apiPendingUserStore, storeKey)
+	if err != nil {
+		return err
+	}
+
+	if pending != nil && !isStalePending(pending) {
+		return ErrPending
+	}
+
+	return nil
+}
+
+// activatePending stores a as the grant for its pending key within tx, once
+// the token of a verification or invitation for it is redeemed. Its owner is
+// logged in with the password they chose, so a session is started and the
+// expiry of the token issued with cfg is returned.
+func (s *Service) activatePending(tx Tx, a *APIAccess, cfg *Config) (time.Time, error) {
+	storeKey := TenantKey(a.Tenant, a.Key)
+	active, err := tx.Get(apiAccessStore, storeKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if active != nil {
+		return time.Time{}, ErrDuplicateKey
+	}
+
+	a.amr = []string{"pwd"}
+	a.LastLoginAt = time.Now()
+
+	// tokens revoked for a grant previously held at the key stay revoked
+	a.ver, err = tokenVersion(tx, storeKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	exp, err := a.newToken(s.signer, cfg)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	err = startSession(tx, storeKey, a, cfg, exp)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	err = putGrant(tx, a)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return exp, tx.Delete(apiPendingUserStore, storeKey)
+}
@@ -0,0 +1,97 @@
+package access
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	pepperMu      sync.RWMutex
+	peppers       map[int][]byte
+	pepperVersion int
+)
+
+// SetPeppers sets the server-side secrets mixed into password hashes, by
+// version. Unlike salts, peppers aren't stored with grants, so a leaked
+// database alone can't be used to guess passwords. New hashes use the highest
+// version, and grants hashed with an older version, or without a pepper, are
+// transparently re-hashed with it the next time they Login successfully. Keep
+// older versions set until every grant has moved on, as grants hashed with a
+// version which isn't set can't Login. Passing no peppers, the default,
+// disables peppering.
+func SetPeppers(versions map[int]string) error {
+	p := make(map[int][]byte, len(versions))
+	current := 0
+	for version, pepper := range versions {
+		if version <= 0 {
+			return fmt.Errorf("pepper version must be positive, got %d", version)
+		}
+
+		if pepper == "" {
+			return fmt.Errorf("pepper version %d must not be empty", version)
+		}
+
+		p[version] = []byte(pepper)
+		if version > current {
+			current = version
+		}
+	}
+
+	pepperMu.Lock()
+	defer pepperMu.Unlock()
+
+	peppers = p
+	pepperVersion = current
+	return nil
+}
+
+// SetPeppersFromEnv calls SetPeppers with the peppers held in the environment
+// variable name, as comma separated version:pepper pairs such as
+// "2:n3wS3cret,1:0ldS3cret"
+func SetPeppersFromEnv(name string) error {
+	versions := make(map[int]string)
+	for _, pair := range splitList([]string{os.Getenv(name)}) {
+		v, pepper, ok := strings.Cut(pair, ":")
+		version, err := strconv.Atoi(v)
+		if !ok || err != nil {
+			return fmt.Errorf("invalid pepper in %s, expected version:pepper", name)
+		}
+
+		versions[version] = pepper
+	}
+
+	return SetPeppers(versions)
+}
+
+// currentPepper returns the version of the pepper mixed into new hashes
+func currentPepper() int {
+	pepperMu.RLock()
+	defer pepperMu.RUnlock()
+
+	return pepperVersion
+}
+
+// pepper mixes the pepper of version into password, or returns it as is for
+// version 0. The result is kept short enough for bcrypt to use all of it.
+func pepper(password string, version int) (string, bool) {
+	if version == 0 {
+		return password, true
+	}
+
+	pepperMu.RLock()
+	secret, ok := peppers[version]
+	pepperMu.RUnlock()
+	if !ok {
+		return "", false
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(password))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), true
+}
@@ -0,0 +1,98 @@
+package access
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Decision is the outcome of a Policy
+type Decision int
+
+const (
+	// Abstain leaves the decision to the next Policy, or to GateKeeper's
+	// Authorizers
+	Abstain Decision = iota
+
+	// Allow lets the request through without further checks
+	Allow
+
+	// Deny rejects the request
+	Deny
+)
+
+// Policy decides whether GateKeeper lets a request through. identity is nil
+// if the request holds no valid token.
+type Policy interface {
+	Allow(req *http.Request, identity *Identity) Decision
+}
+
+// PolicyFunc adapts a func into a Policy
+type PolicyFunc func(req *http.Request, identity *Identity) Decision
+
+// Allow calls f
+func (f PolicyFunc) Allow(req *http.Request, identity *Identity) Decision {
+	return f(req, identity)
+}
+
+type chain []Policy
+
+// Chain returns a Policy which consults each of policies in order, returning
+// the first decision which isn't Abstain
+func Chain(policies ...Policy) Policy {
+	return chain(policies)
+}
+
+func (c chain) Allow(req *http.Request, identity *Identity) Decision {
+	for _, p := range c {
+		d := p.Allow(req, identity)
+		if d != Abstain {
+			return d
+		}
+	}
+
+	return Abstain
+}
+
+// PublicPath returns a Policy which allows every request whose path begins
+// with one of prefixes
+func PublicPath(prefixes ...string) Policy {
+	return PolicyFunc(func(req *http.Request, identity *Identity) Decision {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(req.URL.Path, prefix) {
+				return Allow
+			}
+		}
+
+		return Abstain
+	})
+}
+
+// RoleForMethods returns a Policy which denies requests using one of methods,
+// such as "POST" or "DELETE", unless their token's grant holds role
+func RoleForMethods(role string, methods ...string) Policy {
+	return PolicyFunc(func(req *http.Request, identity *Identity) Decision {
+		for _, method := range methods {
+			if req.Method == method && !identity.HasRole(role) {
+				return Deny
+			}
+		}
+
+		return Abstain
+	})
+}
+
+// UsePolicy sets the policies GateKeeper consults, in order, before its
+// default check. Calling it with no policies restores the default behavior.
+func UsePolicy(policies ...Policy) {
+	std.UsePolicy(policies...)
+}
+
+// UsePolicy is the package UsePolicy for the GateKeeper of s
+func (s *Service) UsePolicy(policies ...Policy) {
+	if len(policies) == 0 {
+		s.policy = nil
+		return
+	}
+
+	s.policy = Chain(policies...)
+}
@@ -0,0 +1,128 @@
+package access
+
+import (
+	"fmt"
+	"time"
+)
+
+// ProvisionGrant returns the grant described by gr with its groups, creating
+// it without a password if it doesn't exist, for the owners of keys who
+// authenticate elsewhere, such as with an identity provider, and are then
+// issued tokens with IssueToken. gr.Password must be empty, and the roles,
+// scopes, admin flag and metadata of an existing grant are kept as they are.
+// Service accounts are never returned, failing with ErrUnauthorized, nor are
+// disabled or expired grants, failing as Login does. A grant removed by
+// ClearGrant isn't created again while RestoreGrant can still bring it back,
+// failing with ErrUnauthorized, so a cleared grant stays cleared.
+func ProvisionGrant(gr GrantRequest) (*APIAccess, error) {
+	return std.ProvisionGrant(gr)
+}
+
+// ProvisionGrant is the package ProvisionGrant for the grants in s's Store
+func (s *Service) ProvisionGrant(gr GrantRequest) (*APIAccess, error) {
+	if gr.Key == "" {
+		return nil, fmt.Errorf("%s", "key must not be empty")
+	}
+
+	if gr.Password != "" {
+		return nil, fmt.Errorf("%s", "provisioned grants must not have a password")
+	}
+
+	err := validateTenantID(gr.Tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateKey(gr.Key, gr.Tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateTags(gr.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	storeKey := TenantKey(gr.Tenant, gr.Key)
+
+	var a *APIAccess
+	err = s.store.View(func(tx Tx) error {
+		var err error
+		a, _, err = getGrant(tx, storeKey)
+		if err != nil {
+			return err
+		}
+
+		err = provisionable(tx, storeKey, a)
+		if err != nil || a == nil {
+			return err
+		}
+
+		a.Groups, err = groupsOf(tx, storeKey)
+		return err
+	})
+	if err != nil || a != nil {
+		return a, err
+	}
+
+	var created bool
+	err = s.store.Update(func(tx Tx) error {
+		var err error
+		a, _, err = getGrant(tx, storeKey)
+		if err != nil {
+			return err
+		}
+
+		err = provisionable(tx, storeKey, a)
+		if err != nil || a != nil {
+			return err
+		}
+
+		a = &APIAccess{
+			Key:      gr.Key,
+			Tenant:   gr.Tenant,
+			Roles:    gr.Roles,
+			Scopes:   gr.Scopes,
+			Admin:    gr.Admin,
+			Metadata: gr.Metadata,
+			Tags:     gr.Tags,
+		}
+
+		created = true
+		return putGrant(tx, a)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if created {
+		s.grantCreated(storeKey, nil)
+	}
+
+	return a, nil
+}
+
+// provisionable fails if a, the grant stored for storeKey, must not be
+// returned by ProvisionGrant, or if there is none and the grant removed from
+// storeKey by ClearGrant can still be restored
+func provisionable(tx Tx, storeKey string, a *APIAccess) error {
+	now := time.Now()
+	if a == nil {
+		d, err := getDeletedGrant(tx, storeKey)
+		if err != nil {
+			return err
+		}
+
+		if d != nil && !d.expired(now) {
+			return fmt.Errorf("%s was cleared: %w", storeKey, ErrUnauthorized)
+		}
+
+		return nil
+	}
+
+	if a.ServiceAccount {
+		return fmt.Errorf("%s is a service account: %w", storeKey, ErrUnauthorized)
+	}
+
+	return a.inactive(now)
+}
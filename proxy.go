@@ -0,0 +1,109 @@
+package access
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	trustedProxiesMu sync.RWMutex
+	trustedProxies   []*net.IPNet
+)
+
+// SetTrustedProxies sets the networks of the reverse proxies and load
+// balancers in front of the server, such as "10.0.0.0/8". For requests
+// received from them, the client address is read from the Forwarded,
+// X-Forwarded-For or X-Real-IP header, skipping any further trusted hops, so
+// rate limits, network allowlists and LocalNetworks see the real client.
+// Headers of requests from any other address are ignored, as clients can set
+// them freely. Passing no cidrs, the default, trusts no proxy.
+func SetTrustedProxies(cidrs ...string) error {
+	networks, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+
+	trustedProxiesMu.Lock()
+	trustedProxies = networks
+	trustedProxiesMu.Unlock()
+	return nil
+}
+
+// remoteIP returns the IP address of the client req was received from, which
+// is the address of its connection unless that is a trusted proxy
+func remoteIP(req *http.Request) net.IP {
+	trustedProxiesMu.RLock()
+	trusted := trustedProxies
+	trustedProxiesMu.RUnlock()
+
+	ip := parseHost(req.RemoteAddr)
+	if !inNetworks(ip, trusted) {
+		return ip
+	}
+
+	hops := forwardedFor(req.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		hops = splitList(req.Header.Values("X-Forwarded-For"))
+	}
+	if len(hops) == 0 {
+		hops = splitList(req.Header.Values("X-Real-IP"))
+	}
+
+	// hops are appended by each proxy, so the client is the last address
+	// which wasn't added on behalf of a trusted proxy
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHost(hops[i])
+		if hop == nil {
+			break
+		}
+
+		ip = hop
+		if !inNetworks(hop, trusted) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// forwardedFor returns the "for" parameters of Forwarded headers, as described
+// by RFC 7239
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, element := range splitList(values) {
+		for _, pair := range strings.Split(element, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(name, "for") {
+				hops = append(hops, strings.Trim(value, `"`))
+			}
+		}
+	}
+
+	return hops
+}
+
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+
+	return items
+}
+
+// parseHost returns the IP address of host, which may have a port and be
+// enclosed in brackets
+func parseHost(host string) net.IP {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return net.ParseIP(strings.Trim(host, "[]"))
+}
@@ -0,0 +1,177 @@
+package access
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned by Login and Grant when the key or the source IP
+// of the request has made too many attempts
+var ErrRateLimited = errors.New("too many attempts, please try again later")
+
+var (
+	attemptMu       sync.RWMutex
+	attemptLimit    int
+	attemptInterval time.Duration
+)
+
+// rateBucket is the stored token bucket for a key or source IP in the
+// __apiRateLimit bucket
+type rateBucket struct {
+	Tokens  float64   `json:"tokens"`
+	Updated time.Time `json:"updated"`
+}
+
+// SetAttemptLimit limits Login and Grant to limit attempts per interval for
+// each key, and for each source IP of requests set as Config.Request, with
+// bursts of up to limit attempts. Attempts beyond the limit fail with
+// ErrRateLimited before any password is checked. A zero limit, the default,
+// disables rate limiting.
+func SetAttemptLimit(limit int, interval time.Duration) {
+	attemptMu.Lock()
+	defer attemptMu.Unlock()
+
+	attemptLimit = limit
+	attemptInterval = interval
+}
+
+// attemptRate returns the limit and interval set by SetAttemptLimit
+func attemptRate() (int, time.Duration) {
+	attemptMu.RLock()
+	defer attemptMu.RUnlock()
+
+	return attemptLimit, attemptInterval
+}
+
+// PurgeRateLimits removes the rate limit state of keys and source IPs which
+// have fully recovered, and the StoreBacked RateLimit counts of windows which
+// have ended, and returns the number removed
+func PurgeRateLimits() (int, error) {
+	return std.PurgeRateLimits()
+}
+
+// PurgeRateLimits is the package PurgeRateLimits for s's Store
+func (s *Service) PurgeRateLimits() (int, error) {
+	var purged int
+	now := time.Now()
+	limit, _ := attemptRate()
+	err := s.store.Update(func(tx Tx) error {
+		var full []string
+		err := tx.ForEach(apiRateLimitStore, func(key string, value []byte) error {
+			var b rateBucket
+			if json.Unmarshal(value, &b) != nil || refill(b, now) >= float64(limit) {
+				full = append(full, key)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range full {
+			err := tx.Delete(apiRateLimitStore, key)
+			if err != nil {
+				return err
+			}
+		}
+
+		purged = len(full)
+
+		ended, err := purgeRequestWindows(tx, now)
+		purged += ended
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
+
+// takeAttempt records an attempt for storeKey and the source IP of
+// cfg.Request, returning ErrRateLimited if either has none left. Attempts are
+// recorded in their own transaction so failed attempts still count.
+func (s *Service) takeAttempt(cfg *Config, storeKey string) error {
+	limit, interval := attemptRate()
+	if limit <= 0 || interval <= 0 {
+		return nil
+	}
+
+	ids := []string{"key:" + storeKey}
+	if ip := requestIP(cfg.Request); ip != "" {
+		ids = append(ids, "ip:"+ip)
+	}
+
+	now := time.Now()
+	return s.store.Update(func(tx Tx) error {
+		for _, id := range ids {
+			b := rateBucket{Tokens: float64(limit)}
+			j, err := tx.Get(apiRateLimitStore, id)
+			if err != nil {
+				return err
+			}
+
+			if j != nil {
+				err = json.Unmarshal(j, &b)
+				if err != nil {
+					return err
+				}
+
+				b.Tokens = refill(b, now)
+			}
+
+			if b.Tokens < 1 {
+				return ErrRateLimited
+			}
+
+			b.Tokens--
+			b.Updated = now
+
+			j, err = json.Marshal(b)
+			if err != nil {
+				return err
+			}
+
+			err = tx.Put(apiRateLimitStore, id, j)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// refill returns the tokens in b at now, replenished at attemptLimit per
+// attemptInterval
+func refill(b rateBucket, now time.Time) float64 {
+	limit, interval := attemptRate()
+	if interval <= 0 {
+		return float64(limit)
+	}
+
+	elapsed := now.Sub(b.Updated)
+	tokens := b.Tokens + float64(limit)*elapsed.Seconds()/interval.Seconds()
+	if tokens > float64(limit) {
+		return float64(limit)
+	}
+
+	return tokens
+}
+
+func requestIP(req *http.Request) string {
+	if req == nil {
+		return ""
+	}
+
+	ip := remoteIP(req)
+	if ip == nil {
+		return ""
+	}
+
+	return ip.String()
+}
@@ -0,0 +1,216 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitBackend is where RateLimit counts requests
+type RateLimitBackend int
+
+const (
+	// MemoryBacked counts requests in the memory of the process, which is
+	// fastest but not shared by other processes serving the same API
+	MemoryBacked RateLimitBackend = iota
+
+	// StoreBacked counts requests in the __apiRequestLimit bucket of the
+	// Store, so every process using the Store shares the limit, at the cost of
+	// a write per request
+	StoreBacked
+)
+
+// requestWindow is the count of requests made by a grant in the window
+// starting at Start
+type requestWindow struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// RateLimit returns middleware which only calls next for the first limit
+// requests of each grant in every window, identifying the grant from the
+// request's valid token, API key or request signature. Requests without one
+// are rejected with 401 Unauthorized, and requests over the limit with 429 Too
+// Many Requests and a Retry-After header holding the seconds until the window
+// ends. Place it after GateKeeper, or use it alone.
+func RateLimit(limit int, window time.Duration, backend RateLimitBackend) func(next http.HandlerFunc) http.HandlerFunc {
+	return std.RateLimit(limit, window, backend)
+}
+
+// RateLimit is the package RateLimit, keeping windows in s's Store
+func (s *Service) RateLimit(limit int, window time.Duration, backend RateLimitBackend) func(next http.HandlerFunc) http.HandlerFunc {
+	var take func(key string, now time.Time) (time.Duration, error)
+	switch backend {
+	case StoreBacked:
+		prefix := fmt.Sprintf("%d/%s:", limit, window)
+		take = func(key string, now time.Time) (time.Duration, error) {
+			return s.takeStoredRequest(prefix+key, limit, window, now)
+		}
+
+	default:
+		counts := &requestCounts{windows: make(map[string]*requestWindow)}
+		take = func(key string, now time.Time) (time.Duration, error) {
+			return counts.take(key, limit, window, now), nil
+		}
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			key, ok := s.requestKey(req)
+			if !ok {
+				res.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			retryAfter, err := take(key, time.Now())
+			if err != nil {
+				s.logger.Error("failed to count request", "key", key, "err", err)
+				res.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if retryAfter > 0 {
+				res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				res.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
+// requestKey returns the namespaced key of the grant making req, from the
+// identity GateKeeper set or else the request's credentials
+func (s *Service) requestKey(req *http.Request) (string, bool) {
+	if identity, ok := FromContext(req.Context()); ok {
+		return TenantKey(identity.Tenant, identity.Key), true
+	}
+
+	claims, ok := s.requestClaims(req)
+	if !ok {
+		return "", false
+	}
+
+	return claimKey(claims), true
+}
+
+// add counts a request made at now in w, returning how long until the window
+// ends if it is over limit
+func (w *requestWindow) add(limit int, window time.Duration, now time.Time) time.Duration {
+	if !now.Before(w.Start.Add(window)) {
+		w.Start = now
+		w.Count = 0
+	}
+
+	if w.Count >= limit {
+		return w.Start.Add(window).Sub(now)
+	}
+
+	w.Count++
+	return 0
+}
+
+// requestCounts are the windows of a MemoryBacked RateLimit
+type requestCounts struct {
+	mu        sync.Mutex
+	windows   map[string]*requestWindow
+	nextSweep time.Time
+}
+
+func (c *requestCounts) take(key string, limit int, window time.Duration, now time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// forget grants idle for a whole window, at most once per window
+	if now.After(c.nextSweep) {
+		for k, w := range c.windows {
+			if !now.Before(w.Start.Add(window)) {
+				delete(c.windows, k)
+			}
+		}
+
+		c.nextSweep = now.Add(window)
+	}
+
+	w, ok := c.windows[key]
+	if !ok {
+		w = &requestWindow{Start: now}
+		c.windows[key] = w
+	}
+
+	return w.add(limit, window, now)
+}
+
+// takeStoredRequest is requestCounts.take for a StoreBacked RateLimit
+func (s *Service) takeStoredRequest(id string, limit int, window time.Duration, now time.Time) (time.Duration, error) {
+	var retryAfter time.Duration
+	err := s.store.Update(func(tx Tx) error {
+		w := requestWindow{Start: now}
+		j, err := tx.Get(apiRequestLimitStore, id)
+		if err != nil {
+			return err
+		}
+
+		if j != nil {
+			err = json.Unmarshal(j, &w)
+			if err != nil {
+				return fmt.Errorf("failed to decode request count for %s, %v", id, err)
+			}
+		}
+
+		retryAfter = w.add(limit, window, now)
+		if retryAfter > 0 {
+			return nil
+		}
+
+		j, err = json.Marshal(w)
+		if err != nil {
+			return err
+		}
+
+		return tx.Put(apiRequestLimitStore, id, j)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return retryAfter, nil
+}
+
+// purgeRequestWindows removes the StoreBacked RateLimit counts of windows
+// ended at now, returning the number removed
+func purgeRequestWindows(tx Tx, now time.Time) (int, error) {
+	var ended []string
+	err := tx.ForEach(apiRequestLimitStore, func(id string, value []byte) error {
+		var w requestWindow
+		err := json.Unmarshal(value, &w)
+
+		// ids are prefixed by their limit and window, such as "100/1m0s:"
+		_, rest, _ := strings.Cut(id, "/")
+		d, _, _ := strings.Cut(rest, ":")
+		window, perr := time.ParseDuration(d)
+		if err != nil || perr != nil || !now.Before(w.Start.Add(window)) {
+			ended = append(ended, id)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range ended {
+		err := tx.Delete(apiRequestLimitStore, id)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(ended), nil
+}
@@ -0,0 +1,365 @@
+package access
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// resetRecord is the stored value for a reset token in the __apiReset bucket,
+// keyed by the token's hash
+type resetRecord struct {
+	Key     string    `json:"key"`
+	Expires time.Time `json:"expires"`
+}
+
+var (
+	resetMu  sync.RWMutex
+	resetTTL = time.Hour
+	resetURL string
+)
+
+// SetResetTTL sets how long password reset tokens are valid for, which is an
+// hour by default
+func SetResetTTL(ttl time.Duration) {
+	resetMu.Lock()
+	resetTTL = ttl
+	resetMu.Unlock()
+}
+
+// SetResetURL sets the link sent by the Mailer to reset a password, to which
+// the token is added as the "token" query parameter
+func SetResetURL(u string) {
+	resetMu.Lock()
+	resetURL = u
+	resetMu.Unlock()
+}
+
+// resetSettings returns the TTL and URL of password resets
+func resetSettings() (time.Duration, string) {
+	resetMu.RLock()
+	defer resetMu.RUnlock()
+
+	return resetTTL, resetURL
+}
+
+// RequestPasswordReset returns a single-use token which resets the password of
+// the grant for key when passed to CompletePasswordReset. If a Mailer has been
+// set, the token is sent to the grant's owner as a link to the reset URL, and
+// otherwise it is for you to send. Only a hash of the token is stored.
+func RequestPasswordReset(key string) (string, error) {
+	return std.RequestPasswordReset(key)
+}
+
+// RequestPasswordReset is the package RequestPasswordReset for the grants
+// in s's Store
+func (s *Service) RequestPasswordReset(key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("%s", "key must not be empty")
+	}
+
+	token, hash, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	ttl, link := resetSettings()
+	j, err := json.Marshal(resetRecord{
+		Key:     key,
+		Expires: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var owner string
+	err = s.store.Update(func(tx Tx) error {
+		a, _, err := getGrant(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if a == nil {
+			return notFound(key)
+		}
+
+		if a.ServiceAccount {
+			return fmt.Errorf("%s is a service account, which must not have a password", key)
+		}
+
+		owner = a.Key
+		return tx.Put(apiResetStore, hash, j)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = s.sendMail(owner, MailPasswordReset, token, tokenLink(link, token))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// CompletePasswordReset sets newPassword as the password of the grant a reset
+// token was issued for, unlocks it and revokes its tokens. The reset token
+// can't be used again.
+func CompletePasswordReset(token, newPassword string) error {
+	return std.CompletePasswordReset(token, newPassword)
+}
+
+// CompletePasswordReset is the package CompletePasswordReset for the grants
+// in s's Store
+func (s *Service) CompletePasswordReset(token, newPassword string) error {
+	if newPassword == "" {
+		return fmt.Errorf("%s", "password must not be empty")
+	}
+
+	// the token is checked before the password, whose PasswordChecker may
+	// make network calls and whose hash is costly, so requests with made up
+	// tokens are turned away cheaply
+	var expired bool
+	hash := hashSecret(token)
+	err := s.store.View(func(tx Tx) error {
+		rec, err := readReset(tx, hash)
+		if err != nil {
+			return err
+		}
+
+		if time.Now().After(rec.Expires) {
+			expired = true
+			return nil
+		}
+
+		a, _, err := getGrant(tx, rec.Key)
+		if err != nil {
+			return err
+		}
+
+		return resettable(a, rec.Key)
+	})
+	if err != nil {
+		return err
+	}
+
+	if expired {
+		return s.expireToken(apiResetStore, hash, "password reset token has expired")
+	}
+
+	err = s.checkNewPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	hashed := &APIAccess{}
+	err = s.hashPassword(hashed, newPassword)
+	if err != nil {
+		return err
+	}
+
+	var key string
+	err = s.store.Update(func(tx Tx) error {
+		rec, err := readReset(tx, hash)
+		if err != nil {
+			return err
+		}
+
+		if time.Now().After(rec.Expires) {
+			return fmt.Errorf("%s", "password reset token has expired")
+		}
+
+		key = rec.Key
+
+		// the other reset tokens of the grant are spent along with this one
+		err = clearResets(tx, rec.Key)
+		if err != nil {
+			return err
+		}
+
+		a, _, err := getGrant(tx, rec.Key)
+		if err != nil {
+			return err
+		}
+
+		err = resettable(a, rec.Key)
+		if err != nil {
+			return err
+		}
+
+		a.Hash = hashed.Hash
+		a.Salt = hashed.Salt
+		a.HashAlgorithm = hashed.HashAlgorithm
+		a.PepperVersion = hashed.PepperVersion
+		a.FailedLogins = 0
+		a.LockedUntil = time.Time{}
+
+		err = revokeTokens(tx, rec.Key)
+		if err != nil {
+			return err
+		}
+
+		return putGrant(tx, a)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.sessionStates.forget(key)
+	return nil
+}
+
+// readReset returns the record of the reset token stored under hash
+func readReset(tx Tx, hash string) (*resetRecord, error) {
+	j, err := tx.Get(apiResetStore, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if j == nil {
+		return nil, fmt.Errorf("%s", "invalid password reset token")
+	}
+
+	var rec resetRecord
+	err = json.Unmarshal(j, &rec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode password reset token, %v", err)
+	}
+
+	return &rec, nil
+}
+
+// resettable fails unless a, the grant for key, can have its password reset
+func resettable(a *APIAccess, key string) error {
+	if a == nil {
+		return notFound(key)
+	}
+
+	if a.ServiceAccount {
+		return fmt.Errorf("%s is a service account, which must not have a password", key)
+	}
+
+	return a.inactive(time.Now())
+}
+
+// clearResets removes every reset token issued for the grant for key
+func clearResets(tx Tx, key string) error {
+	var hashes []string
+	err := tx.ForEach(apiResetStore, func(hash string, value []byte) error {
+		var rec resetRecord
+		if json.Unmarshal(value, &rec) == nil && rec.Key == key {
+			hashes = append(hashes, hash)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		err = tx.Delete(apiResetStore, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expireToken removes the expired token stored under hash in bucket, and
+// returns an error with msg
+func (s *Service) expireToken(bucket, hash, msg string) error {
+	err := s.store.Update(func(tx Tx) error {
+		return tx.Delete(bucket, hash)
+	})
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%s", msg)
+}
+
+// PasswordResetRequestHandler handles POST requests with a "key" form value by
+// calling send with a new reset token for the grant. send may be nil if a
+// Mailer has been set to deliver the token. It responds 202 Accepted whether
+// or not the grant exists, so keys can't be discovered through it.
+func PasswordResetRequestHandler(send func(key, token string) error) http.HandlerFunc {
+	return std.PasswordResetRequestHandler(send)
+}
+
+// PasswordResetRequestHandler is the package PasswordResetRequestHandler
+// for the grants in s's Store
+func (s *Service) PasswordResetRequestHandler(send func(key, token string) error) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		key := req.PostFormValue("key")
+		token, err := s.RequestPasswordReset(key)
+		if err == nil && send != nil {
+			err = send(key, token)
+		}
+
+		// failures are only logged, as responding differently would tell
+		// which keys have grants
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			s.logger.Error("failed to request password reset", "err", err)
+		}
+
+		res.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// PasswordResetHandler handles POST requests with "token" and "password" form
+// values by completing the reset, responding 204 No Content on success or 400
+// Bad Request with the reason it failed
+func PasswordResetHandler() http.HandlerFunc {
+	return std.PasswordResetHandler()
+}
+
+// PasswordResetHandler is the package PasswordResetHandler for the grants
+// in s's Store
+func (s *Service) PasswordResetHandler() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		err := s.CompletePasswordReset(req.PostFormValue("token"), req.PostFormValue("password"))
+		if err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			res.Write([]byte(err.Error()))
+			return
+		}
+
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// newSecretToken returns a random token and the hash to store it under
+func newSecretToken() (string, string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate token, %v", err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashSecret(token), nil
+}
+
+func hashSecret(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,160 @@
+package access_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+// breachedChecker counts the passwords it is asked about, reporting none as
+// breached
+type breachedChecker struct {
+	calls int
+}
+
+func (c *breachedChecker) Breached(password string) (bool, error) {
+	c.calls++
+	return false, nil
+}
+
+func TestPasswordReset(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	old := accesstest.Token(t, "reset@example.com")
+	token, err := access.RequestPasswordReset("reset@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = access.CompletePasswordReset(token, "a new "+accesstest.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if access.IsGranted(bearer(http.MethodGet, old), http.Header{}) {
+		t.Error("token issued before the reset accepted")
+	}
+
+	_, err = access.Login("reset@example.com", accesstest.Password, headerConfig(""))
+	if err == nil {
+		t.Error("old password accepted after the reset")
+	}
+
+	_, err = access.Login("reset@example.com", "a new "+accesstest.Password, headerConfig(""))
+	if err != nil {
+		t.Errorf("new password rejected: %v", err)
+	}
+
+	err = access.CompletePasswordReset(token, "another "+accesstest.Password)
+	if err == nil {
+		t.Error("reset token used twice")
+	}
+}
+
+func TestPasswordResetChecksTokenFirst(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+	accesstest.Token(t, "taken@example.com")
+
+	checker := &breachedChecker{}
+	access.UsePasswordChecker(checker)
+	t.Cleanup(func() { access.UsePasswordChecker(nil) })
+
+	err := access.CompletePasswordReset("made-up", "a new "+accesstest.Password)
+	if err == nil {
+		t.Fatal("made up reset token accepted")
+	}
+
+	if checker.calls != 0 {
+		t.Errorf("password checked %d times for a made up token", checker.calls)
+	}
+
+	_, err = access.AcceptInvite("made-up", accesstest.Password, headerConfig(""))
+	if err == nil {
+		t.Fatal("made up invitation token accepted")
+	}
+
+	if checker.calls != 0 {
+		t.Errorf("password checked %d times for a made up invitation", checker.calls)
+	}
+
+	_, err = access.PendingWithVerification("taken@example.com", accesstest.Password, headerConfig(""))
+	if !errors.Is(err, access.ErrDuplicateKey) {
+		t.Errorf("got %v, want ErrDuplicateKey", err)
+	}
+
+	if checker.calls != 0 {
+		t.Errorf("password checked %d times for a key already granted", checker.calls)
+	}
+}
+
+func TestPasswordResetDisabledGrant(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	accesstest.Token(t, "disabled@example.com")
+	token, err := access.RequestPasswordReset("disabled@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = access.Disable("disabled@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = access.CompletePasswordReset(token, "a new "+accesstest.Password)
+	if !errors.Is(err, access.ErrDisabled) {
+		t.Errorf("got %v, want ErrDisabled", err)
+	}
+}
+
+func TestPasswordResetSpendsOtherTokens(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	accesstest.Token(t, "twice@example.com")
+	first, err := access.RequestPasswordReset("twice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := access.RequestPasswordReset("twice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = access.CompletePasswordReset(second, "a new "+accesstest.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = access.CompletePasswordReset(first, "another "+accesstest.Password)
+	if err == nil {
+		t.Error("earlier reset token accepted after another was used")
+	}
+}
+
+func TestPasswordResetRequestHandler(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	accesstest.Token(t, "handler@example.com")
+	handler := access.PasswordResetRequestHandler(func(key, token string) error {
+		return errors.New("mail server unavailable")
+	})
+
+	for _, key := range []string{"handler@example.com", "nobody@example.com"} {
+		form := url.Values{"key": {key}}
+		req := httptest.NewRequest(http.MethodPost, "/reset", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		res := httptest.NewRecorder()
+		handler(res, req)
+		if res.Code != http.StatusAccepted {
+			t.Errorf("%s: got status %d, want %d", key, res.Code, http.StatusAccepted)
+		}
+	}
+}
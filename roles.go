@@ -0,0 +1,146 @@
+package access
+
+import (
+	"net/http"
+	"sync"
+)
+
+var (
+	roleHierarchyMu sync.RWMutex
+	roleHierarchy   map[string][]string
+)
+
+// SetRoles replaces the roles held by the grant for key. Roles are added to
+// tokens as the "roles" claim, so tokens issued before the change keep their
+// previous roles until the next Grant or Login.
+func SetRoles(key string, roles ...string) error {
+	return std.SetRoles(key, roles...)
+}
+
+// SetRoles is the package SetRoles for the grants in s's Store
+func (s *Service) SetRoles(key string, roles ...string) error {
+	return s.modifyGrant(key, func(a *APIAccess) error {
+		a.Roles = roles
+		return nil
+	})
+}
+
+// SetRoleHierarchy defines the roles each role inherits, so a grant holding a
+// role also satisfies every role beneath it. For example, with
+//
+//	map[string][]string{"admin": {"editor"}, "editor": {"viewer"}}
+//
+// an admin passes RequireRole("viewer"). Inheritance is resolved when tokens
+// are checked, so changes apply to existing tokens immediately.
+func SetRoleHierarchy(hierarchy map[string][]string) {
+	h := make(map[string][]string, len(hierarchy))
+	for role, inherits := range hierarchy {
+		h[role] = append([]string(nil), inherits...)
+	}
+
+	roleHierarchyMu.Lock()
+	roleHierarchy = h
+	roleHierarchyMu.Unlock()
+}
+
+// HasRole validates the access token held within the provided tokenStore and
+// checks whether its grant holds role, directly or through the role hierarchy
+func HasRole(req *http.Request, tokenStore reqHeaderOrHTTPCookie, role string) bool {
+	return std.HasRole(req, tokenStore, role)
+}
+
+// HasRole is the package HasRole, verifying tokens with s's Signer
+func (s *Service) HasRole(req *http.Request, tokenStore reqHeaderOrHTTPCookie, role string) bool {
+	claims, ok := s.grantedClaims(req, tokenStore)
+	if !ok {
+		return false
+	}
+
+	return hasRole(claimStrings(claims, "roles"), role)
+}
+
+// RequireRole returns middleware which only calls next for requests holding a
+// valid token whose grant holds role, directly or through the role hierarchy.
+// Requests without a valid token are rejected with 401 Unauthorized, and those
+// lacking the role with 403 Forbidden.
+func RequireRole(role string) func(next http.HandlerFunc) http.HandlerFunc {
+	return std.RequireRole(role)
+}
+
+// RequireRole is the package RequireRole, verifying tokens with s's Signer
+func (s *Service) RequireRole(role string) func(next http.HandlerFunc) http.HandlerFunc {
+	return s.RequireClaim("roles", func(claim interface{}) bool {
+		return hasRole(stringList(claim), role)
+	})
+}
+
+// hasRole reports whether any of held is role or inherits it
+func hasRole(held []string, role string) bool {
+	roleHierarchyMu.RLock()
+	defer roleHierarchyMu.RUnlock()
+
+	seen := make(map[string]bool)
+	queue := append([]string(nil), held...)
+	for len(queue) > 0 {
+		r := queue[0]
+		queue = queue[1:]
+
+		if r == role {
+			return true
+		}
+
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+
+		queue = append(queue, roleHierarchy[r]...)
+	}
+
+	return false
+}
+
+// SetAdmin sets whether the grant for key is an admin grant, such as an
+// operator key for management endpoints. Admin grants are marked with the
+// "admin" claim, so tokens issued before the change keep their previous tier
+// until the next Grant or Login.
+func SetAdmin(key string, admin bool) error {
+	return std.SetAdmin(key, admin)
+}
+
+// SetAdmin is the package SetAdmin for the grants in s's Store
+func (s *Service) SetAdmin(key string, admin bool) error {
+	return s.modifyGrant(key, func(a *APIAccess) error {
+		a.Admin = admin
+		return nil
+	})
+}
+
+// IsAdmin validates the access token held within the provided tokenStore and
+// checks whether its grant is an admin grant
+func IsAdmin(req *http.Request, tokenStore reqHeaderOrHTTPCookie) bool {
+	return std.IsAdmin(req, tokenStore)
+}
+
+// IsAdmin is the package IsAdmin, verifying tokens with s's Signer
+func (s *Service) IsAdmin(req *http.Request, tokenStore reqHeaderOrHTTPCookie) bool {
+	claims, ok := s.grantedClaims(req, tokenStore)
+	if !ok {
+		return false
+	}
+
+	admin, _ := claims["admin"].(bool)
+	return admin
+}
+
+// RequireAdmin returns middleware which only calls next for requests holding a
+// valid token from an admin grant. Requests without a valid token are rejected
+// with 401 Unauthorized, and those from standard grants with 403 Forbidden.
+func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return std.RequireAdmin(next)
+}
+
+// RequireAdmin is the package RequireAdmin, verifying tokens with s's Signer
+func (s *Service) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return s.RequireClaim("admin", ClaimEquals(true))(next)
+}
@@ -0,0 +1,255 @@
+// Package samlaccess exchanges SAML assertions from an identity provider for
+// access tokens, for organizations which can only provision API users through
+// SAML single sign-on.
+//
+// An SSO serves the service provider's metadata, starts logins by redirecting
+// to the identity provider, and consumes the assertions it posts back. The
+// subject of a verified assertion is mapped to a local grant, created without
+// a password with access.ProvisionGrant the first time it is seen, and a token
+// is issued for it with access.IssueToken, recording "saml" in its "amr" claim.
+package samlaccess
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+
+	"github.com/nilslice/access"
+)
+
+// requestCookie holds the ID of the authentication request a login is waiting
+// on, so the assertion answering it can be matched to it
+const requestCookie = "saml_request"
+
+// SSO exchanges the assertions of the identity provider described in
+// SP.IDPMetadata for tokens
+type SSO struct {
+	SP *saml.ServiceProvider
+
+	// Config issues tokens, its TenantID namespacing the grants of the
+	// identity provider's subjects. Its ResponseWriter and Request are set for
+	// each login.
+	Config *access.Config
+
+	// KeyAttribute, if set, names the assertion attribute whose value is the
+	// key of the subject's grant, such as "email". Otherwise the key is the
+	// assertion's NameID.
+	KeyAttribute string
+
+	// Roles and Scopes are given to the grants created on first login
+	Roles  []string
+	Scopes []string
+
+	// RequireGrant, if true, only issues tokens to subjects whose grant
+	// already exists, instead of creating one on first login
+	RequireGrant bool
+}
+
+// New returns an SSO for sp, issuing tokens as cfg describes
+func New(sp *saml.ServiceProvider, cfg *access.Config) *SSO {
+	return &SSO{SP: sp, Config: cfg}
+}
+
+// Handler returns a handler to mount at prefix, such as "/saml", serving:
+//
+//	prefix/metadata   the service provider's metadata, to register with the
+//	                  identity provider
+//	prefix/login      a redirect to the identity provider to log in, with the
+//	                  "next" query parameter as where to return afterwards
+//	prefix/acs        the assertion consumer service, which SP.AcsURL must
+//	                  point to
+//
+// After a login, the assertion consumer service redirects to the RelayState
+// the identity provider sent back if it is a path on this host, or otherwise
+// responds 204 No Content, with the token written as Config describes.
+func (s *SSO) Handler(prefix string) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(prefix+"/metadata", s.serveMetadata)
+	mux.HandleFunc(prefix+"/login", s.serveLogin)
+	mux.HandleFunc(prefix+"/acs", s.serveACS)
+	return mux
+}
+
+func (s *SSO) serveMetadata(res http.ResponseWriter, req *http.Request) {
+	j, err := xml.MarshalIndent(s.SP.Metadata(), "", "  ")
+	if err != nil {
+		http.Error(res, "failed to encode metadata", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/samlmetadata+xml")
+	res.Write(j)
+}
+
+func (s *SSO) serveLogin(res http.ResponseWriter, req *http.Request) {
+	authn, err := s.SP.MakeAuthenticationRequest(
+		s.SP.GetSSOBindingLocation(saml.HTTPRedirectBinding),
+		saml.HTTPRedirectBinding,
+		saml.HTTPPostBinding,
+	)
+	if err != nil {
+		http.Error(res, "failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	redirect, err := authn.Redirect(localPath(req.URL.Query().Get("next")), s.SP)
+	if err != nil {
+		http.Error(res, "failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	// the identity provider posts the assertion back from another site, so
+	// the cookie must be sent with cross-site requests
+	http.SetCookie(res, &http.Cookie{
+		Name:     requestCookie,
+		Value:    authn.ID,
+		Path:     s.cookiePath(),
+		MaxAge:   int(saml.MaxIssueDelay / time.Second),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+	})
+
+	http.Redirect(res, req, redirect.String(), http.StatusFound)
+}
+
+func (s *SSO) serveACS(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestIDs []string
+	if c, err := req.Cookie(requestCookie); err == nil && c.Value != "" {
+		requestIDs = append(requestIDs, c.Value)
+	}
+
+	http.SetCookie(res, &http.Cookie{
+		Name:     requestCookie,
+		Path:     s.cookiePath(),
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+	})
+
+	_, err := s.Login(res, req, requestIDs)
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		switch {
+		case errors.As(err, &invalid), errors.Is(err, access.ErrUnauthorized),
+			errors.Is(err, access.ErrDisabled), errors.Is(err, access.ErrGrantExpired):
+			http.Error(res, "authentication failed", http.StatusForbidden)
+
+		case errors.Is(err, access.ErrLocked):
+			http.Error(res, err.Error(), http.StatusTooManyRequests)
+
+		default:
+			http.Error(res, "failed to log in", http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if next := localPath(req.PostFormValue("RelayState")); next != "" {
+		http.Redirect(res, req, next, http.StatusSeeOther)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// Login verifies the SAML response posted in req, answering one of the
+// authentication requests with IDs requestIDs unless SP.AllowIDPInitiated is
+// set, and issues a token for the grant of its subject, written to res as
+// Config describes
+func (s *SSO) Login(res http.ResponseWriter, req *http.Request, requestIDs []string) (*access.APIAccess, error) {
+	err := req.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+
+	assertion, err := s.SP.ParseResponse(req, requestIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := s.keyOf(assertion)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := *s.Config
+	cfg.ResponseWriter = res
+	cfg.Request = req
+
+	if !s.RequireGrant {
+		_, err = access.ProvisionGrant(access.GrantRequest{
+			Key:    key,
+			Tenant: cfg.TenantID,
+			Roles:  s.Roles,
+			Scopes: s.Scopes,
+			Metadata: map[string]string{
+				"saml_issuer": assertion.Issuer.Value,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return access.IssueToken(key, &cfg, "saml")
+}
+
+// keyOf returns the grant key of the subject of assertion
+func (s *SSO) keyOf(assertion *saml.Assertion) (string, error) {
+	if s.KeyAttribute == "" {
+		if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+			return "", fmt.Errorf("%s", "assertion has no NameID")
+		}
+
+		return assertion.Subject.NameID.Value, nil
+	}
+
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			if attr.Name != s.KeyAttribute && attr.FriendlyName != s.KeyAttribute {
+				continue
+			}
+
+			for _, v := range attr.Values {
+				if v.Value != "" {
+					return v.Value, nil
+				}
+			}
+		}
+	}
+
+	return "", fmt.Errorf("assertion has no %s attribute", s.KeyAttribute)
+}
+
+// cookiePath scopes the request cookie to the assertion consumer service
+func (s *SSO) cookiePath() string {
+	if s.SP.AcsURL.Path == "" {
+		return "/"
+	}
+
+	return s.SP.AcsURL.Path
+}
+
+// localPath returns next if it is a path on this host, so logins can't be
+// used to redirect to other sites
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return ""
+	}
+
+	return next
+}
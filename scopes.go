@@ -0,0 +1,31 @@
+package access
+
+import "net/http"
+
+// HasScope validates the access token held within the provided tokenStore and
+// checks whether it was issued with scope
+func HasScope(req *http.Request, tokenStore reqHeaderOrHTTPCookie, scope string) bool {
+	return std.HasScope(req, tokenStore, scope)
+}
+
+// HasScope is the package HasScope, verifying tokens with s's Signer
+func (s *Service) HasScope(req *http.Request, tokenStore reqHeaderOrHTTPCookie, scope string) bool {
+	claims, ok := s.grantedClaims(req, tokenStore)
+	if !ok {
+		return false
+	}
+
+	return containsString(claimStrings(claims, "scopes"), scope)
+}
+
+// RequireScope returns middleware which only calls next for requests holding a
+// valid token issued with scope. Requests without a valid token are rejected
+// with 401 Unauthorized, and those missing the scope with 403 Forbidden.
+func RequireScope(scope string) func(next http.HandlerFunc) http.HandlerFunc {
+	return std.RequireScope(scope)
+}
+
+// RequireScope is the package RequireScope, verifying tokens with s's Signer
+func (s *Service) RequireScope(scope string) func(next http.HandlerFunc) http.HandlerFunc {
+	return s.RequireClaim("scopes", ClaimContains(scope))
+}
@@ -0,0 +1,86 @@
+package access
+
+import "net/http"
+
+// Service is an access domain: the grants held in its Store, the tokens its
+// Signer issues and verifies, and the default Config used by its GateKeeper,
+// along with its hooks, policies, password rules, Hasher, Mailer and trusted
+// issuers. Services with different Stores and Signers can run side by side in
+// one process, or be created per test. The package-level functions act on a
+// default Service configured by UseStore, UseSigner, UseLogger and the other
+// package-level setters.
+//
+// Lockout, rate limits, peppers, encryption, the role hierarchy, trusted
+// proxies and the TTLs of tokens sent to users are shared by every Service.
+type Service struct {
+	store  Store
+	signer Signer
+	logger Logger
+	config Config
+	cache  *tokenCache
+	strict *activeGrants
+	seen   *seenWrites
+
+	sessionStates *sessionStates
+
+	hooks           Hooks
+	policy          Policy
+	passwordPolicy  PasswordPolicy
+	passwordChecker PasswordChecker
+	mailer          Mailer
+	hashers         *hasherSet
+	issuers         *issuerSet
+}
+
+// std is the default Service used by the package-level functions
+var std = &Service{
+	store:   newBoltStore(),
+	signer:  jwtSigner{},
+	logger:  stdLogger{},
+	seen:    newSeenWrites(lastSeenSize),
+	hashers: newHasherSet(),
+	issuers: newIssuerSet(),
+}
+
+// NewService returns a Service keeping grants in store, issuing tokens with
+// signer and logging to logger. A nil store uses Ponzu's bolt database, as the
+// default Service does, a nil signer uses the package default, a nil logger
+// silences the Service, and defaults, if not nil, is the Config its
+// GateKeeper uses and Config copies.
+func NewService(store Store, signer Signer, logger Logger, defaults *Config) *Service {
+	if store == nil {
+		store = newBoltStore()
+	}
+
+	if signer == nil {
+		signer = jwtSigner{}
+	}
+
+	if logger == nil {
+		logger = discardLogger{}
+	}
+
+	s := &Service{
+		store:   store,
+		signer:  signer,
+		logger:  logger,
+		seen:    newSeenWrites(lastSeenSize),
+		hashers: newHasherSet(),
+		issuers: newIssuerSet(),
+	}
+
+	if defaults != nil {
+		s.config = *defaults
+	}
+
+	return s
+}
+
+// Config returns a copy of the Service's default Config for a request being
+// handled, writing tokens to res, to pass to Grant, Login and IssueToken
+func (s *Service) Config(res http.ResponseWriter, req *http.Request) *Config {
+	cfg := s.config
+	cfg.ResponseWriter = res
+	cfg.Request = req
+	return &cfg
+}
@@ -0,0 +1,126 @@
+package access_test
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+func TestServiceIsolation(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	newService := func() *access.Service {
+		s := access.NewService(access.NewMemoryStore(), nil, nil, headerConfig(""))
+		s.UseHasher(access.BcryptHasher{Cost: bcrypt.MinCost})
+		return s
+	}
+
+	partners, internal := newService(), newService()
+
+	var granted []string
+	partners.UseHooks(access.Hooks{OnGrant: func(key string) {
+		granted = append(granted, key)
+	}})
+	partners.UsePasswordPolicy(access.PasswordPolicy{MinLength: len(accesstest.Password) + 1})
+
+	_, err := partners.Grant("svc@example.com", accesstest.Password, headerConfig(""))
+	if err == nil {
+		t.Fatal("partners' password policy wasn't applied")
+	}
+
+	a, err := internal.Grant("svc@example.com", accesstest.Password, headerConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(granted) != 0 {
+		t.Errorf("partners' OnGrant hook was called for internal's grant: %v", granted)
+	}
+
+	err = internal.SetACL("report-1", "svc@example.com", "read")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, s := range map[string]*access.Service{"partners": partners, "default": nil} {
+		getGrant, getACL, listSessions := access.GetGrant, access.GetACL, access.ListSessions
+		if s != nil {
+			getGrant, getACL, listSessions = s.GetGrant, s.GetACL, s.ListSessions
+		}
+
+		_, err = getGrant("svc@example.com")
+		if !errors.Is(err, access.ErrNotFound) {
+			t.Errorf("%s GetGrant: got %v, want ErrNotFound", name, err)
+		}
+
+		acl, err := getACL("report-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(acl) != 0 {
+			t.Errorf("%s GetACL holds internal's entries: %v", name, acl)
+		}
+
+		sessions, err := listSessions("svc@example.com")
+		if err != nil && !errors.Is(err, access.ErrNotFound) {
+			t.Fatal(err)
+		}
+
+		if len(sessions) != 0 {
+			t.Errorf("%s ListSessions holds internal's sessions: %v", name, sessions)
+		}
+	}
+
+	req := bearer(http.MethodGet, a.Token)
+	if !internal.IsGranted(req, http.Header{}) {
+		t.Error("internal rejected its own token")
+	}
+
+	if partners.IsGranted(req, http.Header{}) {
+		t.Error("partners accepted a token for internal's grant")
+	}
+}
+
+// TestSettingsWhileServing is meant to be run with -race
+func TestSettingsWhileServing(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+	token := accesstest.Token(t, "settings@example.com")
+
+	t.Cleanup(func() {
+		access.SetLockout(0, 0)
+		access.SetAttemptLimit(0, 0)
+		access.SetTrustedProxies()
+		access.SetLastSeenInterval(0)
+		access.SetPeppers(nil)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				access.Login("settings@example.com", accesstest.Password, headerConfig(""))
+				access.IsGranted(bearer(http.MethodGet, token), http.Header{})
+			}
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		access.SetLockout(100, time.Minute)
+		access.SetAttemptLimit(100, time.Minute)
+		access.SetTrustedProxies("10.0.0.0/8")
+		access.SetLastSeenInterval(time.Minute)
+		access.SetPeppers(map[int]string{1: "pepper"})
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,368 @@
+package access
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ServiceAccountAssertionType is the client_assertion_type of OAuth 2.0 token
+// requests authenticating a service account with a signed assertion, as
+// described by RFC 7523
+const ServiceAccountAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// maxAssertionAge limits how far ahead the "exp" claim of an assertion may be,
+// which bounds how long its "jti" is remembered to reject replays
+const maxAssertionAge = 5 * time.Minute
+
+// serviceCredentials is the stored secret hash or public key of a service
+// account, kept in the __apiServiceCredentials bucket apart from the
+// passwords of grants
+type serviceCredentials struct {
+	SecretHash string    `json:"secret_hash,omitempty"`
+	PublicKey  string    `json:"public_key,omitempty"`
+	Created    time.Time `json:"created"`
+}
+
+// CreateServiceAccount creates a grant for a machine client, as gr describes,
+// which has no password and can't log in with one. If publicKeyPEM is nil, a
+// long random secret is generated and returned, which isn't stored and can't
+// be recovered. Otherwise publicKeyPEM is the client's RSA or ECDSA public key,
+// in PKIX PEM form, and the client authenticates with assertions signed by its
+// private key. Tokens for service accounts are issued by ServiceAccountToken,
+// ServiceAccountAssertion and OAuth2TokenHandler, and carry the "svc" claim but
+// never a CSRF token or "amr" claim. Service accounts are meant to be created
+// by operators, through AdminHandler or the access command.
+func CreateServiceAccount(gr GrantRequest, publicKeyPEM []byte) (string, error) {
+	return std.CreateServiceAccount(gr, publicKeyPEM)
+}
+
+// CreateServiceAccount is the package CreateServiceAccount for s's Store
+func (s *Service) CreateServiceAccount(gr GrantRequest, publicKeyPEM []byte) (string, error) {
+	if gr.Key == "" {
+		return "", fmt.Errorf("%s", "key must not be empty")
+	}
+
+	if gr.Password != "" {
+		return "", fmt.Errorf("%s", "service accounts must not have a password")
+	}
+
+	err := validateTenantID(gr.Tenant)
+	if err != nil {
+		return "", err
+	}
+
+	err = validateKey(gr.Key, gr.Tenant)
+	if err != nil {
+		return "", err
+	}
+
+	err = validateTags(gr.Tags)
+	if err != nil {
+		return "", err
+	}
+
+	creds := serviceCredentials{Created: time.Now()}
+
+	var secret string
+	if publicKeyPEM == nil {
+		secret, creds.SecretHash, err = newSecretToken()
+		if err != nil {
+			return "", err
+		}
+	} else {
+		_, err = parsePublicKey(publicKeyPEM)
+		if err != nil {
+			return "", err
+		}
+
+		creds.PublicKey = string(publicKeyPEM)
+	}
+
+	j, err := json.Marshal(creds)
+	if err != nil {
+		return "", err
+	}
+
+	storeKey := TenantKey(gr.Tenant, gr.Key)
+	err = s.store.Update(func(tx Tx) error {
+		active, err := tx.Get(apiAccessStore, storeKey)
+		if err != nil {
+			return err
+		}
+
+		if active != nil {
+			return ErrDuplicateKey
+		}
+
+		pending, err := tx.Get(apiPendingUserStore, storeKey)
+		if err != nil {
+			return err
+		}
+
+		if pending != nil && !isStalePending(pending) {
+			return fmt.Errorf("Pending: %w", ErrPending)
+		}
+
+		err = putGrant(tx, &APIAccess{
+			Key:            gr.Key,
+			Tenant:         gr.Tenant,
+			Roles:          gr.Roles,
+			Scopes:         gr.Scopes,
+			Admin:          gr.Admin,
+			Metadata:       gr.Metadata,
+			Tags:           gr.Tags,
+			ServiceAccount: true,
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.Put(apiServiceCredentialStore, storeKey, j)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	s.grantCreated(storeKey, nil)
+	return secret, nil
+}
+
+// parsePublicKey returns the RSA or ECDSA public key held in PKIX PEM form
+func parsePublicKey(publicKeyPEM []byte) (interface{}, error) {
+	block, _ := pem.Decode(publicKeyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("%s", "public key must be PEM encoded")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key, %v", err)
+	}
+
+	switch pub.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("%s", "public key must be an RSA or ECDSA key")
+	}
+}
+
+// ServiceAccountToken issues a token for the service account for key, which
+// authenticates with the secret returned by CreateServiceAccount. cfg's
+// TokenStore must be an http.Header or QueryParam, as service accounts don't
+// hold cookies.
+func ServiceAccountToken(key, secret string, cfg *Config) (*APIAccess, error) {
+	return std.ServiceAccountToken(key, secret, cfg)
+}
+
+// ServiceAccountToken is the package ServiceAccountToken for the grants in s's
+// Store
+func (s *Service) ServiceAccountToken(key, secret string, cfg *Config) (*APIAccess, error) {
+	if key == "" || secret == "" {
+		return nil, fmt.Errorf("%s", "key and secret must not be empty")
+	}
+
+	return s.serviceAccountToken(key, cfg, func(tx Tx, storeKey string, creds serviceCredentials) (bool, error) {
+		return creds.SecretHash != "" && sameKey(hashSecret(secret), creds.SecretHash), nil
+	})
+}
+
+// ServiceAccountAssertion issues a token for the service account which signed
+// assertion, a JWT as described by RFC 7523 signed with the private key of the
+// public key given to CreateServiceAccount using RS256/384/512 or
+// ES256/384/512. Its "iss" and "sub" claims must be the key of the account,
+// and its "aud" claim must hold audience, such as the URL of the token
+// endpoint. Its "exp" claim may be at most 5 minutes ahead, and its "jti" claim
+// is remembered until then so the assertion can't be replayed. cfg's
+// TokenStore must be an http.Header or QueryParam.
+func ServiceAccountAssertion(assertion, audience string, cfg *Config) (*APIAccess, error) {
+	return std.ServiceAccountAssertion(assertion, audience, cfg)
+}
+
+// ServiceAccountAssertion is the package ServiceAccountAssertion for the grants
+// in s's Store
+func (s *Service) ServiceAccountAssertion(assertion, audience string, cfg *Config) (*APIAccess, error) {
+	if audience == "" {
+		return nil, fmt.Errorf("%s", "audience must not be empty")
+	}
+
+	alg, _, ok := jwtHeaderOf(assertion)
+	claims := jwtClaims(assertion)
+	if !ok || claims == nil {
+		return nil, ErrUnauthorized
+	}
+
+	key, _ := claims["sub"].(string)
+	iss, _ := claims["iss"].(string)
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	now := time.Now()
+
+	if key == "" || iss != key || jti == "" || !hasAudience(claims, audience) ||
+		now.Add(-clockSkew).Unix() > int64(exp) || now.Add(maxAssertionAge+clockSkew).Unix() < int64(exp) {
+		return nil, ErrUnauthorized
+	}
+
+	return s.serviceAccountToken(key, cfg, func(tx Tx, storeKey string, creds serviceCredentials) (bool, error) {
+		if creds.PublicKey == "" {
+			return false, nil
+		}
+
+		pub, err := parsePublicKey([]byte(creds.PublicKey))
+		if err != nil {
+			return false, err
+		}
+
+		if !verifyJWTSignature(assertion, alg, pub) {
+			return false, nil
+		}
+
+		// assertions are single use, their IDs kept in the nonce bucket
+		// until they expire
+		replay := hashSecret(storeKey + "|" + jti)
+		used, err := tx.Get(apiNonceStore, replay)
+		if err != nil || used != nil {
+			return false, err
+		}
+
+		j, err := json.Marshal(nonceRecord{
+			Key:     storeKey,
+			Expires: time.Unix(int64(exp), 0),
+		})
+		if err != nil {
+			return false, err
+		}
+
+		return true, tx.Put(apiNonceStore, replay, j)
+	})
+}
+
+// serviceAccountToken issues a token for the service account for key if verify
+// accepts its credentials, subject to the attempt limit and lockout as logins
+// are
+func (s *Service) serviceAccountToken(key string, cfg *Config, verify func(tx Tx, storeKey string, creds serviceCredentials) (bool, error)) (*APIAccess, error) {
+	if _, ok := cfg.TokenStore.(http.Cookie); ok {
+		return nil, fmt.Errorf("%s", "service account tokens must not be stored in cookies")
+	}
+
+	err := validateTenantID(cfg.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	storeKey := TenantKey(cfg.TenantID, key)
+	err = s.takeAttempt(cfg, storeKey)
+	if err != nil {
+		s.loginFailed(storeKey, cfg.Request, err)
+		return nil, err
+	}
+
+	var apiAccess *APIAccess
+	var exp time.Time
+	var failed bool
+	err = s.store.Update(func(tx Tx) error {
+		existing, err := tx.Get(apiAccessStore, storeKey)
+		if err != nil {
+			return err
+		}
+
+		if existing == nil {
+			return ErrUnauthorized
+		}
+
+		// credentials are checked before whether the grant is inactive, so
+		// its status isn't revealed to callers without them
+		var verifyErr error
+		a, err := verifiedGrant(tx, storeKey, func(a *APIAccess) bool {
+			if !a.ServiceAccount {
+				return false
+			}
+
+			creds, err := getServiceCredentials(tx, storeKey)
+			if err != nil {
+				verifyErr = err
+				return false
+			}
+
+			ok, err := verify(tx, storeKey, creds)
+			if err != nil {
+				verifyErr = err
+				return false
+			}
+
+			return ok
+		})
+		if verifyErr != nil {
+			return verifyErr
+		}
+		if err != nil {
+			failed = err == ErrUnauthorized
+			return err
+		}
+
+		a.Groups, err = groupsOf(tx, storeKey)
+		if err != nil {
+			return err
+		}
+
+		a.ver, err = tokenVersion(tx, storeKey)
+		if err != nil {
+			return err
+		}
+
+		exp, err = a.newToken(s.signer, cfg)
+		if err != nil {
+			return err
+		}
+
+		err = startSession(tx, storeKey, a, cfg, exp)
+		if err != nil {
+			return err
+		}
+
+		a.FailedLogins = 0
+		a.LastLoginAt = time.Now()
+		apiAccess = a
+		return putGrant(tx, a)
+	})
+
+	if failed {
+		s.recordLoginFailure(storeKey)
+	}
+
+	if err != nil {
+		s.loginFailed(storeKey, cfg.Request, err)
+		return nil, err
+	}
+
+	s.sessionStates.forget(storeKey)
+
+	err = apiAccess.writeToken(cfg, exp)
+	if err != nil {
+		return nil, err
+	}
+
+	s.loggedIn(storeKey, cfg.Request, nil)
+	return apiAccess, nil
+}
+
+func getServiceCredentials(tx Tx, key string) (serviceCredentials, error) {
+	var creds serviceCredentials
+	j, err := tx.Get(apiServiceCredentialStore, key)
+	if err != nil || j == nil {
+		return creds, err
+	}
+
+	err = json.Unmarshal(j, &creds)
+	if err != nil {
+		return creds, fmt.Errorf("failed to decode credentials for %s, %v", key, err)
+	}
+
+	return creds, nil
+}
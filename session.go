@@ -0,0 +1,235 @@
+package access
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Session is a token issued for a grant, identified by its "jti" claim, which
+// is accepted until it expires or is revoked
+type Session struct {
+	ID        string    `json:"jti"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// SessionLimitAction is what happens when issuing a token would exceed
+// Config.MaxSessions
+type SessionLimitAction int
+
+const (
+	// EvictOldestSession revokes the grant's oldest sessions to make room for
+	// the new one
+	EvictOldestSession SessionLimitAction = iota
+
+	// RejectNewSession fails the login with ErrSessionLimit
+	RejectNewSession
+)
+
+// ErrSessionLimit is returned when issuing a token would exceed
+// Config.MaxSessions and Config.SessionLimit is RejectNewSession
+var ErrSessionLimit = errors.New("too many active sessions")
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate session ID, %v", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// sessions returns the unexpired sessions of the grant for key, oldest first,
+// stored in the __apiSessions bucket
+func sessions(tx Tx, key string) ([]Session, error) {
+	j, err := tx.Get(apiSessionStore, key)
+	if err != nil || j == nil {
+		return nil, err
+	}
+
+	var all []Session
+	err = json.Unmarshal(j, &all)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode sessions for %s, %v", key, err)
+	}
+
+	now := time.Now()
+	active := all[:0]
+	for _, sess := range all {
+		if sess.ExpiresAt.After(now) {
+			active = append(active, sess)
+		}
+	}
+
+	sort.SliceStable(active, func(i, j int) bool {
+		return active[i].IssuedAt.Before(active[j].IssuedAt)
+	})
+
+	return active, nil
+}
+
+func putSessions(tx Tx, key string, active []Session) error {
+	if len(active) == 0 {
+		return tx.Delete(apiSessionStore, key)
+	}
+
+	j, err := json.Marshal(active)
+	if err != nil {
+		return fmt.Errorf("failed to encode sessions for %s, %v", key, err)
+	}
+
+	return tx.Put(apiSessionStore, key, j)
+}
+
+// startSession records the token just issued to the grant for key by newToken,
+// enforcing cfg.MaxSessions
+func startSession(tx Tx, key string, a *APIAccess, cfg *Config, exp time.Time) error {
+	active, err := sessions(tx, key)
+	if err != nil {
+		return err
+	}
+
+	if cfg.MaxSessions > 0 && len(active) >= cfg.MaxSessions {
+		if cfg.SessionLimit == RejectNewSession {
+			return ErrSessionLimit
+		}
+
+		active = active[len(active)-cfg.MaxSessions+1:]
+	}
+
+	sess := Session{
+		ID:        a.jti,
+		IssuedAt:  time.Now(),
+		ExpiresAt: exp,
+		IP:        requestIP(cfg.Request),
+	}
+
+	if cfg.Request != nil {
+		sess.UserAgent = cfg.Request.UserAgent()
+	}
+
+	return putSessions(tx, key, append(active, sess))
+}
+
+// sessionExpiries are the IDs and expiry of sessions, decoded without the
+// rest of each Session
+type sessionExpiries []struct {
+	ID        string    `json:"jti"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// has reports whether id is a session unexpired at now
+func (active sessionExpiries) has(id string, now time.Time) bool {
+	for _, sess := range active {
+		if sameKey(sess.ID, id) && sess.ExpiresAt.After(now) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ListSessions returns the unexpired sessions of the grant for key, oldest
+// first, such as to show its owner where they are logged in. For tenant grants,
+// key is the namespaced key returned by TenantKey.
+func ListSessions(key string) ([]Session, error) {
+	return std.ListSessions(key)
+}
+
+// ListSessions is the package ListSessions for s's Store
+func (s *Service) ListSessions(key string) ([]Session, error) {
+	if key == "" {
+		return nil, fmt.Errorf("%s", "key must not be empty")
+	}
+
+	active := []Session{}
+	err := s.store.View(func(tx Tx) error {
+		a, _, err := getGrant(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if a == nil {
+			return notFound(key)
+		}
+
+		s, err := sessions(tx, key)
+		if err != nil {
+			return err
+		}
+
+		active = append(active, s...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return active, nil
+}
+
+// RevokeSession revokes the session of the grant for key with ID jti, so its
+// token is rejected, such as to log out another device. The grant's other
+// sessions are kept. Revoking an unknown or expired session is not an error.
+func RevokeSession(key, jti string) error {
+	return std.RevokeSession(key, jti)
+}
+
+// RevokeSession is the package RevokeSession for s's Store
+func (s *Service) RevokeSession(key, jti string) error {
+	if key == "" || jti == "" {
+		return fmt.Errorf("%s", "key and jti must not be empty")
+	}
+
+	defer s.sessionStates.forget(key)
+	return s.store.Update(func(tx Tx) error {
+		active, err := sessions(tx, key)
+		if err != nil {
+			return err
+		}
+
+		kept := active[:0]
+		for _, sess := range active {
+			if !sameKey(sess.ID, jti) {
+				kept = append(kept, sess)
+			}
+		}
+
+		return putSessions(tx, key, kept)
+	})
+}
+
+// RevokeAllSessions revokes every token issued for the grant for key, as
+// changing its password does, without changing the grant
+func RevokeAllSessions(key string) error {
+	return std.RevokeAllSessions(key)
+}
+
+// RevokeAllSessions is the package RevokeAllSessions for s's Store
+func (s *Service) RevokeAllSessions(key string) error {
+	if key == "" {
+		return fmt.Errorf("%s", "key must not be empty")
+	}
+
+	defer s.sessionStates.forget(key)
+	return s.store.Update(func(tx Tx) error {
+		a, _, err := getGrant(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if a == nil {
+			return notFound(key)
+		}
+
+		return revokeTokens(tx, key)
+	})
+}
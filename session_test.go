@@ -0,0 +1,117 @@
+package access_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+func TestRevokeSession(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	laptop := accesstest.Token(t, "sessions@example.com")
+	phone := accesstest.Token(t, "sessions@example.com")
+
+	identity, ok := access.VerifyToken(laptop)
+	if !ok || identity.SessionID == "" {
+		t.Fatalf("token has no session: %+v", identity)
+	}
+
+	sessions, err := access.ListSessions("sessions@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listed := false
+	for _, s := range sessions {
+		listed = listed || s.ID == identity.SessionID
+	}
+	if !listed {
+		t.Errorf("session %s not in %v", identity.SessionID, sessions)
+	}
+
+	err = access.RevokeSession("sessions@example.com", identity.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	granted := func(token string) bool {
+		return access.IsGranted(accesstest.Request(http.MethodGet, "/", token), http.Header{})
+	}
+
+	if granted(laptop) {
+		t.Error("token of a revoked session accepted")
+	}
+
+	if !granted(phone) {
+		t.Fatal("revoking one session revoked another")
+	}
+
+	err = access.RevokeAllSessions("sessions@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	access.GateKeeper(func(res http.ResponseWriter, req *http.Request) {}).
+		ServeHTTP(res, accesstest.Request(http.MethodGet, "/", phone))
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("GateKeeper after RevokeAllSessions: got status %d, want %d", res.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestSessionCache(t *testing.T) {
+	store := accesstest.UseMemoryStore(t)
+	access.SetSessionCache(time.Hour)
+	t.Cleanup(func() { access.SetSessionCache(0) })
+
+	granted := func(token string) bool {
+		return access.IsGranted(accesstest.Request(http.MethodGet, "/", token), http.Header{})
+	}
+
+	laptop := accesstest.Token(t, "cached@example.com")
+	if !granted(laptop) {
+		t.Fatal("token rejected")
+	}
+
+	// as another server sharing the store would, without dropping the state
+	// this server has cached
+	err := store.Update(func(tx access.Tx) error {
+		return tx.Delete("__apiSessions", "cached@example.com")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !granted(laptop) {
+		t.Fatal("sessions weren't cached")
+	}
+
+	access.InvalidateTokens("cached@example.com")
+	if granted(laptop) {
+		t.Error("token of a removed session accepted after InvalidateTokens")
+	}
+
+	phone := accesstest.Token(t, "cached@example.com")
+	if !granted(phone) {
+		t.Fatal("token of a new session rejected")
+	}
+
+	identity, ok := access.VerifyToken(phone)
+	if !ok {
+		t.Fatal("token rejected")
+	}
+
+	err = access.RevokeSession("cached@example.com", identity.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if granted(phone) {
+		t.Error("token of a revoked session accepted while cached")
+	}
+}
@@ -0,0 +1,174 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SetSessionCache keeps the token version and sessions of each grant read by
+// IsGranted, GateKeeper and the other checks for ttl, so the tokens of a busy
+// grant cost at most one Store read per ttl instead of one per check. Tokens
+// whose session or version isn't found in the cached state read the Store
+// again, so new logins are accepted at once. Revocations made through the
+// Service are seen at once too, but grants revoked by other servers sharing
+// the Store keep their tokens working for up to ttl unless InvalidateTokens
+// is called, such as from etcdstore's WatchRevocations. A ttl of 0, the
+// default, reads the Store on every check.
+func SetSessionCache(ttl time.Duration) {
+	std.SetSessionCache(ttl)
+}
+
+// SetSessionCache is the package SetSessionCache for the tokens s verifies. It
+// must be called before s handles requests.
+func (s *Service) SetSessionCache(ttl time.Duration) {
+	if ttl <= 0 {
+		s.sessionStates = nil
+		return
+	}
+
+	s.sessionStates = &sessionStates{
+		ttl:    ttl,
+		states: make(map[string]sessionState),
+	}
+}
+
+// sessionState is the token version and sessions of a grant, as read from the
+// Store
+type sessionState struct {
+	version  int
+	sessions sessionExpiries
+	until    time.Time
+}
+
+// readSessionState reads the token version and sessions of the grant for key.
+// Only the IDs and expiry of sessions are decoded, as this runs on every
+// request.
+func readSessionState(tx Tx, key string) (sessionState, error) {
+	var state sessionState
+	var err error
+	state.version, err = tokenVersion(tx, key)
+	if err != nil {
+		return state, err
+	}
+
+	j, err := tx.Get(apiSessionStore, key)
+	if err != nil || j == nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(j, &state.sessions)
+	if err != nil {
+		return state, fmt.Errorf("failed to decode sessions for %s, %v", key, err)
+	}
+
+	return state, nil
+}
+
+// current reports whether claims hold the grant's token version and name an
+// unexpired session of it, as do all of the sessions in their "chain" for
+// delegated tokens. Tokens issued without a "jti" claim predate session
+// tracking and are only checked by version.
+func (state sessionState) current(claims map[string]interface{}, now time.Time) bool {
+	var got int
+	switch v := claims["ver"].(type) {
+	case float64:
+		got = int(v)
+	case int:
+		got = v
+	}
+
+	if got != state.version {
+		return false
+	}
+
+	jti, ok := claims["jti"].(string)
+	if !ok {
+		return true
+	}
+
+	if !state.sessions.has(jti, now) {
+		return false
+	}
+
+	switch chain := claims["chain"].(type) {
+	case []interface{}:
+		for _, id := range chain {
+			id, _ := id.(string)
+			if !state.sessions.has(id, now) {
+				return false
+			}
+		}
+
+	case []string:
+		for _, id := range chain {
+			if !state.sessions.has(id, now) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// sessionStates remembers the session state of grants until it was read ttl
+// ago. Its methods are safe to call on a nil *sessionStates, which remembers
+// nothing.
+type sessionStates struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	states    map[string]sessionState
+	nextSweep time.Time
+}
+
+func (c *sessionStates) get(key string, now time.Time) (sessionState, bool) {
+	if c == nil {
+		return sessionState{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, ok := c.states[key]
+	if !ok || !now.Before(state.until) {
+		return sessionState{}, false
+	}
+
+	return state, true
+}
+
+func (c *sessionStates) put(key string, state sessionState, now time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state.until = now.Add(c.ttl)
+	c.states[key] = state
+
+	// forget grants no longer seen, at most once per ttl
+	if now.After(c.nextSweep) {
+		for k, s := range c.states {
+			if !now.Before(s.until) {
+				delete(c.states, k)
+			}
+		}
+
+		c.nextSweep = now.Add(c.ttl)
+	}
+}
+
+func (c *sessionStates) forget(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.states, key)
+}
@@ -0,0 +1,121 @@
+package access_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+func TestSignedRequestBodyLimit(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+	accesstest.Token(t, "webhook@example.com")
+
+	secret, err := access.CreateSigningSecret("webhook@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	access.SetSignatureBodyLimit(16)
+	t.Cleanup(func() { access.SetSignatureBodyLimit(1 << 20) })
+
+	for body, want := range map[string]bool{
+		"small":                       true,
+		strings.Repeat("large", 1024): false,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body))
+		err = access.SignRequest(req, "webhook@example.com", secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// servers don't see the length of a chunked body up front
+		req.ContentLength = -1
+		if got := access.IsGranted(req, http.Header{}); got != want {
+			t.Errorf("%d byte body: granted %v, want %v", len(body), got, want)
+		}
+	}
+}
+
+func TestSignedRequest(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+	accesstest.Token(t, "webhook@example.com")
+
+	secret, err := access.CreateSigningSecret("webhook@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := func(secret string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/hook?id=1", strings.NewReader(`{"event":"paid"}`))
+		err := access.SignRequest(req, "webhook@example.com", secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return req
+	}
+
+	req := signed(secret)
+	identity, err := access.CheckRequest(req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if identity.Key != "webhook@example.com" || identity.Source != access.SignatureHeader {
+		t.Errorf("got identity %+v, want the signing grant", identity)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil || string(body) != `{"event":"paid"}` {
+		t.Errorf("handler read body %q, %v", body, err)
+	}
+
+	for name, tamper := range map[string]func(req *http.Request){
+		"body": func(req *http.Request) {
+			req.Body = io.NopCloser(strings.NewReader(`{"event":"refunded"}`))
+		},
+		"query":  func(req *http.Request) { req.URL.RawQuery = "id=2" },
+		"method": func(req *http.Request) { req.Method = http.MethodPut },
+		"key": func(req *http.Request) {
+			req.Header.Set(access.SignatureKeyHeader, "other@example.com")
+		},
+	} {
+		req := signed(secret)
+		tamper(req)
+		if access.IsGranted(req, http.Header{}) {
+			t.Errorf("request with a changed %s accepted", name)
+		}
+	}
+
+	if access.IsGranted(signed("not the secret"), http.Header{}) {
+		t.Error("request signed with the wrong secret accepted")
+	}
+
+	err = access.Disable("webhook@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if access.IsGranted(signed(secret), http.Header{}) {
+		t.Error("request signed for a disabled grant accepted")
+	}
+
+	err = access.Enable("webhook@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = access.ClearSigningSecret("webhook@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if access.IsGranted(signed(secret), http.Header{}) {
+		t.Error("request signed with a cleared secret accepted")
+	}
+}
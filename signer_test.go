@@ -0,0 +1,58 @@
+package access_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+func TestConfigSigner(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	access.SetTokenCache(100)
+	t.Cleanup(func() { access.SetTokenCache(0) })
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paseto := headerConfig("")
+	paseto.Signer = access.NewPASETOSigner(key)
+
+	a, err := access.Grant("paseto@example.com", accesstest.Password, paseto)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(a.Token, "v4.public.") {
+		t.Fatalf("got token %q, want a PASETO token", a.Token)
+	}
+
+	if !access.IsGranted(bearer(http.MethodGet, a.Token), paseto) {
+		t.Error("token rejected through the Config which issued it")
+	}
+
+	if access.IsGranted(bearer(http.MethodGet, a.Token), headerConfig("")) {
+		t.Error("PASETO token accepted by the default Signer")
+	}
+
+	jwt := accesstest.Token(t, "jwt@example.com")
+	if !access.IsGranted(bearer(http.MethodGet, jwt), http.Header{}) {
+		t.Fatal("token rejected by the default Signer")
+	}
+
+	if access.IsGranted(bearer(http.MethodGet, jwt), paseto) {
+		t.Error("cached token accepted through a Config with another Signer")
+	}
+
+	_, err = access.CheckRequest(bearer(http.MethodGet, a.Token), paseto)
+	if err != nil {
+		t.Errorf("CheckRequest rejected the token: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package access
+
+import (
+	"bytes"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// MailTemplate is the subject and body of a message sent by SMTPMailer, each
+// a text/template rendered with the data passed to Send, such as {{.link}}
+type MailTemplate struct {
+	Subject string
+	Body    string
+}
+
+// DefaultMailTemplates are the messages SMTPMailer sends for templates it has
+// no MailTemplate of its own for
+var DefaultMailTemplates = map[string]MailTemplate{
+	MailVerify: {
+		Subject: "Verify your email address",
+		Body:    "Verify your email address to finish signing up:\n\n{{.link}}\n",
+	},
+	MailInvite: {
+		Subject: "You have been invited",
+		Body:    "You have been invited to create an account. Accept the invitation and choose a password:\n\n{{.link}}\n",
+	},
+	MailPasswordReset: {
+		Subject: "Reset your password",
+		Body:    "Reset your password:\n\n{{.link}}\n\nIf you didn't ask to reset your password, you can ignore this message.\n",
+	},
+}
+
+// SMTPMailer is a Mailer sending plain text messages through an SMTP server
+type SMTPMailer struct {
+	// Addr is the host:port of the server, which must support STARTTLS for
+	// Auth to be used
+	Addr string
+	Auth smtp.Auth
+	From string
+
+	// Templates, if set, replace DefaultMailTemplates by template name
+	Templates map[string]MailTemplate
+}
+
+// NewSMTPMailer returns an SMTPMailer sending messages from from through the
+// server at addr, authenticating with auth if it isn't nil, such as
+// smtp.PlainAuth("", user, password, host)
+func NewSMTPMailer(addr string, auth smtp.Auth, from string) *SMTPMailer {
+	return &SMTPMailer{
+		Addr: addr,
+		Auth: auth,
+		From: from,
+	}
+}
+
+// Send renders the MailTemplate for name with data and sends it to to
+func (m *SMTPMailer) Send(to, name string, data map[string]string) error {
+	tmpl, ok := m.Templates[name]
+	if !ok {
+		tmpl, ok = DefaultMailTemplates[name]
+	}
+	if !ok {
+		return fmt.Errorf("no mail template %s", name)
+	}
+
+	subject, err := renderMail(tmpl.Subject, data)
+	if err != nil {
+		return fmt.Errorf("failed to render subject of %s, %v", name, err)
+	}
+
+	body, err := renderMail(tmpl.Body, data)
+	if err != nil {
+		return fmt.Errorf("failed to render body of %s, %v", name, err)
+	}
+
+	for _, header := range []string{m.From, to, subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return fmt.Errorf("%s", "mail headers must not contain line breaks")
+		}
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{to}, msg.Bytes())
+}
+
+func renderMail(text string, data map[string]string) (string, error) {
+	t, err := template.New("mail").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	err = t.Execute(&b, data)
+	if err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
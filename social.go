@@ -0,0 +1,327 @@
+package access
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Provider is an OAuth 2.0 provider API consumers can sign in with, such as
+// one returned by GoogleProvider or GitHubProvider
+type Provider struct {
+	// Name is the provider's path segment in SocialLoginHandler, and is
+	// recorded in the "provider" metadata of grants created for it
+	Name string
+
+	ClientID     string
+	ClientSecret string
+
+	// AuthURL and TokenURL are the provider's authorization and token
+	// endpoints
+	AuthURL  string
+	TokenURL string
+
+	// RedirectURL is the callback URL registered with the provider, which
+	// must be served by SocialLoginHandler
+	RedirectURL string
+
+	Scopes []string
+
+	// Email returns the verified email address of the user an access token
+	// was issued for, or an error if it has none
+	Email func(client *http.Client, accessToken string) (string, error)
+
+	// Client makes the token exchange and the requests of Email, and defaults
+	// to a client with a 5 second timeout
+	Client *http.Client
+}
+
+// GoogleProvider returns a Provider for signing in with Google
+func GoogleProvider(clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{
+		Name:         "google",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL:     "https://oauth2.googleapis.com/token",
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email"},
+		Email:        googleEmail,
+	}
+}
+
+// GitHubProvider returns a Provider for signing in with GitHub
+func GitHubProvider(clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{
+		Name:         "github",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"user:email"},
+		Email:        githubEmail,
+	}
+}
+
+func googleEmail(client *http.Client, accessToken string) (string, error) {
+	var info struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+
+	err := getBearerJSON(client, "https://openidconnect.googleapis.com/v1/userinfo", accessToken, &info)
+	if err != nil {
+		return "", err
+	}
+
+	if info.Email == "" || !info.EmailVerified {
+		return "", fmt.Errorf("%s", "Google account has no verified email address")
+	}
+
+	return info.Email, nil
+}
+
+func githubEmail(client *http.Client, accessToken string) (string, error) {
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+
+	err := getBearerJSON(client, "https://api.github.com/user/emails", accessToken, &emails)
+	if err != nil {
+		return "", err
+	}
+
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			return e.Email, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s", "GitHub account has no verified primary email address")
+}
+
+// getBearerJSON decodes the JSON document at url, requested with accessToken,
+// into v
+func getBearerJSON(client *http.Client, url, accessToken string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	return doJSON(client, req, v)
+}
+
+// exchange trades the authorization code for an access token
+func (p *Provider) exchange(code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+
+	err = doJSON(p.Client, req, &token)
+	if err != nil {
+		return "", err
+	}
+
+	// GitHub reports failed exchanges with a 200 OK
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("%s rejected the authorization code, %s", p.Name, token.Error)
+	}
+
+	return token.AccessToken, nil
+}
+
+// socialStateCookie holds the state and PKCE verifier of a sign in, and where
+// to return after it
+const socialStateCookie = "social_state"
+
+// SocialLoginHandler returns a handler to mount at prefix, such as "/auth",
+// signing API consumers in with providers, serving for each:
+//
+//	prefix/{name}/login      a redirect to the provider, with the "next" query
+//	                         parameter as where to return afterwards
+//	prefix/{name}/callback   the provider's RedirectURL
+//
+// The callback exchanges the authorization code, using PKCE, for the user's
+// verified email address, which is the key of their grant. A grant is created
+// without a password the first time an address is seen, and a grant which
+// already exists for it is signed in to, so its owner can use either their
+// password or the provider. Tokens are issued with defaults, recording
+// "oauth2" in their "amr" claim, and the callback then redirects to "next" if
+// it is a path on this host, or otherwise responds 204 No Content.
+func SocialLoginHandler(prefix string, defaults *Config, providers ...*Provider) http.Handler {
+	return std.SocialLoginHandler(prefix, defaults, providers...)
+}
+
+// SocialLoginHandler is the package SocialLoginHandler for the grants in s's
+// Store
+func (s *Service) SocialLoginHandler(prefix string, defaults *Config, providers ...*Provider) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	mux := http.NewServeMux()
+	for _, p := range providers {
+		h := socialHandler{s: s, provider: p, defaults: *defaults, path: prefix + "/" + p.Name}
+		mux.HandleFunc(h.path+"/login", h.login)
+		mux.HandleFunc(h.path+"/callback", h.callback)
+	}
+
+	return mux
+}
+
+type socialHandler struct {
+	s        *Service
+	provider *Provider
+	defaults Config
+	path     string
+}
+
+func (h socialHandler) login(res http.ResponseWriter, req *http.Request) {
+	state, err := newCSRFToken()
+	if err != nil {
+		h.s.writeError(res, err)
+		return
+	}
+
+	verifier, err := newCSRFToken()
+	if err != nil {
+		h.s.writeError(res, err)
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {h.provider.ClientID},
+		"redirect_uri":          {h.provider.RedirectURL},
+		"scope":                 {strings.Join(h.provider.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	value := url.Values{
+		"state":    {state},
+		"verifier": {verifier},
+		"next":     {localPath(req.URL.Query().Get("next"))},
+	}
+
+	http.SetCookie(res, &http.Cookie{
+		Name:     socialStateCookie,
+		Value:    value.Encode(),
+		Path:     h.path,
+		MaxAge:   10 * 60,
+		HttpOnly: true,
+		Secure:   h.defaults.SecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(res, req, h.provider.AuthURL+"?"+q.Encode(), http.StatusFound)
+}
+
+func (h socialHandler) callback(res http.ResponseWriter, req *http.Request) {
+	c, err := req.Cookie(socialStateCookie)
+	if err != nil {
+		http.Error(res, "sign in has expired, try again", http.StatusBadRequest)
+		return
+	}
+
+	http.SetCookie(res, &http.Cookie{
+		Name:     socialStateCookie,
+		Path:     h.path,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   h.defaults.SecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	saved, err := url.ParseQuery(c.Value)
+	state := req.URL.Query().Get("state")
+	if err != nil || state == "" || !sameKey(state, saved.Get("state")) {
+		http.Error(res, "invalid sign in state", http.StatusBadRequest)
+		return
+	}
+
+	code := req.URL.Query().Get("code")
+	if code == "" {
+		http.Error(res, "sign in was cancelled or denied", http.StatusUnauthorized)
+		return
+	}
+
+	accessToken, err := h.provider.exchange(code, saved.Get("verifier"))
+	if err != nil {
+		h.s.logger.Error("failed to exchange authorization code", "provider", h.provider.Name, "err", err)
+		http.Error(res, "sign in failed", http.StatusUnauthorized)
+		return
+	}
+
+	email, err := h.provider.Email(h.provider.Client, accessToken)
+	if err != nil {
+		h.s.logger.Error("failed to get verified email", "provider", h.provider.Name, "err", err)
+		http.Error(res, "sign in failed", http.StatusUnauthorized)
+		return
+	}
+
+	cfg := h.defaults
+	cfg.ResponseWriter = res
+	cfg.Request = req
+
+	key := strings.ToLower(email)
+	_, err = h.s.ProvisionGrant(GrantRequest{
+		Key:      key,
+		Tenant:   cfg.TenantID,
+		Metadata: map[string]string{"provider": h.provider.Name},
+	})
+	if err != nil {
+		h.s.writeError(res, err)
+		return
+	}
+
+	_, err = h.s.IssueToken(key, &cfg, "oauth2")
+	if err != nil {
+		h.s.writeError(res, err)
+		return
+	}
+
+	if next := saved.Get("next"); next != "" {
+		http.Redirect(res, req, next, http.StatusSeeOther)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// localPath returns next if it is a path on this host, so sign ins can't be
+// used to redirect to other sites
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return ""
+	}
+
+	return next
+}
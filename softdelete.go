@@ -0,0 +1,229 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// deletedGrant is the stored value for a grant removed by ClearGrant in the
+// __apiAccessDeleted bucket, holding its records exactly as they were stored
+type deletedGrant struct {
+	DeletedAt time.Time      `json:"deleted_at"`
+	Records   []backupRecord `json:"records"`
+}
+
+// DeletedGrant is a grant removed by ClearGrant which RestoreGrant can still
+// bring back
+type DeletedGrant struct {
+	// Key is the namespaced key returned by TenantKey
+	Key       string    `json:"key"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+var (
+	deletedRetentionMu sync.RWMutex
+	deletedRetention   = 30 * 24 * time.Hour
+)
+
+// SetDeletedRetention sets how long grants removed by ClearGrant are kept for
+// RestoreGrant before PurgeDeletedGrants removes them for good, which is 30
+// days by default. A zero retention makes ClearGrant remove grants at once.
+func SetDeletedRetention(retention time.Duration) {
+	deletedRetentionMu.Lock()
+	deletedRetention = retention
+	deletedRetentionMu.Unlock()
+}
+
+// currentDeletedRetention returns the retention set by SetDeletedRetention
+func currentDeletedRetention() time.Duration {
+	deletedRetentionMu.RLock()
+	defer deletedRetentionMu.RUnlock()
+
+	return deletedRetention
+}
+
+// archiveGrant keeps the grant for key, stored as active, and its groups,
+// grant data, service account credentials and API keys in the
+// __apiAccessDeleted bucket, unless the retention is zero
+func archiveGrant(tx Tx, key string, active []byte) error {
+	if currentDeletedRetention() <= 0 {
+		return nil
+	}
+
+	records := []backupRecord{{Bucket: apiAccessStore, Key: key, Value: active}}
+	for _, bucket := range []string{apiGroupStore, apiGrantDataStore, apiServiceCredentialStore} {
+		j, err := tx.Get(bucket, key)
+		if err != nil {
+			return err
+		}
+
+		if j != nil {
+			records = append(records, backupRecord{Bucket: bucket, Key: key, Value: j})
+		}
+	}
+
+	err := forEachAPIKey(tx, key, func(hash string, k APIKey) error {
+		j, err := tx.Get(apiKeyStore, hash)
+		if err != nil {
+			return err
+		}
+
+		records = append(records, backupRecord{Bucket: apiKeyStore, Key: hash, Value: j})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(deletedGrant{
+		DeletedAt: time.Now(),
+		Records:   records,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Put(apiAccessDeletedStore, key, j)
+}
+
+func getDeletedGrant(tx Tx, key string) (*deletedGrant, error) {
+	j, err := tx.Get(apiAccessDeletedStore, key)
+	if err != nil || j == nil {
+		return nil, err
+	}
+
+	var d deletedGrant
+	err = json.Unmarshal(j, &d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode deleted grant for %s, %v", key, err)
+	}
+
+	return &d, nil
+}
+
+// expired reports whether d is past the retention period at now
+func (d *deletedGrant) expired(now time.Time) bool {
+	return !now.Before(d.DeletedAt.Add(currentDeletedRetention()))
+}
+
+// RestoreGrant brings back the grant for key removed by ClearGrant within the
+// retention period, along with its groups, grant data, service account
+// credentials and API keys. Tokens issued before it was removed stay revoked,
+// so its owner must Login again. It fails with ErrNotFound if there is no
+// grant to restore, and ErrDuplicateKey if a grant for key has been created
+// since. For tenant grants, key should be the namespaced key returned by
+// TenantKey.
+func RestoreGrant(key string) error {
+	return std.RestoreGrant(key)
+}
+
+// RestoreGrant is the package RestoreGrant for the grants in s's Store
+func (s *Service) RestoreGrant(key string) error {
+	if key == "" {
+		return fmt.Errorf("%s", "key must not be empty")
+	}
+
+	return s.store.Update(func(tx Tx) error {
+		d, err := getDeletedGrant(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if d == nil || d.expired(time.Now()) {
+			return notFound(key)
+		}
+
+		active, err := tx.Get(apiAccessStore, key)
+		if err != nil {
+			return err
+		}
+
+		if active != nil {
+			return ErrDuplicateKey
+		}
+
+		for _, r := range d.Records {
+			err = tx.Put(r.Bucket, r.Key, r.Value)
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Delete(apiAccessDeletedStore, key)
+	})
+}
+
+// ListDeletedGrants returns the grants removed by ClearGrant which can still be
+// restored, in key order
+func ListDeletedGrants() ([]DeletedGrant, error) {
+	return std.ListDeletedGrants()
+}
+
+// ListDeletedGrants is the package ListDeletedGrants for s's Store
+func (s *Service) ListDeletedGrants() ([]DeletedGrant, error) {
+	deleted := []DeletedGrant{}
+	now := time.Now()
+	err := s.store.View(func(tx Tx) error {
+		return tx.ForEach(apiAccessDeletedStore, func(key string, value []byte) error {
+			var d deletedGrant
+			err := json.Unmarshal(value, &d)
+			if err != nil {
+				return fmt.Errorf("failed to decode deleted grant for %s, %v", key, err)
+			}
+
+			if !d.expired(now) {
+				deleted = append(deleted, DeletedGrant{Key: key, DeletedAt: d.DeletedAt})
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// PurgeDeletedGrants removes the grants removed by ClearGrant longer ago than
+// the retention period for good, and returns the number removed
+func PurgeDeletedGrants() (int, error) {
+	return std.PurgeDeletedGrants()
+}
+
+// PurgeDeletedGrants is the package PurgeDeletedGrants for s's Store
+func (s *Service) PurgeDeletedGrants() (int, error) {
+	var purged int
+	now := time.Now()
+	err := s.store.Update(func(tx Tx) error {
+		var expired []string
+		err := tx.ForEach(apiAccessDeletedStore, func(key string, value []byte) error {
+			var d deletedGrant
+			if json.Unmarshal(value, &d) != nil || d.expired(now) {
+				expired = append(expired, key)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range expired {
+			err := tx.Delete(apiAccessDeletedStore, key)
+			if err != nil {
+				return err
+			}
+		}
+
+		purged = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
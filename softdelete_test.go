@@ -0,0 +1,84 @@
+package access_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+func TestRestoreGrant(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	token := accesstest.Token(t, "restore@example.com", accesstest.WithRoles("editor"))
+	err := access.SetGrantData("restore@example.com", "profile", []byte("kept"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = access.ClearGrant("restore@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := accesstest.Request(http.MethodGet, "/", token)
+	if access.IsGranted(req, http.Header{}) {
+		t.Error("token of a cleared grant accepted")
+	}
+
+	deleted, err := access.ListDeletedGrants()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deleted) != 1 || deleted[0].Key != "restore@example.com" {
+		t.Errorf("ListDeletedGrants: got %v", deleted)
+	}
+
+	err = access.RestoreGrant("restore@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := access.GetGrant("restore@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a.Roles) != 1 || a.Roles[0] != "editor" {
+		t.Errorf("restored grant has roles %v, want [editor]", a.Roles)
+	}
+
+	data, err := access.GrantData("restore@example.com", "profile")
+	if err != nil || string(data) != "kept" {
+		t.Errorf("restored grant data: got %q, %v", data, err)
+	}
+
+	if access.IsGranted(req, http.Header{}) {
+		t.Error("token revoked by ClearGrant accepted after RestoreGrant")
+	}
+
+	_, err = access.Login("restore@example.com", accesstest.Password, headerConfig(""))
+	if err != nil {
+		t.Errorf("Login to the restored grant: %v", err)
+	}
+
+	err = access.RestoreGrant("restore@example.com")
+	if !errors.Is(err, access.ErrNotFound) {
+		t.Errorf("RestoreGrant of an active grant: got %v, want ErrNotFound", err)
+	}
+
+	// a grant created at the key since it was cleared isn't replaced
+	err = access.ClearGrant("restore@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accesstest.Token(t, "restore@example.com")
+	err = access.RestoreGrant("restore@example.com")
+	if !errors.Is(err, access.ErrDuplicateKey) {
+		t.Errorf("RestoreGrant over a new grant: got %v, want ErrDuplicateKey", err)
+	}
+}
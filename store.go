@@ -0,0 +1,157 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/ponzu-cms/ponzu/system/db"
+)
+
+// Store is the persistence layer holding access grants and pending users. It
+// mirrors bolt's transaction model: everything done within a single Update
+// either commits together or not at all.
+type Store interface {
+	View(fn func(tx Tx) error) error
+	Update(fn func(tx Tx) error) error
+}
+
+// Tx is a transaction against a Store. Values returned from Get are only
+// valid for the life of the transaction, and ForEach visits keys in byte
+// order, as bolt does.
+type Tx interface {
+	Get(bucket, key string) ([]byte, error)
+	Put(bucket, key string, value []byte) error
+	Delete(bucket, key string) error
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+}
+
+// ContextStore is a Store whose transactions can be bound to a context, so
+// deadlines and cancellation reach the database. The context variants of the
+// package functions, such as GrantContext, use it when the Store implements
+// it, and otherwise check the context before each operation of a transaction.
+type ContextStore interface {
+	Store
+	ViewContext(ctx context.Context, fn func(tx Tx) error) error
+	UpdateContext(ctx context.Context, fn func(tx Tx) error) error
+}
+
+var errTxNotWritable = errors.New("tx not writable")
+
+// errStopIteration ends a ForEach early without failing the transaction
+var errStopIteration = errors.New("stop iteration")
+
+// UseStore replaces the Store used by the package, which defaults to Ponzu's
+// bolt database. It should be called before any grants are created or checked.
+func UseStore(s Store) {
+	if s == nil {
+		s = newBoltStore()
+	}
+
+	std.store = s
+}
+
+// boltStore is the default Store, backed by the Ponzu system database
+type boltStore struct{}
+
+// boltBuckets are the buckets of the Ponzu system database a boltStore uses
+var boltBuckets = []string{
+	apiAccessStore,
+	apiPendingUserStore,
+	apiGroupStore,
+	apiACLStore,
+	apiRateLimitStore,
+	apiResetStore,
+	apiVerifyStore,
+	apiGrantDataStore,
+	apiKeyStore,
+	apiNonceStore,
+	apiAuditStore,
+	apiTokenVersionStore,
+	apiSessionStore,
+	apiInviteStore,
+	apiServiceCredentialStore,
+	apiRequestLimitStore,
+	apiUsageStore,
+	apiAccessDeletedStore,
+}
+
+var registerBuckets sync.Once
+
+// newBoltStore returns a boltStore, registering its buckets with Ponzu the
+// first time, which must happen before Ponzu opens the database
+func newBoltStore() boltStore {
+	registerBuckets.Do(func() {
+		for _, bucket := range boltBuckets {
+			db.AddBucket(bucket)
+		}
+	})
+
+	return boltStore{}
+}
+
+func (boltStore) View(fn func(tx Tx) error) error {
+	return db.Store().View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (boltStore) Update(fn func(tx Tx) error) error {
+	return db.Store().Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t boltTx) bucket(name string) (*bolt.Bucket, error) {
+	b := t.tx.Bucket([]byte(name))
+	if b == nil {
+		return nil, fmt.Errorf("failed to get bucket %s", name)
+	}
+
+	return b, nil
+}
+
+func (t boltTx) Get(bucket, key string) ([]byte, error) {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Get([]byte(key)), nil
+}
+
+func (t boltTx) Put(bucket, key string, value []byte) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(key), value)
+}
+
+func (t boltTx) Delete(bucket, key string) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+
+	return b.Delete([]byte(key))
+}
+
+func (t boltTx) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+
+	return b.ForEach(func(k, v []byte) error {
+		return fn(string(k), v)
+	})
+}
@@ -0,0 +1,115 @@
+package access
+
+import (
+	"sync"
+	"time"
+)
+
+// SetStrict makes every token check also confirm that the token's grant still
+// exists and isn't disabled or expired, when enabled. ClearGrant, Disable and
+// SetGrantExpiry already revoke the tokens of a grant, but grants which pass
+// their ExpiresAt, or are removed or disabled in the Store by other means,
+// such as tools writing to it directly, keep their tokens working until they
+// expire otherwise. Grants found active are not read again for cacheTTL, so a
+// busy grant costs at most one read per cacheTTL, and grants found missing or
+// inactive are read every time. A zero cacheTTL reads the grant on every
+// check.
+func SetStrict(enabled bool, cacheTTL time.Duration) {
+	std.SetStrict(enabled, cacheTTL)
+}
+
+// SetStrict is the package SetStrict for the tokens s verifies. It must be
+// called before s handles requests.
+func (s *Service) SetStrict(enabled bool, cacheTTL time.Duration) {
+	if !enabled {
+		s.strict = nil
+		return
+	}
+
+	s.strict = &activeGrants{
+		ttl:       cacheTTL,
+		confirmed: make(map[string]time.Time),
+	}
+}
+
+// activeGrants remembers until when grants were confirmed active
+type activeGrants struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	confirmed map[string]time.Time
+	nextSweep time.Time
+}
+
+// grantActive reports whether the grant for key exists and can be used, if s
+// is strict
+func (s *Service) grantActive(key string) bool {
+	g := s.strict
+	if g == nil {
+		return true
+	}
+
+	now := time.Now()
+	if g.active(key, now) {
+		return true
+	}
+
+	var a *APIAccess
+	err := s.store.View(func(tx Tx) error {
+		var err error
+		a, _, err = getGrant(tx, key)
+		return err
+	})
+	if err != nil {
+		s.logger.Error("failed to read grant", "key", key, "err", err)
+		return false
+	}
+
+	if a == nil || a.inactive(now) != nil {
+		return false
+	}
+
+	until := now.Add(g.ttl)
+	if !a.ExpiresAt.IsZero() && a.ExpiresAt.Before(until) {
+		until = a.ExpiresAt
+	}
+
+	g.confirm(key, until, now)
+	return true
+}
+
+func (g *activeGrants) active(key string, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return now.Before(g.confirmed[key])
+}
+
+func (g *activeGrants) forget(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.confirmed, key)
+}
+
+func (g *activeGrants) confirm(key string, until, now time.Time) {
+	if !until.After(now) {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.confirmed[key] = until
+
+	// forget grants no longer seen, at most once per ttl
+	if now.After(g.nextSweep) {
+		for k, t := range g.confirmed {
+			if !now.Before(t) {
+				delete(g.confirmed, k)
+			}
+		}
+
+		g.nextSweep = now.Add(g.ttl)
+	}
+}
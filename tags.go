@@ -0,0 +1,158 @@
+package access
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TagGrant adds tags, such as "partner", "internal" or "trial", to the grant
+// for key, to manage cohorts of API consumers with ListGrantsByTag and the bulk
+// operations by tag. Tags already on the grant are kept. Unlike groups, tags
+// are not included in tokens. For tenant grants, key should be the namespaced
+// key returned by TenantKey.
+func TagGrant(key string, tags ...string) error {
+	return std.TagGrant(key, tags...)
+}
+
+// TagGrant is the package TagGrant for the grants in s's Store
+func (s *Service) TagGrant(key string, tags ...string) error {
+	err := validateTags(tags)
+	if err != nil {
+		return err
+	}
+
+	return s.modifyGrant(key, func(a *APIAccess) error {
+		for _, tag := range tags {
+			if !containsString(a.Tags, tag) {
+				a.Tags = append(a.Tags, tag)
+			}
+		}
+
+		return nil
+	})
+}
+
+// UntagGrant removes tags from the grant for key
+func UntagGrant(key string, tags ...string) error {
+	return std.UntagGrant(key, tags...)
+}
+
+// UntagGrant is the package UntagGrant for the grants in s's Store
+func (s *Service) UntagGrant(key string, tags ...string) error {
+	return s.modifyGrant(key, func(a *APIAccess) error {
+		kept := a.Tags[:0]
+		for _, tag := range a.Tags {
+			if !containsString(tags, tag) {
+				kept = append(kept, tag)
+			}
+		}
+
+		a.Tags = kept
+		if len(a.Tags) == 0 {
+			a.Tags = nil
+		}
+
+		return nil
+	})
+}
+
+// validateTags rejects tags which are empty or hold whitespace or commas, so
+// they can be listed as comma separated values
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if tag == "" || strings.ContainsAny(tag, ", \t\r\n") {
+			return fmt.Errorf("tags must not be empty or hold whitespace or commas, got %q", tag)
+		}
+	}
+
+	return nil
+}
+
+// ListGrantsByTag returns the grants tagged with tag, in key order. Password
+// hashes, salts and TOTP secrets are omitted from the returned grants.
+func ListGrantsByTag(tag string) ([]APIAccess, error) {
+	return std.ListGrantsByTag(tag)
+}
+
+// ListGrantsByTag is the package ListGrantsByTag for the grants in s's Store
+func (s *Service) ListGrantsByTag(tag string) ([]APIAccess, error) {
+	grants := []APIAccess{}
+	err := s.store.View(func(tx Tx) error {
+		return tx.ForEach(apiAccessStore, func(key string, value []byte) error {
+			a, _, err := decodeGrant(value)
+			if err != nil {
+				return fmt.Errorf("failed to decode grant for %s, %v", key, err)
+			}
+
+			if containsString(a.Tags, tag) {
+				grants = append(grants, a.redacted())
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+// ForEachGrantByTag calls fn with the namespaced key of every grant tagged with
+// tag, in key order, such as to update the metadata of a cohort at once. Each
+// call of fn runs on its own, outside of any transaction, so grants tagged
+// while it runs may be missed. It stops at the first error fn returns, which
+// is returned along with the number of grants fn was called with before.
+func ForEachGrantByTag(tag string, fn func(key string) error) (int, error) {
+	return std.ForEachGrantByTag(tag, fn)
+}
+
+// ForEachGrantByTag is the package ForEachGrantByTag for s's Store
+func (s *Service) ForEachGrantByTag(tag string, fn func(key string) error) (int, error) {
+	grants, err := s.ListGrantsByTag(tag)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, a := range grants {
+		err = fn(TenantKey(a.Tenant, a.Key))
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(grants), nil
+}
+
+// DisableByTag disables every grant tagged with tag, as Disable does, and
+// returns the number disabled
+func DisableByTag(tag string) (int, error) {
+	return std.DisableByTag(tag)
+}
+
+// DisableByTag is the package DisableByTag for the grants in s's Store
+func (s *Service) DisableByTag(tag string) (int, error) {
+	return s.ForEachGrantByTag(tag, Disable)
+}
+
+// EnableByTag re-enables every grant tagged with tag, as Enable does, and
+// returns the number enabled
+func EnableByTag(tag string) (int, error) {
+	return std.EnableByTag(tag)
+}
+
+// EnableByTag is the package EnableByTag for the grants in s's Store
+func (s *Service) EnableByTag(tag string) (int, error) {
+	return s.ForEachGrantByTag(tag, Enable)
+}
+
+// ClearGrantsByTag removes every grant tagged with tag, as ClearGrant does,
+// and returns the number removed
+func ClearGrantsByTag(tag string) (int, error) {
+	return std.ClearGrantsByTag(tag)
+}
+
+// ClearGrantsByTag is the package ClearGrantsByTag for the grants in s's Store
+func (s *Service) ClearGrantsByTag(tag string) (int, error) {
+	return s.ForEachGrantByTag(tag, ClearGrant)
+}
@@ -0,0 +1,67 @@
+package access
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TenantKey returns the key under which a tenant's grant or pending user is
+// stored, in the form "@tenantID/key". Keys without a tenant are stored as-is,
+// and since grants are never created with keys beginning with "@", tenant keys
+// never collide with them. Use it to call Check, Pending, ClearPending,
+// ClearGrant and IsOwner for keys granted with a Config.TenantID.
+func TenantKey(tenantID, key string) string {
+	if tenantID == "" {
+		return key
+	}
+
+	return "@" + tenantID + "/" + key
+}
+
+func validateTenantID(tenantID string) error {
+	if strings.Contains(tenantID, "/") {
+		return fmt.Errorf("tenant ID %s must not contain '/'", tenantID)
+	}
+
+	return nil
+}
+
+// validateKey rejects keys of grants in tenantID which TenantKey could confuse
+// with the keys of another tenant: keys beginning with "@", which are reserved
+// for tenant keys, and keys of tenant grants holding "/"
+func validateKey(key, tenantID string) error {
+	if strings.HasPrefix(key, "@") {
+		return fmt.Errorf("key %s must not begin with '@'", key)
+	}
+
+	if tenantID != "" && strings.Contains(key, "/") {
+		return fmt.Errorf("key %s of a tenant grant must not contain '/'", key)
+	}
+
+	return nil
+}
+
+// validateLocalKey is validateKey for the keys of grants with a password,
+// which must not hold "|" so they can't be mistaken for an ExternalKey
+func validateLocalKey(key, tenantID string) error {
+	if strings.Contains(key, "|") {
+		return fmt.Errorf("key %s must not contain '|'", key)
+	}
+
+	return validateKey(key, tenantID)
+}
+
+// validateStoreKey is validateLocalKey for a key namespaced by TenantKey, which
+// may only begin with "@" if it names a tenant
+func validateStoreKey(storeKey string) error {
+	if !strings.HasPrefix(storeKey, "@") {
+		return validateLocalKey(storeKey, "")
+	}
+
+	tenantID, key, ok := strings.Cut(storeKey[1:], "/")
+	if !ok || tenantID == "" || key == "" {
+		return fmt.Errorf("key %s must not begin with '@' unless namespaced by TenantKey", storeKey)
+	}
+
+	return validateLocalKey(key, tenantID)
+}
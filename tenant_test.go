@@ -0,0 +1,116 @@
+package access_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+// headerConfig returns a Config issuing tokens in the Authorization header for
+// grants of tenant
+func headerConfig(tenant string) *access.Config {
+	return &access.Config{
+		ExpireAfter:    time.Hour,
+		ResponseWriter: httptest.NewRecorder(),
+		TokenStore:     http.Header{},
+		TenantID:       tenant,
+	}
+}
+
+// bearer returns a request carrying token in the Authorization header
+func bearer(method, token string) *http.Request {
+	req := httptest.NewRequest(method, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestTenantIsolation(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	a, err := access.Grant("bob", accesstest.Password, headerConfig("acme"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = access.Grant("bob", accesstest.Password+"2", headerConfig("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = access.Login("bob", accesstest.Password, headerConfig("other"))
+	if err == nil {
+		t.Error("logged in to another tenant's grant with the same key")
+	}
+
+	req := bearer(http.MethodGet, a.Token)
+	if !access.IsOwner(req, http.Header{}, access.TenantKey("acme", "bob")) {
+		t.Error("token isn't owner of its own grant")
+	}
+
+	for _, key := range []string{access.TenantKey("other", "bob"), "bob"} {
+		if access.IsOwner(req, http.Header{}, key) {
+			t.Errorf("token of acme's bob is owner of %s", key)
+		}
+	}
+}
+
+func TestTenantKeySquat(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	squat := access.TenantKey("acme", "bob")
+	_, err := access.Grant(squat, accesstest.Password, headerConfig(""))
+	if err == nil {
+		t.Fatal("granted an untenanted key in acme's namespace")
+	}
+
+	_, err = access.Grant("x/bob", accesstest.Password, headerConfig("acme"))
+	if err == nil {
+		t.Error("granted a tenant key holding '/'")
+	}
+
+	_, err = access.ProvisionGrant(access.GrantRequest{Key: squat})
+	if err == nil {
+		t.Error("provisioned an untenanted key in acme's namespace")
+	}
+
+	_, err = access.CreateServiceAccount(access.GrantRequest{Key: squat}, nil)
+	if err == nil {
+		t.Error("created a service account in acme's namespace")
+	}
+
+	err = access.Pending("@acme")
+	if err == nil {
+		t.Error("added a malformed tenant key to pending status")
+	}
+
+	res := httptest.NewRecorder()
+	form := url.Values{"key": {squat}, "password": {accesstest.Password}}
+	req := httptest.NewRequest(http.MethodPost, "/account/signup", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	access.AccountHandler("/account", headerConfig("")).ServeHTTP(res, req)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("signup of %s: got status %d, want %d", squat, res.Code, http.StatusBadRequest)
+	}
+
+	err = access.Check(squat)
+	if err != nil {
+		t.Fatalf("acme's bob was taken: %v", err)
+	}
+
+	_, err = access.Grant("bob", accesstest.Password, headerConfig("acme"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = access.Check(squat)
+	if !errors.Is(err, access.ErrDuplicateKey) {
+		t.Errorf("Check(%s) = %v, want ErrDuplicateKey", squat, err)
+	}
+}
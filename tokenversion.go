@@ -0,0 +1,135 @@
+package access
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// tokenVersion returns the version the tokens of the grant for key must carry,
+// stored in the __apiTokenVersion bucket apart from the grant so checks read
+// as little as possible. Grants without one are at version 0.
+func tokenVersion(tx Tx, key string) (int, error) {
+	j, err := tx.Get(apiTokenVersionStore, key)
+	if err != nil || j == nil {
+		return 0, err
+	}
+
+	v, err := strconv.Atoi(string(j))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode token version for %s, %v", key, err)
+	}
+
+	return v, nil
+}
+
+// revokeTokens bumps the token version of the grant for key and ends its
+// sessions, so every token issued for it before is rejected
+func revokeTokens(tx Tx, key string) error {
+	v, err := tokenVersion(tx, key)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Delete(apiSessionStore, key)
+	if err != nil {
+		return err
+	}
+
+	return tx.Put(apiTokenVersionStore, key, []byte(strconv.Itoa(v+1)))
+}
+
+// currentToken reports whether claims carry the token version of their grant
+// and name one of its active sessions, read from the Store unless
+// SetSessionCache has the grant's state. Checks fail closed if either can't be
+// read.
+func (s *Service) currentToken(claims map[string]interface{}) bool {
+	key := claimKey(claims)
+	now := time.Now()
+	state, ok := s.sessionStates.get(key, now)
+	if ok && state.current(claims, now) {
+		return true
+	}
+
+	// a single value is captured, so the closure costs one allocation
+	err := s.store.View(func(tx Tx) error {
+		var err error
+		state, err = readSessionState(tx, key)
+		return err
+	})
+	if err != nil {
+		s.logger.Error("failed to read token version", "key", key, "err", err)
+		return false
+	}
+
+	s.sessionStates.put(key, state, now)
+	return state.current(claims, now)
+}
+
+// UpdatePassword changes the password of the grant for key from oldPassword to
+// newPassword, which must meet the PasswordPolicy, and revokes every token
+// issued for the grant, all within a single transaction. Wrong passwords count
+// towards SetLockout and SetAttemptLimit as failed logins do. Call Login for a
+// new token once the password is changed.
+func UpdatePassword(key, oldPassword, newPassword string) error {
+	return std.UpdatePassword(key, oldPassword, newPassword)
+}
+
+// UpdatePassword is the package UpdatePassword for the grants in s's Store
+func (s *Service) UpdatePassword(key, oldPassword, newPassword string) error {
+	if key == "" {
+		return fmt.Errorf("%s", "key must not be empty")
+	}
+
+	if oldPassword == "" || newPassword == "" {
+		return fmt.Errorf("%s", "password must not be empty")
+	}
+
+	err := s.takeAttempt(&Config{}, key)
+	if err != nil {
+		return err
+	}
+
+	// the old password is checked before the transaction, as hashing is slow,
+	// and before the new one, whose PasswordChecker may make network calls
+	stored, err := s.storedPassword(key, oldPassword)
+	if err == ErrUnauthorized {
+		s.recordLoginFailure(key)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = s.checkNewPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	hashed := &APIAccess{}
+	err = s.hashPassword(hashed, newPassword)
+	if err != nil {
+		return err
+	}
+
+	defer s.sessionStates.forget(key)
+	return s.store.Update(func(tx Tx) error {
+		a, err := s.updateGrant(tx, key, stored, oldPassword)
+		if err != nil {
+			return err
+		}
+
+		a.Hash = hashed.Hash
+		a.Salt = hashed.Salt
+		a.HashAlgorithm = hashed.HashAlgorithm
+		a.PepperVersion = hashed.PepperVersion
+		a.FailedLogins = 0
+		a.LockedUntil = time.Time{}
+
+		err = revokeTokens(tx, key)
+		if err != nil {
+			return err
+		}
+
+		return putGrant(tx, a)
+	})
+}
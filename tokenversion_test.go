@@ -0,0 +1,56 @@
+package access_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+func TestUpdatePasswordRevokesTokens(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	token := accesstest.Token(t, "version@example.com")
+	req := accesstest.Request(http.MethodGet, "/", token)
+	if !access.IsGranted(req, http.Header{}) {
+		t.Fatal("token rejected")
+	}
+
+	err := access.UpdatePassword("version@example.com", "wrong", accesstest.Password+"2")
+	if !errors.Is(err, access.ErrUnauthorized) {
+		t.Errorf("UpdatePassword with the wrong password: got %v, want ErrUnauthorized", err)
+	}
+
+	if !access.IsGranted(req, http.Header{}) {
+		t.Fatal("a failed UpdatePassword revoked the grant's tokens")
+	}
+
+	err = access.UpdatePassword("version@example.com", accesstest.Password, accesstest.Password+"2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if access.IsGranted(req, http.Header{}) {
+		t.Error("token issued before UpdatePassword accepted")
+	}
+
+	if _, ok := access.VerifyToken(token); ok {
+		t.Error("VerifyToken accepted a token issued before UpdatePassword")
+	}
+
+	_, err = access.Login("version@example.com", accesstest.Password, headerConfig(""))
+	if !errors.Is(err, access.ErrUnauthorized) {
+		t.Errorf("Login with the old password: got %v, want ErrUnauthorized", err)
+	}
+
+	a, err := access.Login("version@example.com", accesstest.Password+"2", headerConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !access.IsGranted(bearer(http.MethodGet, a.Token), http.Header{}) {
+		t.Error("token issued after UpdatePassword rejected")
+	}
+}
@@ -0,0 +1,186 @@
+package access
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	totpPeriod = 30
+	totpDigits = 6
+
+	// totpSkew is the number of periods either side of the current one
+	// whose codes are accepted, to allow for clock drift
+	totpSkew = 1
+)
+
+// ErrTOTPRequired is returned by Login for grants with TOTP enabled when
+// Config.OTP is missing or isn't a valid code
+var ErrTOTPRequired = errors.New("a valid one-time code is required")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// EnableTOTP generates a new TOTP secret for the grant for key and returns it,
+// along with an otpauth:// URI to show as a QR code in authenticator apps.
+// TOTP isn't required by Login until the grant's owner proves they hold the
+// secret by passing a code to ConfirmTOTP. The secret is stored with the
+// grant, so EnableTOTP fails unless UseEncryption is set to encrypt it at
+// rest. It also fails for grants with TOTP already enabled, whose secret stays
+// in use until DisableTOTP removes it.
+func EnableTOTP(key, issuer string) (string, string, error) {
+	return std.EnableTOTP(key, issuer)
+}
+
+// EnableTOTP is the package EnableTOTP for the grants in s's Store
+func (s *Service) EnableTOTP(key, issuer string) (string, string, error) {
+	if currentKeyWrapper() == nil {
+		return "", "", fmt.Errorf("%s", "TOTP secrets must be encrypted, call UseEncryption first")
+	}
+
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate TOTP secret, %v", err)
+	}
+
+	secret := totpEncoding.EncodeToString(b)
+	err = s.modifyGrant(key, func(a *APIAccess) error {
+		if a.TOTPEnabled {
+			return fmt.Errorf("TOTP is already enabled for %s", key)
+		}
+
+		a.TOTPSecret = secret
+		a.TOTPEnabled = false
+		a.TOTPLastStep = 0
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	label := key
+	if issuer != "" {
+		label = issuer + ":" + key
+	}
+
+	q := url.Values{}
+	q.Set("secret", secret)
+	if issuer != "" {
+		q.Set("issuer", issuer)
+	}
+
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + label,
+		RawQuery: q.Encode(),
+	}
+
+	return secret, uri.String(), nil
+}
+
+// ConfirmTOTP requires TOTP codes for the grant for key from now on, if code is
+// valid for the secret issued by EnableTOTP
+func ConfirmTOTP(key, code string) error {
+	return std.ConfirmTOTP(key, code)
+}
+
+// ConfirmTOTP is the package ConfirmTOTP for the grants in s's Store
+func (s *Service) ConfirmTOTP(key, code string) error {
+	return s.modifyGrant(key, func(a *APIAccess) error {
+		if a.TOTPSecret == "" {
+			return fmt.Errorf("TOTP has not been enabled for %s", key)
+		}
+
+		if !a.checkTOTP(code, time.Now()) {
+			return ErrTOTPRequired
+		}
+
+		a.TOTPEnabled = true
+		return nil
+	})
+}
+
+// DisableTOTP removes the TOTP secret of the grant for key, so Login no longer
+// requires a code
+func DisableTOTP(key string) error {
+	return std.DisableTOTP(key)
+}
+
+// DisableTOTP is the package DisableTOTP for the grants in s's Store
+func (s *Service) DisableTOTP(key string) error {
+	return s.modifyGrant(key, func(a *APIAccess) error {
+		a.TOTPSecret = ""
+		a.TOTPEnabled = false
+		a.TOTPLastStep = 0
+		return nil
+	})
+}
+
+// RequireMFA returns middleware which only calls next for requests holding a
+// valid token issued after a TOTP code was checked, as recorded by its "amr"
+// claim. Requests without a valid token are rejected with 401 Unauthorized,
+// and those authenticated by password alone with 403 Forbidden.
+func RequireMFA(next http.HandlerFunc) http.HandlerFunc {
+	return std.RequireMFA(next)
+}
+
+// RequireMFA is the package RequireMFA, verifying tokens with s's Signer
+func (s *Service) RequireMFA(next http.HandlerFunc) http.HandlerFunc {
+	return s.RequireClaim("amr", ClaimContains("otp"))(next)
+}
+
+// checkTOTP reports whether code is valid for the grant's secret at now and
+// hasn't been used before, recording its time step to prevent reuse
+func (a *APIAccess) checkTOTP(code string, now time.Time) bool {
+	secret, err := totpEncoding.DecodeString(a.TOTPSecret)
+	if err != nil || len(code) != totpDigits {
+		return false
+	}
+
+	step := now.Unix() / totpPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		s := step + int64(i)
+		if s <= a.TOTPLastStep {
+			continue
+		}
+
+		want := totpCode(secret, s)
+		if subtle.ConstantTimeCompare([]byte(code), []byte(want)) == 1 {
+			a.TOTPLastStep = s
+			return true
+		}
+	}
+
+	return false
+}
+
+// totpCode returns the code for a time step, as described by RFC 6238
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	code := strconv.Itoa(int(n % 1000000))
+	for len(code) < totpDigits {
+		code = "0" + code
+	}
+
+	return code
+}
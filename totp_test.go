@@ -0,0 +1,82 @@
+package access_test
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+// totpCode returns the current code for secret, as an authenticator app would
+func totpCode(t *testing.T, secret string) string {
+	t.Helper()
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var step [8]byte
+	binary.BigEndian.PutUint64(step[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(step[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000)
+}
+
+func TestEnableTOTP(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	_, err := access.Grant("totp@example.com", accesstest.Password, headerConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = access.EnableTOTP("totp@example.com", "Example")
+	if err == nil {
+		t.Fatal("stored a TOTP secret without encryption")
+	}
+
+	masterKey := make([]byte, 32)
+	rand.Read(masterKey)
+	t.Setenv("ACCESS_TEST_KEY", base64.StdEncoding.EncodeToString(masterKey))
+	kw, err := access.NewEnvKeyWrapper("ACCESS_TEST_KEY")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	access.UseEncryption(kw)
+	t.Cleanup(func() { access.UseEncryption(nil) })
+
+	secret, _, err := access.EnableTOTP("totp@example.com", "Example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = access.ConfirmTOTP("totp@example.com", totpCode(t, secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = access.EnableTOTP("totp@example.com", "Example")
+	if err == nil {
+		t.Error("replaced the secret of a grant with TOTP enabled")
+	}
+
+	_, err = access.Login("totp@example.com", accesstest.Password, headerConfig(""))
+	if !errors.Is(err, access.ErrTOTPRequired) {
+		t.Errorf("Login without a code: got %v, want ErrTOTPRequired", err)
+	}
+}
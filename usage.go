@@ -0,0 +1,149 @@
+package access
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// QuotaMetadata is the Metadata entry holding the number of requests a grant
+// may make per billing period through MeterUsage, such as "10000". Grants
+// without it, or with a value which isn't a whole number, are unlimited.
+const QuotaMetadata = "quota"
+
+// usagePeriodLayout formats billing periods, which are calendar months in UTC
+const usagePeriodLayout = "2006-01"
+
+// BillingPeriod returns the billing period t falls in, such as "2024-05" for
+// May 2024. Billing periods are calendar months in UTC.
+func BillingPeriod(t time.Time) string {
+	return t.UTC().Format(usagePeriodLayout)
+}
+
+// usageKey is where the usage of the grant for key in period is kept in the
+// __apiUsage bucket
+func usageKey(key, period string) string {
+	return period + "|" + key
+}
+
+// Usage returns the number of requests counted by MeterUsage for the grant for
+// key in period, as returned by BillingPeriod. For tenant grants, key is the
+// namespaced key returned by TenantKey.
+func Usage(key, period string) (int, error) {
+	return std.Usage(key, period)
+}
+
+// Usage is the package Usage for the requests counted in s's Store
+func (s *Service) Usage(key, period string) (int, error) {
+	if key == "" {
+		return 0, fmt.Errorf("%s", "key must not be empty")
+	}
+
+	_, err := time.Parse(usagePeriodLayout, period)
+	if err != nil {
+		return 0, fmt.Errorf("period must be a month such as 2024-05, got %q", period)
+	}
+
+	var count int
+	err = s.store.View(func(tx Tx) error {
+		var err error
+		count, err = usageCount(tx, usageKey(key, period))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func usageCount(tx Tx, id string) (int, error) {
+	j, err := tx.Get(apiUsageStore, id)
+	if err != nil || j == nil {
+		return 0, err
+	}
+
+	count, err := strconv.Atoi(string(j))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode usage for %s, %v", id, err)
+	}
+
+	return count, nil
+}
+
+// MeterUsage is middleware counting the requests of each grant per billing
+// period in the __apiUsage bucket of the Store, identifying the grant from the
+// request's valid token, API key or request signature. Grants with a quota in
+// their QuotaMetadata entry are rejected once they have made that many
+// requests in the period, with 429 Too Many Requests and a Retry-After header
+// holding the seconds until the next period starts, and those requests aren't
+// counted. Requests without a valid credential are rejected with 401
+// Unauthorized.
+func MeterUsage(next http.HandlerFunc) http.HandlerFunc {
+	return std.MeterUsage(next)
+}
+
+// MeterUsage is the package MeterUsage, counting requests in s's Store
+func (s *Service) MeterUsage(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		key, ok := s.requestKey(req)
+		if !ok {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		now := time.Now()
+		allowed, err := s.countUsage(key, now)
+		if err != nil {
+			s.logger.Error("failed to count usage", "key", key, "err", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if !allowed {
+			start, _ := time.Parse(usagePeriodLayout, BillingPeriod(now))
+			retryAfter := start.AddDate(0, 1, 0).Sub(now)
+			res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			res.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
+
+// countUsage counts a request by the grant for key at now, reporting false
+// without counting it if the grant has used up its quota
+func (s *Service) countUsage(key string, now time.Time) (bool, error) {
+	id := usageKey(key, BillingPeriod(now))
+
+	var allowed bool
+	err := s.store.Update(func(tx Tx) error {
+		count, err := usageCount(tx, id)
+		if err != nil {
+			return err
+		}
+
+		a, _, err := getGrant(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if a != nil {
+			quota, err := strconv.Atoi(a.Metadata[QuotaMetadata])
+			if err == nil && count >= quota {
+				return nil
+			}
+		}
+
+		allowed = true
+		return tx.Put(apiUsageStore, id, []byte(strconv.Itoa(count+1)))
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return allowed, nil
+}
@@ -0,0 +1,235 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// verifyRecord is the stored value for a verification token in the
+// __apiVerify bucket, keyed by the token's hash, holding the grant to create
+// once the key is verified
+type verifyRecord struct {
+	Expires time.Time `json:"expires"`
+	Grant   APIAccess `json:"grant"`
+}
+
+var (
+	verifyMu  sync.RWMutex
+	verifyTTL = 24 * time.Hour
+	verifyURL string
+)
+
+// SetVerifyTTL sets how long email verification tokens are valid for, which is
+// a day by default
+func SetVerifyTTL(ttl time.Duration) {
+	verifyMu.Lock()
+	verifyTTL = ttl
+	verifyMu.Unlock()
+}
+
+// SetVerifyURL sets the link sent by the Mailer to verify a key, to which the
+// token is added as the "token" query parameter
+func SetVerifyURL(u string) {
+	verifyMu.Lock()
+	verifyURL = u
+	verifyMu.Unlock()
+}
+
+// verifySettings returns the TTL and URL of verifications
+func verifySettings() (time.Duration, string) {
+	verifyMu.RLock()
+	defer verifyMu.RUnlock()
+
+	return verifyTTL, verifyURL
+}
+
+// PendingWithVerification adds key to pending status, as Pending does, and
+// returns a token which grants it access with password when passed to Verify.
+// If a Mailer has been set, the token is also sent to key as a link to the
+// verify URL. Only a hash of the token is stored.
+func PendingWithVerification(key, password string, cfg *Config) (string, error) {
+	return std.PendingWithVerification(key, password, cfg)
+}
+
+// PendingWithVerification is the package PendingWithVerification, keeping
+// the pending user in s's Store
+func (s *Service) PendingWithVerification(key, password string, cfg *Config) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("%s", "key must not be empty")
+	}
+
+	if password == "" {
+		return "", fmt.Errorf("%s", "password must not be empty")
+	}
+
+	err := validateTenantID(cfg.TenantID)
+	if err != nil {
+		return "", err
+	}
+
+	err = validateLocalKey(key, cfg.TenantID)
+	if err != nil {
+		return "", err
+	}
+
+	// the key is checked before the password, whose PasswordChecker may make
+	// network calls and whose hash is costly, and checked again when the
+	// verification is stored
+	storeKey := TenantKey(cfg.TenantID, key)
+	err = s.store.View(func(tx Tx) error {
+		return checkRegistrable(tx, storeKey)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = s.checkNewPassword(password)
+	if err != nil {
+		return "", err
+	}
+
+	ttl, link := verifySettings()
+	rec := verifyRecord{
+		Expires: time.Now().Add(ttl),
+		Grant: APIAccess{
+			Key:    key,
+			Tenant: cfg.TenantID,
+			Scopes: cfg.Scopes,
+		},
+	}
+
+	err = s.hashPassword(&rec.Grant, password)
+	if err != nil {
+		return "", err
+	}
+
+	j, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+
+	j, err = sealRecord(j)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt verification, %v", err)
+	}
+
+	token, hash, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.store.Update(func(tx Tx) error {
+		err := checkRegistrable(tx, storeKey)
+		if err != nil {
+			return err
+		}
+
+		p, err := newPendingRecord()
+		if err != nil {
+			return err
+		}
+
+		err = tx.Put(apiPendingUserStore, storeKey, p)
+		if err != nil {
+			return err
+		}
+
+		return tx.Put(apiVerifyStore, hash, j)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = s.sendMail(key, MailVerify, token, tokenLink(link, token))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Verify promotes the pending key a verification token was issued for into a
+// grant, and issues its token as Grant does. The verification token can't be
+// used again.
+func Verify(token string, cfg *Config) (*APIAccess, error) {
+	return std.Verify(token, cfg)
+}
+
+// Verify is the package Verify for s's Store
+func (s *Service) Verify(token string, cfg *Config) (*APIAccess, error) {
+	var apiAccess *APIAccess
+	var exp time.Time
+	var expired bool
+	hash := hashSecret(token)
+	err := s.store.Update(func(tx Tx) error {
+		j, err := tx.Get(apiVerifyStore, hash)
+		if err != nil {
+			return err
+		}
+
+		if j == nil {
+			return fmt.Errorf("%s", "invalid verification token")
+		}
+
+		err = tx.Delete(apiVerifyStore, hash)
+		if err != nil {
+			return err
+		}
+
+		j, err = openRecord(j)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt verification, %v", err)
+		}
+
+		var rec verifyRecord
+		err = json.Unmarshal(j, &rec)
+		if err != nil {
+			return fmt.Errorf("failed to decode verification, %v", err)
+		}
+
+		if time.Now().After(rec.Expires) {
+			expired = true
+			return nil
+		}
+
+		apiAccess = &rec.Grant
+		exp, err = s.activatePending(tx, apiAccess, cfg)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if expired {
+		return nil, fmt.Errorf("%s", "verification token has expired")
+	}
+
+	err = apiAccess.writeToken(cfg, exp)
+	if err != nil {
+		return nil, err
+	}
+
+	s.grantCreated(TenantKey(apiAccess.Tenant, apiAccess.Key), cfg.Request)
+	return apiAccess, nil
+}
+
+// tokenLink returns the link to base with token added as the "token" query
+// parameter, or token itself if base is empty
+func tokenLink(base, token string) string {
+	if base == "" {
+		return token
+	}
+
+	u, err := url.Parse(base)
+	if err != nil {
+		return base + "?token=" + url.QueryEscape(token)
+	}
+
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
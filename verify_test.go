@@ -0,0 +1,85 @@
+package access_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nilslice/access"
+	"github.com/nilslice/access/accesstest"
+)
+
+func TestVerifyAfterClearGrant(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	old := accesstest.Token(t, "again@example.com")
+	err := access.ClearGrant("again@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := access.PendingWithVerification("again@example.com", accesstest.Password, headerConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := access.Verify(token, headerConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !access.IsGranted(bearer(http.MethodGet, a.Token), http.Header{}) {
+		t.Error("token issued by Verify for a key cleared before rejected")
+	}
+
+	if access.IsGranted(bearer(http.MethodGet, old), http.Header{}) {
+		t.Error("token revoked by ClearGrant accepted for the verified grant")
+	}
+}
+
+func TestVerifyCreatesGrant(t *testing.T) {
+	accesstest.UseMemoryStore(t)
+
+	var granted []string
+	access.UseHooks(access.Hooks{OnGrant: func(key string) {
+		granted = append(granted, key)
+	}})
+	t.Cleanup(func() { access.UseHooks(access.Hooks{}) })
+
+	token, err := access.PendingWithVerification("verified@example.com", accesstest.Password, headerConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := access.Verify(token, headerConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(granted) != 1 || granted[0] != "verified@example.com" {
+		t.Errorf("OnGrant called for %v, want [verified@example.com]", granted)
+	}
+
+	identity, ok := access.VerifyToken(a.Token)
+	if !ok {
+		t.Fatal("token issued by Verify rejected")
+	}
+
+	amr := identity.Claims["amr"]
+	if list, _ := amr.([]interface{}); len(list) != 1 || list[0] != "pwd" {
+		t.Errorf("amr claim: got %v, want [pwd]", amr)
+	}
+
+	grant, err := access.GetGrant("verified@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if grant.LastLoginAt.IsZero() {
+		t.Error("LastLoginAt not set by Verify")
+	}
+
+	_, err = access.Verify(token, headerConfig(""))
+	if err == nil {
+		t.Error("verification token used twice")
+	}
+}
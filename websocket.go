@@ -0,0 +1,59 @@
+package access
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// WebSocketProtocol is the subprotocol named before the token when a browser
+// sends it in the Sec-WebSocket-Protocol header, as with
+// new WebSocket(url, ["access_token", token]). Servers must select it in their
+// handshake response for the browser to accept the connection.
+const WebSocketProtocol = "access_token"
+
+// UpgradeAuth validates the token of a WebSocket handshake request and returns
+// the identity it describes, before the connection is upgraded. The token is
+// read from the Sec-WebSocket-Protocol header, following WebSocketProtocol,
+// or else the "access_token" query parameter, since browsers can't set other
+// headers on WebSocket requests. Cookies aren't accepted, as browsers send
+// them with cross-site WebSocket requests.
+func UpgradeAuth(req *http.Request) (*Identity, error) {
+	return std.UpgradeAuth(req)
+}
+
+// UpgradeAuth is the package UpgradeAuth, verifying tokens with s's Signer
+func (s *Service) UpgradeAuth(req *http.Request) (*Identity, error) {
+	token := webSocketProtocolToken(req)
+	if token == "" {
+		token = req.URL.Query().Get("access_token")
+	}
+
+	if token == "" {
+		return nil, fmt.Errorf("%s", "no access token in WebSocket request")
+	}
+
+	identity, ok := s.VerifyToken(token)
+	if !ok {
+		return nil, fmt.Errorf("%s", "invalid access token in WebSocket request")
+	}
+
+	return identity, nil
+}
+
+func webSocketProtocolToken(req *http.Request) string {
+	var protocols []string
+	for _, h := range req.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(h, ",") {
+			protocols = append(protocols, strings.TrimSpace(p))
+		}
+	}
+
+	for i, p := range protocols {
+		if p == WebSocketProtocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,177 @@
+package access
+
+import "context"
+
+// GrantContext is Grant, with the transactions it runs bound to ctx
+func GrantContext(ctx context.Context, key, password string, cfg *Config) (*APIAccess, error) {
+	return std.GrantContext(ctx, key, password, cfg)
+}
+
+// GrantContext is s.Grant, with the transactions it runs bound to ctx
+func (s *Service) GrantContext(ctx context.Context, key, password string, cfg *Config) (*APIAccess, error) {
+	return s.withContext(ctx).Grant(key, password, cfg)
+}
+
+// LoginContext is Login, with the transactions it runs bound to ctx
+func LoginContext(ctx context.Context, key, password string, cfg *Config) (*APIAccess, error) {
+	return std.LoginContext(ctx, key, password, cfg)
+}
+
+// LoginContext is s.Login, with the transactions it runs bound to ctx
+func (s *Service) LoginContext(ctx context.Context, key, password string, cfg *Config) (*APIAccess, error) {
+	return s.withContext(ctx).Login(key, password, cfg)
+}
+
+// IssueTokenContext is IssueToken, with the transactions it runs bound to ctx
+func IssueTokenContext(ctx context.Context, key string, cfg *Config, amr ...string) (*APIAccess, error) {
+	return std.IssueTokenContext(ctx, key, cfg, amr...)
+}
+
+// IssueTokenContext is s.IssueToken, with the transactions it runs bound to
+// ctx
+func (s *Service) IssueTokenContext(ctx context.Context, key string, cfg *Config, amr ...string) (*APIAccess, error) {
+	return s.withContext(ctx).IssueToken(key, cfg, amr...)
+}
+
+// CheckContext is Check, with the transaction it runs bound to ctx
+func CheckContext(ctx context.Context, key string) error {
+	return std.CheckContext(ctx, key)
+}
+
+// CheckContext is s.Check, with the transaction it runs bound to ctx
+func (s *Service) CheckContext(ctx context.Context, key string) error {
+	return s.withContext(ctx).Check(key)
+}
+
+// PendingContext is Pending, with the transaction it runs bound to ctx
+func PendingContext(ctx context.Context, key string) error {
+	return std.PendingContext(ctx, key)
+}
+
+// PendingContext is s.Pending, with the transaction it runs bound to ctx
+func (s *Service) PendingContext(ctx context.Context, key string) error {
+	return s.withContext(ctx).Pending(key)
+}
+
+// ClearGrantContext is ClearGrant, with the transaction it runs bound to ctx
+func ClearGrantContext(ctx context.Context, key string) error {
+	return std.ClearGrantContext(ctx, key)
+}
+
+// ClearGrantContext is s.ClearGrant, with the transaction it runs bound to
+// ctx
+func (s *Service) ClearGrantContext(ctx context.Context, key string) error {
+	return s.withContext(ctx).ClearGrant(key)
+}
+
+// ViewContext runs fn within a read-only transaction of the package Store
+// bound to ctx
+func ViewContext(ctx context.Context, fn func(tx Tx) error) error {
+	return std.ViewContext(ctx, fn)
+}
+
+// ViewContext runs fn within a read-only transaction of s's Store bound to ctx
+func (s *Service) ViewContext(ctx context.Context, fn func(tx Tx) error) error {
+	return contextStore{ctx: ctx, store: s.store}.View(fn)
+}
+
+// UpdateContext runs fn within a read-write transaction of the package Store
+// bound to ctx
+func UpdateContext(ctx context.Context, fn func(tx Tx) error) error {
+	return std.UpdateContext(ctx, fn)
+}
+
+// UpdateContext runs fn within a read-write transaction of s's Store bound to
+// ctx
+func (s *Service) UpdateContext(ctx context.Context, fn func(tx Tx) error) error {
+	return contextStore{ctx: ctx, store: s.store}.Update(fn)
+}
+
+// withContext returns a copy of s whose transactions are bound to ctx
+func (s *Service) withContext(ctx context.Context) *Service {
+	c := *s
+	c.store = contextStore{ctx: ctx, store: s.store}
+	return &c
+}
+
+// contextStore binds the transactions of store to ctx, passing it on if store
+// is a ContextStore
+type contextStore struct {
+	ctx   context.Context
+	store Store
+}
+
+func (c contextStore) View(fn func(tx Tx) error) error {
+	err := c.ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	if cs, ok := c.store.(ContextStore); ok {
+		return cs.ViewContext(c.ctx, fn)
+	}
+
+	return c.store.View(func(tx Tx) error {
+		return fn(contextTx{ctx: c.ctx, tx: tx})
+	})
+}
+
+func (c contextStore) Update(fn func(tx Tx) error) error {
+	err := c.ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	if cs, ok := c.store.(ContextStore); ok {
+		return cs.UpdateContext(c.ctx, fn)
+	}
+
+	return c.store.Update(func(tx Tx) error {
+		return fn(contextTx{ctx: c.ctx, tx: tx})
+	})
+}
+
+// contextTx fails each operation once ctx is done, rolling back the
+// transaction
+type contextTx struct {
+	ctx context.Context
+	tx  Tx
+}
+
+func (t contextTx) Get(bucket, key string) ([]byte, error) {
+	err := t.ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return t.tx.Get(bucket, key)
+}
+
+func (t contextTx) Put(bucket, key string, value []byte) error {
+	err := t.ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	return t.tx.Put(bucket, key, value)
+}
+
+func (t contextTx) Delete(bucket, key string) error {
+	err := t.ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	return t.tx.Delete(bucket, key)
+}
+
+func (t contextTx) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	return t.tx.ForEach(bucket, func(key string, value []byte) error {
+		err := t.ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		return fn(key, value)
+	})
+}